@@ -0,0 +1,238 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/makkes/shorty/cache"
+	dbpkg "github.com/makkes/shorty/db"
+	"github.com/makkes/shorty/export"
+	"github.com/makkes/shorty/logging"
+	"github.com/makkes/shorty/portable"
+)
+
+// requireAdmin only passes requests to next that carry token as bearer token.
+func requireAdmin(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="shorty"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// exportRange parses the time range of an export. from defaults to the Unix
+// epoch, to to the current time.
+func exportRange(fromParam, toParam string) (time.Time, time.Time, error) {
+	from, to := time.Unix(0, 0).UTC(), time.Now().UTC()
+	if fromParam != "" {
+		var err error
+		if from, err = parseTime(fromParam); err != nil {
+			return from, to, fmt.Errorf("invalid 'from' parameter: %w", err)
+		}
+	}
+	if toParam != "" {
+		var err error
+		if to, err = parseTime(toParam); err != nil {
+			return from, to, fmt.Errorf("invalid 'to' parameter: %w", err)
+		}
+	}
+	if from.Before(time.Unix(0, 0)) {
+		return from, to, fmt.Errorf("'from' must not be before %s", time.Unix(0, 0).UTC().Format(time.DateOnly))
+	}
+	if !from.Before(to) {
+		return from, to, fmt.Errorf("'from' must be before 'to'")
+	}
+	return from, to, nil
+}
+
+// acceptsGzip tells whether the client accepts gzip-encoded responses.
+func acceptsGzip(r *http.Request) bool {
+	for _, enc := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(enc), ";")
+		if strings.EqualFold(strings.TrimSpace(name), "gzip") && strings.ReplaceAll(params, " ", "") != "q=0" {
+			return true
+		}
+	}
+	return false
+}
+
+// writeDownload serves the file written by write as attachment named
+// filename, compressing it if the client supports gzip.
+func writeDownload(w http.ResponseWriter, r *http.Request, filename, contentType string, write func(w io.Writer) error) {
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.Header().Add("Vary", "Accept-Encoding")
+	out := io.Writer(w)
+	if acceptsGzip(r) {
+		w.Header().Set("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(w)
+		defer func() {
+			if err := gz.Close(); err != nil {
+				logging.FromContext(r.Context()).Warn("failed finishing download", "file", filename, "error", err)
+			}
+		}()
+		out = gz
+	}
+	// the status code has been sent along with the first bytes, so errors
+	// can only be logged
+	if err := write(out); err != nil {
+		logging.FromContext(r.Context()).Warn("failed writing download", "file", filename, "error", err)
+	}
+}
+
+// exportHandler serves the export written by write, compressing it if the
+// client supports gzip.
+func exportHandler(name string, write func(ctx context.Context, w io.Writer, f export.Format) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		format := export.FormatCSV
+		if f := r.URL.Query().Get("format"); f != "" {
+			var err error
+			if format, err = export.ParseFormat(f); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		writeDownload(w, r, name+"."+string(format), format.ContentType(), func(w io.Writer) error {
+			return write(r.Context(), w, format)
+		})
+	}
+}
+
+// exportLinks serves all links.
+func exportLinks(db dbpkg.DB) http.HandlerFunc {
+	return exportHandler("links", func(ctx context.Context, w io.Writer, f export.Format) error {
+		return export.Links(ctx, w, f, db)
+	})
+}
+
+// exportClicks serves all clicks in the range given by the 'from' and 'to'
+// parameters.
+func exportClicks(exporter dbpkg.Exporter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		from, to, err := exportRange(r.URL.Query().Get("from"), r.URL.Query().Get("to"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		exportHandler("clicks", func(ctx context.Context, w io.Writer, f export.Format) error {
+			return export.Clicks(ctx, w, f, exporter, from, to)
+		})(w, r)
+	}
+}
+
+// webhookDeliveries serves the most recent webhook deliveries.
+func webhookDeliveries(store dbpkg.Webhooks) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit := 100
+		if l := r.URL.Query().Get("limit"); l != "" {
+			var err error
+			if limit, err = strconv.Atoi(l); err != nil || limit < 1 || limit > 1000 {
+				http.Error(w, "'limit' must be a number between 1 and 1000", http.StatusBadRequest)
+				return
+			}
+		}
+		ctx, cancel := dbContext(r)
+		defer cancel()
+		deliveries, err := store.Deliveries(ctx, limit)
+		if err != nil {
+			logging.FromContext(r.Context()).Error("failed retrieving webhook deliveries", "error", err)
+			w.WriteHeader(dbErrorStatus(err))
+			return
+		}
+		writeJSON(w, r, deliveries)
+	}
+}
+
+// cacheStats serves the counters of the link cache.
+func cacheStats(c *cache.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, r, c.Stats())
+	}
+}
+
+// backup serves a snapshot of the database.
+func backup(backuper dbpkg.Backuper) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filename := fmt.Sprintf("shorty-%s.tar", time.Now().UTC().Format("20060102T150405Z"))
+		writeDownload(w, r, filename, "application/x-tar", func(w io.Writer) error {
+			return backuper.Backup(r.Context(), w)
+		})
+	}
+}
+
+// dumpLinks serves all links in the portable JSON format.
+func dumpLinks(db dbpkg.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeDownload(w, r, "links.json", "application/json", func(w io.Writer) error {
+			return portable.Export(r.Context(), w, db)
+		})
+	}
+}
+
+// importResponse is the result of an import along with the error that
+// stopped it, if any.
+type importResponse struct {
+	portable.Result
+	Error string `json:"error,omitempty"`
+}
+
+// importLinks imports the links sent as request body, by default in the
+// portable JSON format.
+func importLinks(db dbpkg.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		var opts portable.Options
+		if f := query.Get("format"); f != "" {
+			var err error
+			if opts.Format, err = portable.ParseFormat(f); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		if c := query.Get("onConflict"); c != "" {
+			var err error
+			if opts.OnConflict, err = portable.ParseOnConflict(c); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		if d := query.Get("dryRun"); d != "" {
+			var err error
+			if opts.DryRun, err = strconv.ParseBool(d); err != nil {
+				http.Error(w, fmt.Sprintf("invalid 'dryRun' parameter: %v", err), http.StatusBadRequest)
+				return
+			}
+		}
+
+		res, err := portable.Import(r.Context(), r.Body, db, opts)
+		body := importResponse{Result: res}
+		if err != nil {
+			body.Error = err.Error()
+			// writeJSON can't set the content type after the status code
+			w.Header().Set("Content-Type", "application/json")
+			switch {
+			case errors.Is(err, dbpkg.ErrKeyCollision{}):
+				w.WriteHeader(http.StatusConflict)
+			case errors.As(err, new(portable.DBError)):
+				logging.FromContext(r.Context()).Error("failed importing links", "error", err)
+				w.WriteHeader(dbErrorStatus(err))
+			default:
+				w.WriteHeader(http.StatusBadRequest)
+			}
+		}
+		writeJSON(w, r, body)
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/makkes/shorty/assert"
+	"github.com/makkes/shorty/cache"
+	"github.com/makkes/shorty/db"
+)
+
+type TestExporter struct {
+	from, to time.Time
+}
+
+func (te *TestExporter) ForEachClick(ctx context.Context, from, to time.Time, fn func(db.Click) error) error {
+	te.from, te.to = from, to
+	return fn(db.Click{Key: []byte("abc"), Time: from})
+}
+
+func setupExport(url string, te *TestExporter, headers ...string) *httptest.ResponseRecorder {
+	mux := http.NewServeMux()
+	mux.Handle("GET /admin/export/links", requireAdmin("s3cr3t", exportLinks(&TestDB{key: []byte("abc"), url: []byte("http://example.org")})))
+	mux.Handle("GET /admin/export/clicks", requireAdmin("s3cr3t", exportClicks(te)))
+	req, _ := http.NewRequest("GET", url, nil)
+	for idx := 0; idx < len(headers); idx += 2 {
+		req.Header.Set(headers[idx], headers[idx+1])
+	}
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	return w
+}
+
+func TestExportRequiresAdminToken(t *testing.T) {
+	assert := assert.NewAssert(t)
+	w := setupExport("/admin/export/links", &TestExporter{})
+	assert.Equal(w.Code, http.StatusUnauthorized, "unexpected status code without token")
+	w = setupExport("/admin/export/links", &TestExporter{}, "Authorization", "Bearer wrong")
+	assert.Equal(w.Code, http.StatusUnauthorized, "unexpected status code with wrong token")
+}
+
+func TestExportLinks(t *testing.T) {
+	w := setupExport("/admin/export/links", &TestExporter{}, "Authorization", "Bearer s3cr3t")
+
+	assert := assert.NewAssert(t)
+	assert.Equal(w.Code, http.StatusOK, "unexpected status code")
+	assert.Equal(w.Header().Get("Content-Type"), "text/csv; charset=utf-8", "unexpected content type")
+	assert.Equal(w.Header().Get("Content-Disposition"), `attachment; filename="links.csv"`, "unexpected content disposition")
+	assert.Equal(w.Body.String(), "key,url\nabc,http://example.org\n", "unexpected body")
+}
+
+func TestExportClicksCompressed(t *testing.T) {
+	te := &TestExporter{}
+	w := setupExport("/admin/export/clicks?format=jsonl&from=2024-03-01&to=2024-03-02", te,
+		"Authorization", "Bearer s3cr3t", "Accept-Encoding", "gzip, deflate")
+
+	assert := assert.NewAssert(t)
+	assert.Equal(w.Code, http.StatusOK, "unexpected status code")
+	assert.Equal(w.Header().Get("Content-Encoding"), "gzip", "unexpected content encoding")
+	assert.Equal(te.from.Equal(time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)), true, "unexpected from")
+	assert.Equal(te.to.Equal(time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC)), true, "unexpected to")
+	gz, err := gzip.NewReader(w.Body)
+	assert.Nil(err, "unexpected error")
+	body, err := io.ReadAll(gz)
+	assert.Nil(err, "unexpected error")
+	assert.Match(`^\{"key":"abc","time":"2024-03-01T00:00:00Z",.*\}\n$`, string(body), "unexpected body")
+}
+
+func TestExportValidatesParameters(t *testing.T) {
+	for _, url := range []string{
+		"/admin/export/links?format=xml",
+		"/admin/export/clicks?from=yesterday",
+		"/admin/export/clicks?from=2024-03-02&to=2024-03-01",
+		"/admin/export/clicks?from=1969-12-31",
+	} {
+		w := setupExport(url, &TestExporter{}, "Authorization", "Bearer s3cr3t")
+		assert := assert.NewAssert(t)
+		assert.Equal(w.Code, http.StatusBadRequest, "unexpected status code for "+url)
+	}
+}
+
+type TestWebhooks struct {
+	deliveries []db.Delivery
+}
+
+func (tw *TestWebhooks) SaveDelivery(ctx context.Context, d *db.Delivery) error {
+	return nil
+}
+
+func (tw *TestWebhooks) DueDeliveries(ctx context.Context, now time.Time, limit int) ([]db.Delivery, error) {
+	return nil, nil
+}
+
+func (tw *TestWebhooks) Deliveries(ctx context.Context, limit int) ([]db.Delivery, error) {
+	return tw.deliveries[:min(limit, len(tw.deliveries))], nil
+}
+
+func TestWebhookDeliveries(t *testing.T) {
+	tw := &TestWebhooks{deliveries: []db.Delivery{
+		{ID: 2, Endpoint: "chat", Event: "link.created", Payload: []byte(`{"type":"link.created"}`), Status: db.DeliveryPending},
+		{ID: 1, Endpoint: "chat", Event: "link.created", Payload: []byte(`{"type":"link.created"}`), Status: db.DeliverySucceeded},
+	}}
+	handler := requireAdmin("s3cr3t", webhookDeliveries(tw))
+
+	assert := assert.NewAssert(t)
+	req, _ := http.NewRequest("GET", "/admin/webhooks/deliveries?limit=1", nil)
+	req.Header.Set("Authorization", "Bearer s3cr3t")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(w.Code, http.StatusOK, "unexpected status code")
+	assert.Match(`^\[\{"id":2,"endpoint":"chat","event":"link.created","payload":\{"type":"link.created"\},"status":"pending",.*\}\]\n$`, w.Body.String(), "unexpected body")
+
+	req, _ = http.NewRequest("GET", "/admin/webhooks/deliveries?limit=0", nil)
+	req.Header.Set("Authorization", "Bearer s3cr3t")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(w.Code, http.StatusBadRequest, "unexpected status code for invalid limit")
+}
+
+func TestCacheStats(t *testing.T) {
+	c := cache.New(&TestDB{key: []byte("abc"), url: []byte("http://example.org")}, cache.Options{Size: 10, TTL: time.Minute})
+	c.GetURL(t.Context(), []byte("abc"))
+	c.GetURL(t.Context(), []byte("abc"))
+	handler := requireAdmin("s3cr3t", cacheStats(c))
+
+	assert := assert.NewAssert(t)
+	req, _ := http.NewRequest("GET", "/admin/cache", nil)
+	req.Header.Set("Authorization", "Bearer s3cr3t")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	assert.Equal(w.Code, http.StatusOK, "unexpected status code")
+	assert.Equal(w.Body.String(), `{"hits":1,"negativeHits":0,"misses":1,"shared":0,"evictions":0,"entries":1,"size":10}`+"\n", "unexpected body")
+}
+
+type TestBackuper struct{}
+
+func (TestBackuper) Backup(ctx context.Context, w io.Writer) error {
+	_, err := io.WriteString(w, "snapshot")
+	return err
+}
+
+func TestBackup(t *testing.T) {
+	handler := requireAdmin("s3cr3t", backup(TestBackuper{}))
+	req, _ := http.NewRequest("GET", "/admin/backup", nil)
+	req.Header.Set("Authorization", "Bearer s3cr3t")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	assert := assert.NewAssert(t)
+	assert.Equal(w.Code, http.StatusOK, "unexpected status code")
+	assert.Equal(w.Header().Get("Content-Type"), "application/x-tar", "unexpected content type")
+	assert.Match(`^attachment; filename="shorty-[0-9]{8}T[0-9]{6}Z\.tar"$`, w.Header().Get("Content-Disposition"), "unexpected content disposition")
+	assert.Equal(w.Body.String(), "snapshot", "unexpected body")
+}
+
+func TestImportLinks(t *testing.T) {
+	tdb := &TestDB{key: []byte("abc"), url: []byte("http://example.org")}
+	handler := requireAdmin("s3cr3t", importLinks(tdb))
+	post := func(url, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", url, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer s3cr3t")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	assert := assert.NewAssert(t)
+	w := post("/admin/links/import?dryRun=true", `{"version":1,"links":[{"key":"def","url":"http://example.com"}]}`)
+	assert.Equal(w.Code, http.StatusOK, "unexpected status code")
+	assert.Equal(w.Body.String(), `{"created":1,"overwritten":0,"skipped":0,"invalid":0,"dryRun":true}`+"\n", "unexpected body")
+	assert.Equal(string(tdb.key), "abc", "dry run has changed the database")
+
+	w = post("/admin/links/import", `{"version":1,"links":[{"key":"abc","url":"http://example.com"}]}`)
+	assert.Equal(w.Code, http.StatusConflict, "unexpected status code on conflict")
+	assert.Equal(w.Header().Get("Content-Type"), "application/json", "unexpected content type")
+	assert.Match(`"error":"link \\"abc\\": the key \\"abc\\" is already used"`, w.Body.String(), "unexpected body on conflict")
+
+	w = post("/admin/links/import?onConflict=overwrite", `{"version":1,"links":[{"key":"abc","url":"http://example.com"}]}`)
+	assert.Equal(w.Code, http.StatusOK, "unexpected status code")
+	assert.Equal(string(tdb.url), "http://example.com", "link has not been overwritten")
+
+	w = post("/admin/links/import?format=csv", "abc,http://example.org/other\nx,ftp://example.org\n")
+	assert.Equal(w.Code, http.StatusOK, "unexpected status code for csv import")
+	assert.Match(`"skipped":1,"invalid":1,"dryRun":false,"problems":\[\{"record":1,"key":"abc",.*\{"record":2,"key":"x",`, w.Body.String(), "unexpected problems")
+
+	w = post("/admin/links/import", `{"version":3}`)
+	assert.Equal(w.Code, http.StatusBadRequest, "unexpected status code for unsupported version")
+	w = post("/admin/links/import?onConflict=merge", `{}`)
+	assert.Equal(w.Code, http.StatusBadRequest, "unexpected status code for unknown conflict strategy")
+	w = post("/admin/links/import?format=tinyurl", `{}`)
+	assert.Equal(w.Code, http.StatusBadRequest, "unexpected status code for unknown format")
+
+	tdb.getErr = errors.New("disk on fire")
+	w = post("/admin/links/import", `{"version":1,"links":[{"key":"ghi","url":"http://example.com"}]}`)
+	assert.Equal(w.Code, http.StatusInternalServerError, "unexpected status code for database error")
+	tdb.getErr = context.DeadlineExceeded
+	w = post("/admin/links/import", `{"version":1,"links":[{"key":"ghi","url":"http://example.com"}]}`)
+	assert.Equal(w.Code, http.StatusServiceUnavailable, "unexpected status code for database timeout")
+}
@@ -0,0 +1,106 @@
+package analytics
+
+import (
+	"bufio"
+	"bytes"
+	_ "embed"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Categories of automated clients besides those defined in the rules.
+const (
+	BotHead     = "head"
+	BotPrefetch = "prefetch"
+)
+
+//go:embed bots.txt
+var defaultBotRules []byte
+
+// A BotClassifier tells automated clients such as link unfurlers, security
+// scanners and crawlers apart from humans.
+type BotClassifier struct {
+	rules []rule
+}
+
+// DefaultBotClassifier returns a BotClassifier using the built-in rules.
+func DefaultBotClassifier() *BotClassifier {
+	bc, err := ParseBotRules(bytes.NewReader(defaultBotRules))
+	if err != nil {
+		panic(fmt.Sprintf("built-in bot rules are invalid: %v", err))
+	}
+	return bc
+}
+
+// LoadBotClassifier returns a BotClassifier using the rules from the file at
+// path, replacing the built-in rules.
+func LoadBotClassifier(path string) (*BotClassifier, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed opening bot rules: %w", err)
+	}
+	defer f.Close()
+	bc, err := ParseBotRules(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed parsing bot rules %s: %w", path, err)
+	}
+	return bc, nil
+}
+
+// ParseBotRules reads rules from r. Each line consists of a category and a
+// case-insensitive substring of the User-Agent header, separated by
+// whitespace. Empty lines and lines starting with # are ignored.
+func ParseBotRules(r io.Reader) (*BotClassifier, error) {
+	bc := &BotClassifier{}
+	scanner := bufio.NewScanner(r)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("line %d: expected category and pattern", lineNo)
+		}
+		category := fields[0]
+		if category == BotHead || category == BotPrefetch {
+			return nil, fmt.Errorf("line %d: category %q is reserved", lineNo, category)
+		}
+		bc.rules = append(bc.rules, rule{
+			token: strings.ToLower(strings.Join(fields[1:], " ")),
+			name:  category,
+		})
+	}
+	return bc, scanner.Err()
+}
+
+// isPrefetch tells whether r has been sent speculatively by a browser rather
+// than due to the user following a link.
+func isPrefetch(r *http.Request) bool {
+	for _, name := range []string{"Sec-Purpose", "Purpose", "X-Purpose", "X-Moz"} {
+		value := strings.ToLower(r.Header.Get(name))
+		if strings.Contains(value, "prefetch") || strings.Contains(value, "preview") {
+			return true
+		}
+	}
+	return false
+}
+
+// Classify returns the category of the automated client that sent r or the
+// empty string if r likely originates from a human. HEAD and prefetch
+// requests are classified as BotHead and BotPrefetch, respectively.
+func (bc *BotClassifier) Classify(r *http.Request) string {
+	if category := match(strings.ToLower(r.UserAgent()), bc.rules); category != "" {
+		return category
+	}
+	if r.Method == http.MethodHead {
+		return BotHead
+	}
+	if isPrefetch(r) {
+		return BotPrefetch
+	}
+	return ""
+}
@@ -0,0 +1,74 @@
+package analytics_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/makkes/shorty/analytics"
+	"github.com/makkes/shorty/assert"
+)
+
+func TestDefaultBotClassifier(t *testing.T) {
+	bots := analytics.DefaultBotClassifier()
+	for _, tc := range []struct {
+		method  string
+		ua      string
+		headers map[string]string
+		want    string
+	}{
+		{ua: "Mozilla/5.0 (X11; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0", want: ""},
+		{ua: "Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)", want: "unfurler"},
+		{ua: "facebookexternalhit/1.1 (+http://www.facebook.com/externalhit_uatext.php)", want: "unfurler"},
+		{ua: "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/51.0.2704.79 Safari/537.36 Edge/14.14393 MSTeamsBot", want: "unfurler"},
+		{ua: "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)", want: "crawler"},
+		{ua: "python-requests/2.31.0", want: "bot"},
+		{method: http.MethodHead, ua: "Mozilla/5.0 Firefox/121.0", want: analytics.BotHead},
+		{ua: "Mozilla/5.0 Chrome/120.0", headers: map[string]string{"Sec-Purpose": "prefetch;prerender"}, want: analytics.BotPrefetch},
+		{ua: "Mozilla/5.0 Safari/605.1.15", headers: map[string]string{"X-Purpose": "preview"}, want: analytics.BotPrefetch},
+	} {
+		method := tc.method
+		if method == "" {
+			method = http.MethodGet
+		}
+		req := httptest.NewRequest(method, "/abc", nil)
+		req.Header.Set("User-Agent", tc.ua)
+		for name, value := range tc.headers {
+			req.Header.Set(name, value)
+		}
+		assert := assert.NewAssert(t)
+		assert.Equal(bots.Classify(req), tc.want, "unexpected category for "+tc.ua)
+	}
+}
+
+func TestLoadBotClassifier(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bots.txt")
+	rules := "# custom rules\nmonitor  uptime robot\n\nbot  curl/\n"
+	if err := os.WriteFile(path, []byte(rules), 0o600); err != nil {
+		t.Fatalf("failed writing rules: %v", err)
+	}
+	bots, err := analytics.LoadBotClassifier(path)
+
+	assert := assert.NewAssert(t)
+	assert.Nil(err, "unexpected error")
+	for ua, want := range map[string]string{
+		"Mozilla/5.0+(compatible; Uptime Robot/2.0)": "monitor",
+		"curl/8.5.0":   "bot",
+		"Slackbot 1.0": "",
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/abc", nil)
+		req.Header.Set("User-Agent", ua)
+		assert.Equal(bots.Classify(req), want, "unexpected category for "+ua)
+	}
+}
+
+func TestParseBotRulesRejectsInvalidRules(t *testing.T) {
+	for _, rules := range []string{"unfurler\n", "head  curl/\n"} {
+		_, err := analytics.ParseBotRules(strings.NewReader(rules))
+		assert := assert.NewAssert(t)
+		assert.NotNil(err, "expected error for "+rules)
+	}
+}
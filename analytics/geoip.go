@@ -0,0 +1,50 @@
+package analytics
+
+import (
+	"fmt"
+	"net/netip"
+
+	"github.com/oschwald/maxminddb-golang/v2"
+)
+
+// A CountryLookup maps IP addresses to countries.
+type CountryLookup interface {
+	// Country returns the ISO 3166-1 alpha-2 code of the country addr is
+	// located in or the empty string if it is unknown.
+	Country(addr netip.Addr) string
+}
+
+// GeoIP looks up countries in a local MaxMind DB file such as GeoLite2
+// Country or DB-IP Lite Country.
+type GeoIP struct {
+	reader *maxminddb.Reader
+}
+
+var _ CountryLookup = &GeoIP{}
+
+// OpenGeoIP opens the MaxMind DB file at path.
+func OpenGeoIP(path string) (*GeoIP, error) {
+	reader, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed opening GeoIP database %s: %w", path, err)
+	}
+	return &GeoIP{reader: reader}, nil
+}
+
+// Country implements CountryLookup.
+func (g *GeoIP) Country(addr netip.Addr) string {
+	var record struct {
+		Country struct {
+			ISOCode string `maxminddb:"iso_code"`
+		} `maxminddb:"country"`
+	}
+	if err := g.reader.Lookup(addr.Unmap()).Decode(&record); err != nil {
+		return ""
+	}
+	return record.Country.ISOCode
+}
+
+// Close closes the underlying database file.
+func (g *GeoIP) Close() error {
+	return g.reader.Close()
+}
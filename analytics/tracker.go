@@ -0,0 +1,115 @@
+// Package analytics turns visits of short URLs into click events, enriched
+// with coarse information about the visitor, and hands them to a backend
+// implementing db.Analytics.
+package analytics
+
+import (
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/makkes/shorty/clientip"
+	dbpkg "github.com/makkes/shorty/db"
+	"github.com/makkes/shorty/logging"
+	"github.com/makkes/shorty/tracing"
+)
+
+var tracer = otel.Tracer("github.com/makkes/shorty/analytics")
+
+// A Tracker records clicks on short URLs.
+type Tracker struct {
+	analytics dbpkg.Analytics
+	countries CountryLookup
+	bots      *BotClassifier
+	visitors  visitorHasher
+	clock     func() time.Time
+}
+
+// NewTracker returns a Tracker that records clicks in analytics. countries
+// may be nil in which case the country of clicks is not determined. bots may
+// be nil in which case the built-in rules are used.
+func NewTracker(analytics dbpkg.Analytics, countries CountryLookup, bots *BotClassifier) *Tracker {
+	if bots == nil {
+		bots = DefaultBotClassifier()
+	}
+	return &Tracker{
+		analytics: analytics,
+		countries: countries,
+		bots:      bots,
+		clock:     time.Now,
+	}
+}
+
+// UseVisitorSalts makes t take the daily salts of visitor identifiers from
+// salts, e.g. the backend shared by all instances, instead of generating
+// them in memory.
+func (t *Tracker) UseVisitorSalts(salts dbpkg.VisitorSalts) {
+	t.visitors.Lock()
+	defer t.visitors.Unlock()
+	t.visitors.salts = salts
+	t.visitors.salt = nil
+}
+
+// Click returns the click event for the visit of the short URL key by r. The
+// visitor is only identified if it is a human that didn't opt out of
+// tracking. Failures of getting the salt are logged and leave the visitor
+// unidentified.
+func (t *Tracker) Click(r *http.Request, key []byte) dbpkg.Click {
+	now := t.clock()
+	ip := clientip.FromRequest(r)
+	ua := ParseUserAgent(r.UserAgent())
+	click := dbpkg.Click{
+		Key:      key,
+		Time:     now.UTC(),
+		Referrer: referrerHost(r.Referer()),
+		Browser:  ua.Browser,
+		OS:       ua.OS,
+		Device:   ua.Device,
+		Bot:      t.bots.Classify(r),
+	}
+	if t.countries != nil {
+		if addr, err := netip.ParseAddr(ip); err == nil {
+			click.Country = t.countries.Country(addr)
+		}
+	}
+	if click.Bot == "" && !optedOut(r) {
+		visitor, err := t.visitors.hash(r.Context(), now, ip, r.UserAgent())
+		if err != nil {
+			logging.FromContext(r.Context()).Error("failed identifying visitor", "error", err)
+		}
+		click.Visitor = visitor
+	}
+	return click
+}
+
+// Track records the visit of the short URL key by r and returns the recorded
+// click. Failures are logged but don't affect the request.
+func (t *Tracker) Track(r *http.Request, key []byte) dbpkg.Click {
+	ctx, span := tracer.Start(r.Context(), "analytics.Track")
+	click := t.Click(r, key)
+	span.SetAttributes(attribute.String("click.bot", click.Bot), attribute.String("click.country", click.Country))
+	err := t.analytics.RecordClick(ctx, click)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("failed recording click", "key", string(key), "error", err)
+	}
+	tracing.End(span, err)
+	return click
+}
+
+// referrerHost returns the lower-cased host name of the referring URL, which
+// is all we keep of it.
+func referrerHost(referrer string) string {
+	if referrer == "" {
+		return ""
+	}
+	u, err := url.Parse(referrer)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
@@ -0,0 +1,112 @@
+package analytics_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/makkes/shorty/analytics"
+	"github.com/makkes/shorty/assert"
+	dbpkg "github.com/makkes/shorty/db"
+)
+
+type recorder struct {
+	clicks []dbpkg.Click
+}
+
+func (r *recorder) RecordClick(ctx context.Context, click dbpkg.Click) error {
+	r.clicks = append(r.clicks, click)
+	return nil
+}
+
+func (r *recorder) GetClickStats(ctx context.Context, key []byte, from, to time.Time, granularity dbpkg.Granularity) ([]dbpkg.ClickStats, error) {
+	return nil, nil
+}
+
+func (r *recorder) GetUniqueVisitors(ctx context.Context, key []byte, from, to time.Time) (uint64, error) {
+	return 0, nil
+}
+
+type countries map[netip.Addr]string
+
+func (c countries) Country(addr netip.Addr) string {
+	return c[addr]
+}
+
+func TestTrackerRecordsEnrichedClicks(t *testing.T) {
+	rec := &recorder{}
+	tracker := analytics.NewTracker(rec, countries{netip.MustParseAddr("1.2.3.4"): "DE"}, nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/abc", nil)
+	req.RemoteAddr = "1.2.3.4:5678"
+	req.Header.Set("Referer", "https://News.Example.com/some/article?id=42")
+	req.Header.Set("User-Agent", "Mozilla/5.0 (X11; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0")
+	before := time.Now()
+	tracker.Track(req, []byte("abc"))
+
+	assert := assert.NewAssert(t)
+	assert.Equal(len(rec.clicks), 1, "unexpected number of clicks")
+	click := rec.clicks[0]
+	assert.Equal(string(click.Key), "abc", "unexpected key")
+	assert.Equal(click.Referrer, "news.example.com", "unexpected referrer")
+	assert.Equal(click.Browser, "Firefox", "unexpected browser")
+	assert.Equal(click.OS, "Linux", "unexpected OS")
+	assert.Equal(click.Device, analytics.DeviceDesktop, "unexpected device")
+	assert.Equal(click.Country, "DE", "unexpected country")
+	assert.Equal(click.Time.Before(before), false, "unexpected time")
+}
+
+func TestTrackerWorksWithoutCountryLookup(t *testing.T) {
+	rec := &recorder{}
+	req := httptest.NewRequest(http.MethodGet, "/abc", nil)
+	analytics.NewTracker(rec, nil, nil).Track(req, []byte("abc"))
+
+	assert := assert.NewAssert(t)
+	assert.Equal(len(rec.clicks), 1, "unexpected number of clicks")
+	assert.Equal(rec.clicks[0].Country, "", "unexpected country")
+	assert.Equal(rec.clicks[0].Referrer, "", "unexpected referrer")
+}
+
+func TestTrackerIdentifiesVisitorsAnonymously(t *testing.T) {
+	rec := &recorder{}
+	tracker := analytics.NewTracker(rec, nil, nil)
+
+	newRequest := func(remoteAddr string, headers ...string) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "/abc", nil)
+		req.RemoteAddr = remoteAddr
+		for idx := 0; idx < len(headers); idx += 2 {
+			req.Header.Set(headers[idx], headers[idx+1])
+		}
+		return req
+	}
+	tracker.Track(newRequest("1.2.3.4:1"), []byte("abc"))
+	tracker.Track(newRequest("1.2.3.4:2"), []byte("abc"))
+	tracker.Track(newRequest("4.3.2.1:1"), []byte("abc"))
+	tracker.Track(newRequest("1.2.3.4:1", "DNT", "1"), []byte("abc"))
+	tracker.Track(newRequest("1.2.3.4:1", "Sec-GPC", "1"), []byte("abc"))
+
+	assert := assert.NewAssert(t)
+	assert.Equal(len(rec.clicks), 5, "unexpected number of clicks")
+	assert.Equal(rec.clicks[0].Visitor != 0, true, "visitor has not been identified")
+	assert.Equal(rec.clicks[0].Visitor, rec.clicks[1].Visitor, "same visitor has different identifiers")
+	assert.Equal(rec.clicks[0].Visitor != rec.clicks[2].Visitor, true, "different visitors have the same identifier")
+	assert.Equal(rec.clicks[3].Visitor, uint64(0), "DNT has not been honored")
+	assert.Equal(rec.clicks[4].Visitor, uint64(0), "Sec-GPC has not been honored")
+}
+
+func TestTrackerClassifiesBots(t *testing.T) {
+	rec := &recorder{}
+	tracker := analytics.NewTracker(rec, nil, nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/abc", nil)
+	req.Header.Set("User-Agent", "Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)")
+	tracker.Track(req, []byte("abc"))
+
+	assert := assert.NewAssert(t)
+	assert.Equal(len(rec.clicks), 1, "unexpected number of clicks")
+	assert.Equal(rec.clicks[0].Bot, "unfurler", "unexpected bot category")
+	assert.Equal(rec.clicks[0].Visitor, uint64(0), "bot has been identified as visitor")
+}
@@ -0,0 +1,91 @@
+package analytics
+
+import "strings"
+
+// Device classes as reported in UserAgent.Device.
+const (
+	DeviceDesktop = "desktop"
+	DeviceMobile  = "mobile"
+	DeviceTablet  = "tablet"
+)
+
+// UserAgent holds the coarse properties of a client derived from its
+// User-Agent header. Unknown properties are empty.
+type UserAgent struct {
+	Browser string
+	OS      string
+	Device  string
+}
+
+// rule maps a User-Agent token to a name. Rules are evaluated in order, so
+// more specific tokens must come first (e.g. Edge sends "Chrome" and
+// "Safari", too).
+type rule struct {
+	token string
+	name  string
+}
+
+var browserRules = []rule{
+	{"edg/", "Edge"},
+	{"edge/", "Edge"},
+	{"opr/", "Opera"},
+	{"opera", "Opera"},
+	{"samsungbrowser/", "Samsung Internet"},
+	{"yabrowser/", "Yandex"},
+	{"vivaldi/", "Vivaldi"},
+	{"firefox/", "Firefox"},
+	{"fxios/", "Firefox"},
+	{"crios/", "Chrome"},
+	{"chromium/", "Chromium"},
+	{"chrome/", "Chrome"},
+	{"msie ", "Internet Explorer"},
+	{"trident/", "Internet Explorer"},
+	{"safari/", "Safari"},
+	{"curl/", "curl"},
+	{"wget/", "Wget"},
+}
+
+var osRules = []rule{
+	{"windows phone", "Windows Phone"},
+	{"windows", "Windows"},
+	{"iphone", "iOS"},
+	{"ipad", "iOS"},
+	{"ipod", "iOS"},
+	{"android", "Android"},
+	{"cros", "ChromeOS"},
+	{"mac os x", "macOS"},
+	{"macintosh", "macOS"},
+	{"linux", "Linux"},
+	{"freebsd", "FreeBSD"},
+}
+
+func match(ua string, rules []rule) string {
+	for _, r := range rules {
+		if strings.Contains(ua, r.token) {
+			return r.name
+		}
+	}
+	return ""
+}
+
+// ParseUserAgent derives browser, operating system and device class from the
+// User-Agent header value ua using a small set of heuristics.
+func ParseUserAgent(ua string) UserAgent {
+	lower := strings.ToLower(ua)
+	res := UserAgent{
+		Browser: match(lower, browserRules),
+		OS:      match(lower, osRules),
+	}
+
+	switch {
+	case strings.Contains(lower, "ipad") || strings.Contains(lower, "tablet") ||
+		(strings.Contains(lower, "android") && !strings.Contains(lower, "mobile")):
+		res.Device = DeviceTablet
+	case strings.Contains(lower, "mobi") || strings.Contains(lower, "iphone") || strings.Contains(lower, "ipod"):
+		res.Device = DeviceMobile
+	case res.OS == "Windows" || res.OS == "macOS" || res.OS == "Linux" || res.OS == "ChromeOS" || res.OS == "FreeBSD":
+		res.Device = DeviceDesktop
+	}
+
+	return res
+}
@@ -0,0 +1,61 @@
+package analytics_test
+
+import (
+	"testing"
+
+	"github.com/makkes/shorty/analytics"
+	"github.com/makkes/shorty/assert"
+)
+
+func TestParseUserAgent(t *testing.T) {
+	tests := []struct {
+		ua       string
+		expected analytics.UserAgent
+	}{
+		{
+			ua:       "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
+			expected: analytics.UserAgent{Browser: "Chrome", OS: "Windows", Device: analytics.DeviceDesktop},
+		},
+		{
+			ua:       "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36 Edg/120.0.2210.91",
+			expected: analytics.UserAgent{Browser: "Edge", OS: "Windows", Device: analytics.DeviceDesktop},
+		},
+		{
+			ua:       "Mozilla/5.0 (Macintosh; Intel Mac OS X 14_2) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.2 Safari/605.1.15",
+			expected: analytics.UserAgent{Browser: "Safari", OS: "macOS", Device: analytics.DeviceDesktop},
+		},
+		{
+			ua:       "Mozilla/5.0 (iPhone; CPU iPhone OS 17_2 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.2 Mobile/15E148 Safari/604.1",
+			expected: analytics.UserAgent{Browser: "Safari", OS: "iOS", Device: analytics.DeviceMobile},
+		},
+		{
+			ua:       "Mozilla/5.0 (iPad; CPU OS 17_2 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) CriOS/120.0.6099.119 Mobile/15E148 Safari/604.1",
+			expected: analytics.UserAgent{Browser: "Chrome", OS: "iOS", Device: analytics.DeviceTablet},
+		},
+		{
+			ua:       "Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.6099.144 Mobile Safari/537.36",
+			expected: analytics.UserAgent{Browser: "Chrome", OS: "Android", Device: analytics.DeviceMobile},
+		},
+		{
+			ua:       "Mozilla/5.0 (Linux; Android 13; SM-X700) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.6099.144 Safari/537.36",
+			expected: analytics.UserAgent{Browser: "Chrome", OS: "Android", Device: analytics.DeviceTablet},
+		},
+		{
+			ua:       "Mozilla/5.0 (X11; Ubuntu; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0",
+			expected: analytics.UserAgent{Browser: "Firefox", OS: "Linux", Device: analytics.DeviceDesktop},
+		},
+		{
+			ua:       "curl/8.5.0",
+			expected: analytics.UserAgent{Browser: "curl"},
+		},
+		{
+			ua:       "",
+			expected: analytics.UserAgent{},
+		},
+	}
+
+	for _, tt := range tests {
+		assert := assert.NewAssert(t)
+		assert.Equal(analytics.ParseUserAgent(tt.ua), tt.expected, "unexpected result for "+tt.ua)
+	}
+}
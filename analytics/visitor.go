@@ -0,0 +1,75 @@
+package analytics
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"net/http"
+	"sync"
+	"time"
+
+	dbpkg "github.com/makkes/shorty/db"
+)
+
+// visitorHasher derives anonymous visitor identifiers from properties of the
+// request. Identifiers are salted with a random salt that is replaced every
+// day, so identifiers can neither be reversed nor linked across days. The
+// salts are taken from salts if set. Otherwise they are only kept in memory,
+// so that visitors are counted again after a restart and by every instance.
+type visitorHasher struct {
+	sync.Mutex
+
+	salts dbpkg.VisitorSalts
+	day   time.Time
+	salt  []byte
+}
+
+// hash returns the identifier of the visitor with the given IP and user agent
+// at time now. It never returns zero.
+func (vh *visitorHasher) hash(ctx context.Context, now time.Time, ip string, userAgent string) (uint64, error) {
+	salt, err := vh.saltOf(ctx, now)
+	if err != nil {
+		return 0, err
+	}
+
+	h := sha256.New()
+	h.Write(salt)
+	h.Write([]byte(ip))
+	h.Write([]byte{0})
+	h.Write([]byte(userAgent))
+	res := binary.BigEndian.Uint64(h.Sum(nil))
+	if res == 0 {
+		res = 1
+	}
+	return res, nil
+}
+
+// saltOf returns the salt of the day now falls into, rotating it if needed.
+func (vh *visitorHasher) saltOf(ctx context.Context, now time.Time) ([]byte, error) {
+	day := now.UTC().Truncate(24 * time.Hour)
+
+	vh.Lock()
+	defer vh.Unlock()
+	if vh.salt == nil || !vh.day.Equal(day) {
+		salt := make([]byte, 32)
+		if vh.salts != nil {
+			var err error
+			if salt, err = vh.salts.VisitorSalt(ctx, day); err != nil {
+				return nil, err
+			}
+		} else {
+			// rand.Read never returns an error
+			_, _ = rand.Read(salt)
+		}
+		vh.day = day
+		vh.salt = salt
+	}
+	return vh.salt, nil
+}
+
+// optedOut tells whether the client asked not to be tracked using the Do Not
+// Track or Global Privacy Control headers.
+func optedOut(r *http.Request) bool {
+	return r.Header.Get("DNT") == "1" || r.Header.Get("Sec-GPC") == "1"
+}
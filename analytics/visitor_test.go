@@ -0,0 +1,63 @@
+package analytics
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestVisitorHashRotatesDaily(t *testing.T) {
+	var vh visitorHasher
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	hash := func(now time.Time, userAgent string) uint64 {
+		res, err := vh.hash(t.Context(), now, "1.2.3.4", userAgent)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return res
+	}
+
+	first := hash(day.Add(time.Hour), "curl/8.5.0")
+	if second := hash(day.Add(23*time.Hour), "curl/8.5.0"); first != second {
+		t.Errorf("expected the same identifier on the same day")
+	}
+	if other := hash(day.Add(2*time.Hour), "Wget/1.21"); first == other {
+		t.Errorf("expected a different identifier for a different user agent")
+	}
+	if next := hash(day.Add(25*time.Hour), "curl/8.5.0"); first == next {
+		t.Errorf("expected a different identifier on the next day")
+	}
+}
+
+// salts hands out a fixed salt per day like a backend shared by several
+// instances.
+type salts map[time.Time][]byte
+
+func (s salts) VisitorSalt(ctx context.Context, day time.Time) ([]byte, error) {
+	if _, ok := s[day]; !ok {
+		s[day] = []byte(day.String())
+	}
+	return s[day], nil
+}
+
+func TestVisitorHashUsesSharedSalts(t *testing.T) {
+	shared := salts{}
+	instances := []*visitorHasher{{salts: shared}, {salts: shared}}
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	var ids []uint64
+	for _, vh := range instances {
+		id, err := vh.hash(t.Context(), day.Add(time.Hour), "1.2.3.4", "curl/8.5.0")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	if ids[0] != ids[1] {
+		t.Errorf("expected the same identifier on all instances")
+	}
+	if _, ok := shared[day]; !ok {
+		t.Errorf("expected the salt to be taken from the shared salts")
+	}
+}
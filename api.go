@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	dbpkg "github.com/makkes/shorty/db"
+	"github.com/makkes/shorty/logging"
+)
+
+// maxStatsPeriods bounds the number of periods returned by the stats API.
+const maxStatsPeriods = 10000
+
+type clickStatsResponse struct {
+	Key         string            `json:"key"`
+	Granularity dbpkg.Granularity `json:"granularity"`
+	From        time.Time         `json:"from"`
+	To          time.Time         `json:"to"`
+	Total       uint64            `json:"total"`
+	BotClicks   uint64            `json:"botClicks"`
+	// UniqueVisitors is counted on all days overlapping with the range.
+	UniqueVisitors uint64             `json:"uniqueVisitors"`
+	Series         []dbpkg.ClickStats `json:"series"`
+}
+
+// parseTime parses an RFC 3339 timestamp or a plain date.
+func parseTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.DateOnly, s)
+}
+
+func writeJSON(w http.ResponseWriter, r *http.Request, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logging.FromContext(r.Context()).Warn("failed writing response", "error", err)
+	}
+}
+
+// clickStats serves the click time series of a short URL.
+func clickStats(db dbpkg.DB, analytics dbpkg.Analytics) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := []byte(r.PathValue("key"))
+		query := r.URL.Query()
+
+		granularity := dbpkg.GranularityDay
+		if g := query.Get("granularity"); g != "" {
+			var err error
+			if granularity, err = dbpkg.ParseGranularity(g); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		to := time.Now().UTC()
+		if s := query.Get("to"); s != "" {
+			var err error
+			if to, err = parseTime(s); err != nil {
+				http.Error(w, fmt.Sprintf("invalid 'to' parameter: %v", err), http.StatusBadRequest)
+				return
+			}
+		}
+		from := to.AddDate(0, 0, -7)
+		if granularity == dbpkg.GranularityHour {
+			from = to.Add(-24 * time.Hour)
+		}
+		if s := query.Get("from"); s != "" {
+			var err error
+			if from, err = parseTime(s); err != nil {
+				http.Error(w, fmt.Sprintf("invalid 'from' parameter: %v", err), http.StatusBadRequest)
+				return
+			}
+		}
+		if !from.Before(to) {
+			http.Error(w, "'from' must be before 'to'", http.StatusBadRequest)
+			return
+		}
+
+		// build an empty series first so that periods without clicks are included
+		series := make([]dbpkg.ClickStats, 0)
+		for start := granularity.Truncate(from); start.Before(to); start = granularity.Next(start) {
+			if len(series) == maxStatsPeriods {
+				http.Error(w, fmt.Sprintf("the requested range exceeds %d periods", maxStatsPeriods), http.StatusBadRequest)
+				return
+			}
+			series = append(series, dbpkg.NewClickStats(start))
+		}
+
+		ctx, cancel := dbContext(r)
+		defer cancel()
+		url, err := db.GetURL(ctx, key)
+		if err != nil {
+			logging.FromContext(r.Context()).Error("failed retrieving URL", "key", string(key), "error", err)
+			w.WriteHeader(dbErrorStatus(err))
+			return
+		}
+		if url == nil {
+			http.Error(w, fmt.Sprintf("no URL found for key %q", key), http.StatusNotFound)
+			return
+		}
+
+		stats, err := analytics.GetClickStats(ctx, key, from, to, granularity)
+		if err != nil {
+			logging.FromContext(r.Context()).Error("failed retrieving click stats", "key", string(key), "error", err)
+			w.WriteHeader(dbErrorStatus(err))
+			return
+		}
+
+		visitors, err := analytics.GetUniqueVisitors(ctx, key, from, to)
+		if err != nil {
+			logging.FromContext(r.Context()).Error("failed retrieving unique visitors", "key", string(key), "error", err)
+			w.WriteHeader(dbErrorStatus(err))
+			return
+		}
+
+		res := clickStatsResponse{
+			UniqueVisitors: visitors,
+			Key:            string(key),
+			Granularity:    granularity,
+			From:           from,
+			To:             to,
+			Series:         series,
+		}
+		periods := make(map[int64]int, len(series))
+		for idx, s := range series {
+			periods[s.Start.Unix()] = idx
+		}
+		for _, s := range stats {
+			if idx, ok := periods[s.Start.Unix()]; ok {
+				res.Series[idx] = s
+			}
+			res.Total += s.Clicks
+			res.BotClicks += s.BotClicks
+		}
+		writeJSON(w, r, res)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/makkes/shorty/assert"
+	"github.com/makkes/shorty/db"
+)
+
+type TestAnalytics struct {
+	stats    []db.ClickStats
+	visitors uint64
+	// block makes queries wait until their context is done
+	block bool
+}
+
+func (ta *TestAnalytics) RecordClick(ctx context.Context, click db.Click) error {
+	return nil
+}
+
+func (ta *TestAnalytics) GetClickStats(ctx context.Context, key []byte, from, to time.Time, granularity db.Granularity) ([]db.ClickStats, error) {
+	if ta.block {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	return ta.stats, nil
+}
+
+func (ta *TestAnalytics) GetUniqueVisitors(ctx context.Context, key []byte, from, to time.Time) (uint64, error) {
+	return ta.visitors, nil
+}
+
+func setupClickStats(url string, tdb *TestDB, ta *TestAnalytics) *httptest.ResponseRecorder {
+	mux := http.NewServeMux()
+	mux.Handle("GET /api/v1/links/{key}/stats", clickStats(tdb, ta))
+	req, _ := http.NewRequest("GET", url, nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	return w
+}
+
+func TestClickStatsReturnsContinuousSeries(t *testing.T) {
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	stats := db.NewClickStats(day.AddDate(0, 0, 1))
+	stats.Add(db.Click{Browser: "Firefox"})
+	stats.Add(db.Click{Browser: "Chrome"})
+	stats.Add(db.Click{Browser: "Chrome", Bot: "unfurler"})
+
+	w := setupClickStats("/api/v1/links/abc/stats?from=2024-03-01&to=2024-03-04",
+		&TestDB{key: []byte("abc"), url: []byte("http://example.org")},
+		&TestAnalytics{stats: []db.ClickStats{stats}, visitors: 1})
+
+	assert := assert.NewAssert(t)
+	assert.Equal(w.Code, http.StatusOK, "unexpected status code")
+	assert.Equal(w.Header().Get("Content-Type"), "application/json", "unexpected content type")
+
+	var res clickStatsResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatalf("failed decoding response: %v", err)
+	}
+	assert.Equal(res.Granularity, db.GranularityDay, "unexpected granularity")
+	assert.Equal(res.Total, uint64(2), "unexpected total")
+	assert.Equal(res.BotClicks, uint64(1), "unexpected bot clicks")
+	assert.Equal(res.UniqueVisitors, uint64(1), "unexpected unique visitors")
+	assert.Equal(len(res.Series), 3, "unexpected length of series")
+	assert.Equal(res.Series[0].Clicks, uint64(0), "unexpected clicks on first day")
+	assert.Equal(res.Series[1].Clicks, uint64(2), "unexpected clicks on second day")
+	assert.Equal(res.Series[1].Browsers["Firefox"], uint64(1), "unexpected browser count")
+	assert.Equal(res.Series[1].Browsers["Chrome"], uint64(1), "bot click counted as human browser")
+	assert.Equal(res.Series[1].Bots["unfurler"], uint64(1), "unexpected bot count")
+	assert.Equal(res.Series[2].Start.Equal(day.AddDate(0, 0, 2)), true, "unexpected start of last day")
+}
+
+func TestClickStatsHandlesUnknownKeys(t *testing.T) {
+	w := setupClickStats("/api/v1/links/abc/stats", &TestDB{}, &TestAnalytics{})
+
+	assert := assert.NewAssert(t)
+	assert.Equal(w.Code, http.StatusNotFound, "unexpected status code")
+}
+
+func TestClickStatsTimesOut(t *testing.T) {
+	defer func(timeout time.Duration) { dbTimeout = timeout }(dbTimeout)
+	dbTimeout = 10 * time.Millisecond
+	w := setupClickStats("/api/v1/links/abc/stats",
+		&TestDB{key: []byte("abc"), url: []byte("http://example.org")}, &TestAnalytics{block: true})
+
+	assert := assert.NewAssert(t)
+	assert.Equal(w.Code, http.StatusServiceUnavailable, "unexpected status code")
+}
+
+func TestClickStatsValidatesParameters(t *testing.T) {
+	tdb := &TestDB{key: []byte("abc"), url: []byte("http://example.org")}
+	for _, query := range []string{
+		"granularity=week",
+		"from=yesterday",
+		"to=2024-13-01",
+		"from=2024-03-02&to=2024-03-01",
+		"from=2000-01-01&to=2024-01-01&granularity=hour",
+	} {
+		w := setupClickStats("/api/v1/links/abc/stats?"+query, tdb, &TestAnalytics{})
+		assert := assert.NewAssert(t)
+		assert.Equal(w.Code, http.StatusBadRequest, "unexpected status code for "+query)
+	}
+}
@@ -0,0 +1,112 @@
+// Package assets serves Shorty's web UI. The files are embedded into the
+// binary and may be replaced by files in an override directory.
+package assets
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"os"
+
+	"github.com/makkes/shorty/logging"
+)
+
+//go:embed index.html css fonts js
+var embedded embed.FS
+
+// ContentSecurityPolicy only allows the UI to load resources from and send
+// requests to Shorty itself. Custom assets must not use inline scripts or
+// styles.
+const ContentSecurityPolicy = "default-src 'none'; script-src 'self'; style-src 'self'; img-src 'self'; font-src 'self'; connect-src 'self'; form-action 'self'; base-uri 'none'; frame-ancestors 'none'"
+
+// Config holds the settings of the UI, available to index.html.
+type Config struct {
+	// Title is the name of the instance.
+	Title string
+	// BaseURL is the prefix of short URLs, e.g. "https://sho.rt/".
+	BaseURL string
+	// Footer is shown at the bottom of the page and may contain markup.
+	Footer template.HTML
+	// CustomKeys enables the input field for choosing the key of a short URL.
+	CustomKeys bool
+	// GitHubRibbon enables the "Fork me on GitHub" ribbon.
+	GitHubRibbon bool
+}
+
+// DefaultConfig returns the settings used unless configured otherwise.
+func DefaultConfig() Config {
+	return Config{
+		Title:        "Shorty",
+		Footer:       `Built with <a href="https://go.dev">Go</a>`,
+		CustomKeys:   true,
+		GitHubRibbon: true,
+	}
+}
+
+// overlay serves the files of upper, falling back to lower.
+type overlay struct {
+	upper, lower fs.FS
+}
+
+func (o overlay) Open(name string) (fs.File, error) {
+	f, err := o.upper.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return o.lower.Open(name)
+	}
+	return f, err
+}
+
+// A UI serves index.html, rendered as template with its Config, and the
+// static files referenced by it.
+type UI struct {
+	cfg   Config
+	files fs.FS
+	index *template.Template
+}
+
+// New returns a UI using the files in dir, if not empty, in place of the
+// embedded ones.
+func New(cfg Config, dir string) (*UI, error) {
+	var files fs.FS = embedded
+	if dir != "" {
+		if _, err := os.Stat(dir); err != nil {
+			return nil, fmt.Errorf("invalid assets directory: %w", err)
+		}
+		files = overlay{upper: os.DirFS(dir), lower: embedded}
+	}
+	index, err := template.ParseFS(files, "index.html")
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing index.html: %w", err)
+	}
+	return &UI{cfg: cfg, files: files, index: index}, nil
+}
+
+// Index serves the rendered index.html.
+func (ui *UI) Index() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// render to a buffer so that failures can still be reported
+		var buf bytes.Buffer
+		if err := ui.index.Execute(&buf, ui.cfg); err != nil {
+			logging.FromContext(r.Context()).Error("failed rendering index.html", "error", err)
+			http.Error(w, "failed rendering page", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Content-Security-Policy", ContentSecurityPolicy)
+		_, _ = buf.WriteTo(w)
+	}
+}
+
+// Files serves the static files such as scripts and style sheets.
+func (ui *UI) Files() http.Handler {
+	files := http.FileServerFS(ui.files)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// protects e.g. SVG images and directory listings
+		w.Header().Set("Content-Security-Policy", ContentSecurityPolicy)
+		files.ServeHTTP(w, r)
+	})
+}
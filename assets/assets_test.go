@@ -0,0 +1,105 @@
+package assets_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/makkes/shorty/assert"
+	"github.com/makkes/shorty/assets"
+)
+
+func get(t *testing.T, h http.Handler, path string) *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+	return rr
+}
+
+func TestIndexRendersConfig(t *testing.T) {
+	cfg := assets.DefaultConfig()
+	cfg.Title = "Links <R> Us"
+	cfg.BaseURL = "https://sho.rt/"
+	cfg.Footer = `Run by <a href="https://example.org">us</a>`
+	ui, err := assets.New(cfg, "")
+	assert := assert.NewAssert(t)
+	assert.Nil(err, "unexpected error")
+
+	rr := get(t, ui.Index(), "/")
+	assert.Equal(rr.Code, http.StatusOK, "unexpected status code")
+	assert.Equal(rr.Header().Get("Content-Type"), "text/html; charset=utf-8", "unexpected content type")
+	assert.Equal(rr.Header().Get("Content-Security-Policy"), assets.ContentSecurityPolicy, "unexpected content security policy")
+	body := rr.Body.String()
+	assert.Match(`<title>Links &lt;R&gt; Us</title>`, body, "title has not been escaped")
+	assert.Match(`<a href="https://sho.rt/">Links &lt;R&gt; Us</a>`, body, "unexpected heading")
+	assert.Match(`<footer>Run by <a href="https://example.org">us</a></footer>`, body, "unexpected footer")
+	assert.Match(`id="key"`, body, "key input is missing")
+	assert.Match(`github-ribbon`, body, "ribbon is missing")
+
+	cfg.CustomKeys, cfg.GitHubRibbon = false, false
+	ui, err = assets.New(cfg, "")
+	assert.Nil(err, "unexpected error")
+	body = get(t, ui.Index(), "/").Body.String()
+	assert.Equal(strings.Contains(body, `id="key"`), false, "key input has not been disabled")
+	assert.Equal(strings.Contains(body, "github-ribbon"), false, "ribbon has not been disabled")
+}
+
+func TestFilesAreEmbedded(t *testing.T) {
+	ui, err := assets.New(assets.DefaultConfig(), "")
+	assert := assert.NewAssert(t)
+	assert.Nil(err, "unexpected error")
+	for _, path := range []string{"/css/style.css", "/css/bootstrap.min.css", "/js/shorty.js"} {
+		assert.Equal(get(t, ui.Files(), path).Code, http.StatusOK, "unexpected status code for "+path)
+	}
+}
+
+func TestUIIsSelfContained(t *testing.T) {
+	ui, err := assets.New(assets.DefaultConfig(), "")
+	assert := assert.NewAssert(t)
+	assert.Nil(err, "unexpected error")
+	index := get(t, ui.Index(), "/").Body.String()
+	refs := regexp.MustCompile(`<(?:script|link|img)[^>]*(?:src|href)="([^"]*)"`).FindAllStringSubmatch(index, -1)
+	assert.Equal(len(refs), 3, "unexpected number of resources")
+	for _, ref := range refs {
+		assert.Match(`^[a-z]+/[\w.-]+$`, ref[1], "external resource in index.html")
+		assert.Equal(get(t, ui.Files(), "/"+ref[1]).Code, http.StatusOK, "missing asset "+ref[1])
+	}
+
+	for _, sheet := range []string{"css/style.css", "css/bootstrap.min.css"} {
+		css := get(t, ui.Files(), "/"+sheet).Body.String()
+		for _, ref := range regexp.MustCompile(`url\(['"]?([^'")?#]*)`).FindAllStringSubmatch(css, -1) {
+			assert.Match(`^\.\./[a-z]+/[\w.-]+$`, ref[1], "external resource in "+sheet)
+			asset := path.Join("/", path.Dir(sheet), ref[1])
+			assert.Equal(get(t, ui.Files(), asset).Code, http.StatusOK, "missing asset "+asset+" referenced in "+sheet)
+		}
+	}
+}
+
+func TestOverrideDirectory(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "css"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{
+		"index.html":    `<h1>{{.Title}} custom</h1>`,
+		"css/style.css": `body { color: hotpink; }`,
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ui, err := assets.New(assets.DefaultConfig(), dir)
+	assert := assert.NewAssert(t)
+	assert.Nil(err, "unexpected error")
+	assert.Equal(get(t, ui.Index(), "/").Body.String(), "<h1>Shorty custom</h1>", "index has not been overridden")
+	assert.Match("hotpink", get(t, ui.Files(), "/css/style.css").Body.String(), "style sheet has not been overridden")
+	assert.Match("function shorten", get(t, ui.Files(), "/js/shorty.js").Body.String(), "embedded script is not served")
+
+	_, err = assets.New(assets.DefaultConfig(), filepath.Join(dir, "missing"))
+	assert.NotNil(err, "expected error for missing directory")
+}
@@ -2,7 +2,7 @@ function shorten(url, key) {
 
     function feedback(msg) {
         var feedbackElem = document.querySelector("#feedback");
-        feedbackElem.querySelector('.text').innerHTML = msg;
+        feedbackElem.querySelector('.text').textContent = msg;
         feedbackElem.classList.add('visible');
         window.setTimeout(function () {
             feedbackElem.classList.remove('visible');
@@ -30,8 +30,8 @@ function shorten(url, key) {
         res.focus();
         res.select();
     });
-    req.open("GET", "/shorten?url=" + encodeURIComponent(url) + "&key=" + encodeURIComponent(key));
-    req.send();
+    req.open("POST", "/shorten");
+    req.send(new URLSearchParams({url: url, key: key}));
 }
 
 window.addEventListener("load", function (ev) {
@@ -40,7 +40,8 @@ window.addEventListener("load", function (ev) {
     keyElem = document.querySelector("#key");
     form.addEventListener("submit", function (ev) {
         ev.preventDefault();
-        shorten(urlElem.value, keyElem.value);
+        // the key can only be chosen if custom keys are enabled
+        shorten(urlElem.value, keyElem ? keyElem.value : '');
     });
     urlElem.focus();
 });
@@ -0,0 +1,157 @@
+package boltdb
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	dbpkg "github.com/makkes/shorty/db"
+)
+
+var _ dbpkg.Backuper = BoltDB{}
+
+// Backup writes a tar archive containing snapshots of both database files to
+// w. Each snapshot is taken in a read transaction, so the database can be
+// used concurrently.
+func (db BoltDB) Backup(ctx context.Context, w io.Writer) error {
+	tw := tar.NewWriter(w)
+	for _, file := range []struct {
+		name string
+		db   *bolt.DB
+	}{{dbFile, db.DB}, {statsFile, db.stats}} {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		err := file.db.View(func(tx *bolt.Tx) error {
+			err := tw.WriteHeader(&tar.Header{
+				Typeflag: tar.TypeReg,
+				Name:     file.name,
+				Mode:     0o600,
+				Size:     tx.Size(),
+				ModTime:  time.Now(),
+			})
+			if err != nil {
+				return err
+			}
+			_, err = tx.WriteTo(tw)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("Error backing up %s: %w", file.name, err)
+		}
+	}
+	return tw.Close()
+}
+
+// validate checks the integrity of the database file at p.
+func validate(p string) error {
+	db, err := bolt.Open(p, 0o600, &bolt.Options{ReadOnly: true, Timeout: 1 * time.Second})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return db.View(func(tx *bolt.Tx) error {
+		var errs []error
+		for err := range tx.Check() {
+			errs = append(errs, err)
+		}
+		return errors.Join(errs...)
+	})
+}
+
+// Restore replaces the database files in DB_DIR with the ones from a backup
+// created by Backup, optionally compressed with gzip. Both files are
+// validated before any of the existing files is touched; these are kept with
+// the suffix ".bak". The database must not be in use.
+func Restore(r io.Reader) error {
+	timeout, err := lockTimeout()
+	if err != nil {
+		return err
+	}
+	dbDir := os.Getenv("DB_DIR")
+	br := bufio.NewReader(r)
+	if magic, _ := br.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	} else {
+		r = br
+	}
+
+	restored := make(map[string]string)
+	defer func() {
+		for _, tmp := range restored {
+			os.Remove(tmp)
+		}
+	}()
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("Error reading backup: %w", err)
+		}
+		if (hdr.Name != dbFile && hdr.Name != statsFile) || hdr.Typeflag != tar.TypeReg {
+			return fmt.Errorf("unexpected entry %q in backup", hdr.Name)
+		}
+		if _, ok := restored[hdr.Name]; ok {
+			return fmt.Errorf("duplicate entry %q in backup", hdr.Name)
+		}
+		tmp, err := os.CreateTemp(dbDir, hdr.Name+".restore-*")
+		if err != nil {
+			return err
+		}
+		restored[hdr.Name] = tmp.Name()
+		_, err = io.Copy(tmp, tr)
+		if err = errors.Join(err, tmp.Sync(), tmp.Close()); err != nil {
+			return fmt.Errorf("Error extracting %s: %w", hdr.Name, err)
+		}
+		if err := validate(tmp.Name()); err != nil {
+			return fmt.Errorf("%s in backup is invalid: %w", hdr.Name, err)
+		}
+	}
+	for _, name := range []string{dbFile, statsFile} {
+		if _, ok := restored[name]; !ok {
+			return fmt.Errorf("backup doesn't contain %s", name)
+		}
+	}
+
+	// make sure that no server is using the database while swapping files
+	for _, name := range []string{dbFile, statsFile} {
+		current := path.Join(dbDir, name)
+		if _, err := os.Stat(current); errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		db, err := bolt.Open(current, 0o600, &bolt.Options{Timeout: timeout})
+		if err != nil {
+			return fmt.Errorf("Error opening %s, is Shorty still running? %w", current, err)
+		}
+		defer db.Close()
+	}
+	for _, name := range []string{dbFile, statsFile} {
+		current := path.Join(dbDir, name)
+		if err := os.Rename(current, current+".bak"); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		if err := os.Rename(restored[name], current); err != nil {
+			return err
+		}
+		delete(restored, name)
+	}
+	return nil
+}
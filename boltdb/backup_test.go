@@ -0,0 +1,95 @@
+package boltdb_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/makkes/shorty/assert"
+	"github.com/makkes/shorty/boltdb"
+)
+
+func TestBackupAndRestore(t *testing.T) {
+	t.Setenv("DB_DIR", t.TempDir())
+	db := openDB(t)
+
+	assert := assert.NewAssert(t)
+	assert.Nil(db.SaveURL(t.Context(), "http://example.org", []byte("abc")), "unexpected error")
+	var backup bytes.Buffer
+	assert.Nil(db.Backup(t.Context(), &backup), "unexpected error")
+	assert.Nil(db.Close(), "unexpected error")
+
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	_, err := gz.Write(backup.Bytes())
+	assert.Nil(err, "unexpected error")
+	assert.Nil(gz.Close(), "unexpected error")
+
+	for name, archive := range map[string][]byte{"plain": backup.Bytes(), "gzip": compressed.Bytes()} {
+		dir := t.TempDir()
+		t.Setenv("DB_DIR", dir)
+		// an existing database is replaced
+		db = openDB(t)
+		assert.Nil(db.SaveURL(t.Context(), "http://example.com", []byte("def")), "unexpected error")
+		assert.Nil(db.Close(), "unexpected error")
+
+		assert.Nil(boltdb.Restore(bytes.NewReader(archive)), "unexpected error restoring "+name+" backup")
+		db = openDB(t)
+		url, err := db.GetURL(t.Context(), []byte("abc"))
+		assert.Nil(err, "unexpected error")
+		assert.Equal(string(url), "http://example.org", "URL has not been restored from "+name+" backup")
+		url, err = db.GetURL(t.Context(), []byte("def"))
+		assert.Nil(err, "unexpected error")
+		assert.Equal(url == nil, true, "database has not been replaced by "+name+" backup")
+		assert.Nil(db.Close(), "unexpected error")
+		_, err = os.Stat(filepath.Join(dir, "shorty.db.bak"))
+		assert.Nil(err, "previous database has not been kept")
+	}
+}
+
+func TestRestoreRejectsInvalidBackups(t *testing.T) {
+	t.Setenv("DB_DIR", t.TempDir())
+	db := openDB(t)
+	assert := assert.NewAssert(t)
+	assert.Nil(db.SaveURL(t.Context(), "http://example.org", []byte("abc")), "unexpected error")
+	var backup bytes.Buffer
+	assert.Nil(db.Backup(t.Context(), &backup), "unexpected error")
+	assert.Nil(db.Close(), "unexpected error")
+
+	archive := func(entries map[string][]byte) []byte {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		for name, data := range entries {
+			assert.Nil(tw.WriteHeader(&tar.Header{Name: name, Mode: 0o600, Size: int64(len(data))}), "unexpected error")
+			_, err := tw.Write(data)
+			assert.Nil(err, "unexpected error")
+		}
+		assert.Nil(tw.Close(), "unexpected error")
+		return buf.Bytes()
+	}
+	dbFile, err := os.ReadFile(filepath.Join(os.Getenv("DB_DIR"), "shorty.db"))
+	assert.Nil(err, "unexpected error")
+
+	for name, data := range map[string][]byte{
+		"garbage":      []byte("garbage"),
+		"truncated":    backup.Bytes()[:backup.Len()/2],
+		"missing file": archive(map[string][]byte{"shorty.db": dbFile}),
+		"unknown file": archive(map[string][]byte{"shorty.db": dbFile, "shorty_stats.db": dbFile, "../evil": nil}),
+		"corrupt file": archive(map[string][]byte{"shorty.db": dbFile, "shorty_stats.db": []byte("not a database")}),
+	} {
+		assert.NotNil(boltdb.Restore(bytes.NewReader(data)), "expected error restoring "+name)
+	}
+
+	// the existing database is untouched and no temporary files are left
+	entries, err := os.ReadDir(os.Getenv("DB_DIR"))
+	assert.Nil(err, "unexpected error")
+	assert.Equal(len(entries), 2, "unexpected files in DB_DIR")
+
+	// restoring a database in use fails
+	db = openDB(t)
+	defer db.Close()
+	assert.NotNil(boltdb.Restore(bytes.NewReader(backup.Bytes())), "expected error restoring a database in use")
+}
@@ -1,41 +1,116 @@
 package boltdb
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"path"
 	"strconv"
 	"time"
 
 	bolt "go.etcd.io/bbolt"
+	"go.opentelemetry.io/otel"
+	semconv "go.opentelemetry.io/otel/semconv/v1.43.0"
+	"go.opentelemetry.io/otel/trace"
 
 	dbpkg "github.com/makkes/shorty/db"
+	"github.com/makkes/shorty/tracing"
 )
 
-// A BoltDB uses Bolt to persist URLs.
+// A BoltDB uses Bolt to persist URLs. Clicks are recorded asynchronously in
+// a separate database file.
 type BoltDB struct {
 	*bolt.DB
+
+	stats     *bolt.DB
+	clicks    chan dbpkg.Click
+	collected chan struct{}
 }
 
+// The names of the database files in DB_DIR.
+const (
+	dbFile    = "shorty.db"
+	statsFile = "shorty_stats.db"
+)
+
 var _ dbpkg.DB = BoltDB{}
+var _ dbpkg.LinkSaver = BoltDB{}
+var _ dbpkg.Analytics = BoltDB{}
+var _ dbpkg.VisitorSalts = BoltDB{}
+
+// lockTimeout returns how long to wait for the lock of a database file held
+// by another process, configured by BOLT_LOCK_TIMEOUT.
+func lockTimeout() (time.Duration, error) {
+	s := os.Getenv("BOLT_LOCK_TIMEOUT")
+	if s == "" {
+		return time.Second, nil
+	}
+	timeout, err := time.ParseDuration(s)
+	if err != nil || timeout < 0 {
+		return 0, fmt.Errorf("invalid BOLT_LOCK_TIMEOUT %q", s)
+	}
+	return timeout, nil
+}
 
-// NewBoltDB returns a BoltDB that uses db as database.
-func NewBoltDB() (dbpkg.DB, error) {
+// NewBoltDB returns a BoltDB using the database files in DB_DIR, creating
+// them if necessary. Failures of recording clicks are logged to logger.
+func NewBoltDB(logger *slog.Logger) (dbpkg.DB, error) {
 	res := BoltDB{}
+	timeout, err := lockTimeout()
+	if err != nil {
+		return res, err
+	}
 	dbDir := os.Getenv("DB_DIR")
-	db, err := bolt.Open(path.Join(dbDir, "shorty.db"), 0o600, &bolt.Options{Timeout: 1 * time.Second})
+	db, err := bolt.Open(path.Join(dbDir, dbFile), 0o600, &bolt.Options{Timeout: timeout})
 	if err != nil {
 		return res, fmt.Errorf("Error opening Bolt DB: %w", err)
 	}
+	stats, err := bolt.Open(path.Join(dbDir, statsFile), 0o600, &bolt.Options{Timeout: timeout})
+	if err != nil {
+		db.Close()
+		return res, fmt.Errorf("Error opening Bolt DB for stats: %w", err)
+	}
 
 	res.DB = db
+	res.stats = stats
+	res.clicks = make(chan dbpkg.Click, 1000)
+	res.collected = make(chan struct{})
+	go collectStats(res.stats, res.clicks, res.collected, logger)
 	return res, nil
 }
 
-func (db BoltDB) GetURL(key []byte) ([]byte, error) {
-	var url []byte
-	err := db.View(func(tx *bolt.Tx) error {
+// Close stops recording clicks after all queued clicks have been stored and
+// closes both database files. db must not be used afterwards.
+func (db BoltDB) Close() error {
+	close(db.clicks)
+	<-db.collected
+	return errors.Join(db.stats.Close(), db.DB.Close())
+}
+
+var tracer = otel.Tracer("github.com/makkes/shorty/boltdb")
+
+// startSpan starts the span of the database operation op.
+func startSpan(ctx context.Context, op string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, op, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		semconv.DBSystemNameKey.String("bolt"),
+		semconv.DBOperationName(op),
+	))
+}
+
+// Bolt transactions can't be interrupted, so the methods of BoltDB only check
+// ctx before starting a transaction and between the links and clicks they
+// iterate over.
+
+func (db BoltDB) GetURL(ctx context.Context, key []byte) (url []byte, err error) {
+	ctx, span := startSpan(ctx, "GetURL")
+	defer func() { tracing.End(span, err) }()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	err = db.View(func(tx *bolt.Tx) error {
 		bucket := tx.Bucket([]byte("shorty"))
 		if bucket == nil {
 			return nil
@@ -46,35 +121,169 @@ func (db BoltDB) GetURL(key []byte) ([]byte, error) {
 	return url, err
 }
 
+// createdBucket maps keys to the creation time of their links, encoded as
+// RFC 3339 timestamps. Links created before it was introduced have no entry.
+var createdBucket = []byte("created")
+
+// putLink stores link in tx, failing with ErrKeyCollision if its key is used
+// unless replace is true. The creation time is only stored if set.
+func putLink(tx *bolt.Tx, link dbpkg.Link, replace bool) error {
+	key := []byte(link.Key)
+	invbucket, err := tx.CreateBucketIfNotExists([]byte("invshorty"))
+	if err != nil {
+		return err
+	}
+	bucket, err := tx.CreateBucketIfNotExists([]byte("shorty"))
+	if err != nil {
+		return err
+	}
+	if old := bucket.Get(key); old != nil {
+		if !replace {
+			return dbpkg.NewErrKeyCollision(key)
+		}
+		if bytes.Equal(invbucket.Get(old), key) {
+			if err := invbucket.Delete(old); err != nil {
+				return err
+			}
+		}
+	}
+	if err := invbucket.Put([]byte(link.URL), key); err != nil {
+		return err
+	}
+	if err := bucket.Put(key, []byte(link.URL)); err != nil {
+		return err
+	}
+	if link.Created.IsZero() {
+		return nil
+	}
+	created, err := tx.CreateBucketIfNotExists(createdBucket)
+	if err != nil {
+		return err
+	}
+	return created.Put(key, []byte(link.Created.UTC().Format(time.RFC3339Nano)))
+}
+
 // SaveURL saves the given url using a key from the keybuffer as short URL.
-func (db BoltDB) SaveURL(url string, key []byte) error {
-	err := db.Update(func(tx *bolt.Tx) error {
-		invbucket, err := tx.CreateBucketIfNotExists([]byte("invshorty"))
+func (db BoltDB) SaveURL(ctx context.Context, url string, key []byte) (err error) {
+	ctx, span := startSpan(ctx, "SaveURL")
+	defer func() { tracing.End(span, err) }()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		return putLink(tx, dbpkg.Link{Key: string(key), URL: url, Created: time.Now()}, false)
+	})
+}
+
+// ReplaceURL saves url under key, replacing any URL already stored under it.
+func (db BoltDB) ReplaceURL(ctx context.Context, url string, key []byte) (err error) {
+	ctx, span := startSpan(ctx, "ReplaceURL")
+	defer func() { tracing.End(span, err) }()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		return putLink(tx, dbpkg.Link{Key: string(key), URL: url}, true)
+	})
+}
+
+// SaveLink saves link including its metadata. The number of clicks
+// replaces the total number of clicks recorded for the key.
+func (db BoltDB) SaveLink(ctx context.Context, link dbpkg.Link, replace bool) (err error) {
+	ctx, span := startSpan(ctx, "SaveLink")
+	defer func() { tracing.End(span, err) }()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		return putLink(tx, link, replace)
+	})
+	if err != nil || link.Clicks == 0 {
+		return err
+	}
+	return db.stats.Update(func(tx *bolt.Tx) error {
+		views, err := tx.CreateBucketIfNotExists(viewsBucket)
 		if err != nil {
-			return err
+			return fmt.Errorf("Error opening/creating bucket 'views': %w", err)
 		}
-		bucket, err := tx.CreateBucketIfNotExists([]byte("shorty"))
+		return views.Put([]byte(link.Key), []byte(strconv.FormatUint(link.Clicks, 10)))
+	})
+}
+
+// ListURLs iterates over all links including their creation time and total
+// number of clicks, reading them in pages.
+func (db BoltDB) ListURLs(ctx context.Context, fn func(dbpkg.Link) error) (err error) {
+	ctx, span := startSpan(ctx, "ListURLs")
+	defer func() { tracing.End(span, err) }()
+	var last []byte
+	for {
+		page := make([]dbpkg.Link, 0, exportPageSize)
+		err := db.View(func(tx *bolt.Tx) error {
+			bucket := tx.Bucket([]byte("shorty"))
+			if bucket == nil {
+				return nil
+			}
+			created := tx.Bucket(createdBucket)
+			return db.stats.View(func(statsTx *bolt.Tx) error {
+				views := statsTx.Bucket(viewsBucket)
+				c := bucket.Cursor()
+				k, v := c.First()
+				if last != nil {
+					if k, v = c.Seek(last); bytes.Equal(k, last) {
+						k, v = c.Next()
+					}
+				}
+				for ; k != nil && len(page) < exportPageSize; k, v = c.Next() {
+					link := dbpkg.Link{Key: string(k), URL: string(v)}
+					if created != nil {
+						if ts := created.Get(k); ts != nil {
+							t, err := time.Parse(time.RFC3339Nano, string(ts))
+							if err != nil {
+								return fmt.Errorf("Error decoding creation time of %q: %w", k, err)
+							}
+							link.Created = t
+						}
+					}
+					if views != nil {
+						if count := views.Get(k); count != nil {
+							clicks, err := strconv.ParseUint(string(count), 10, 64)
+							if err != nil {
+								return fmt.Errorf("Error decoding views for %s: %w", k, err)
+							}
+							link.Clicks = clicks
+						}
+					}
+					page = append(page, link)
+					last = bytes.Clone(k)
+				}
+				return nil
+			})
+		})
 		if err != nil {
 			return err
 		}
-		if bucket.Get(key) != nil {
-			return dbpkg.NewErrKeyCollision(key)
+		for _, link := range page {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := fn(link); err != nil {
+				return err
+			}
 		}
-		err = invbucket.Put([]byte(url), key)
-		if err != nil {
-			return err
+		if len(page) < exportPageSize {
+			return nil
 		}
-		err = bucket.Put(key, []byte(url))
-		return err
-	})
-
-	return err
+	}
 }
 
-func (db BoltDB) GetStats() (dbpkg.Stats, error) {
-	var res dbpkg.Stats
+func (db BoltDB) GetStats(ctx context.Context) (res dbpkg.Stats, err error) {
+	ctx, span := startSpan(ctx, "GetStats")
+	defer func() { tracing.End(span, err) }()
+	if err := ctx.Err(); err != nil {
+		return res, err
+	}
 
-	err := db.View(func(tx *bolt.Tx) error {
+	err = db.View(func(tx *bolt.Tx) error {
 		bucket := tx.Bucket([]byte("shorty"))
 		if bucket == nil {
 			return nil
@@ -86,41 +295,3 @@ func (db BoltDB) GetStats() (dbpkg.Stats, error) {
 
 	return res, err
 }
-
-func collectStats(dbDir string, statch <-chan []byte) {
-	db, err := bolt.Open(path.Join(dbDir, "shorty_stats.db"), 0o600, &bolt.Options{Timeout: 1 * time.Second})
-	if err != nil {
-		log.Fatal("Error opening Bolt DB for stats: ", err)
-	}
-	defer func() {
-		closeerr := db.Close()
-		if err != nil {
-			log.Printf("Error closing stats DB: %v", closeerr)
-		}
-	}()
-	for {
-		url := <-statch
-		err = db.Update(func(tx *bolt.Tx) error {
-			bucket, berr := tx.CreateBucketIfNotExists([]byte("views"))
-			if berr != nil {
-				return fmt.Errorf("Error opening/creating bucket 'views': %v", berr)
-			}
-			viewBytes := bucket.Get(url)
-			var views uint64
-			if viewBytes != nil {
-				views, err = strconv.ParseUint(string(viewBytes), 10, 64)
-				if err != nil {
-					return fmt.Errorf("Error decoding views for %s: %v", string(url), err)
-				}
-			} else {
-				views = 0
-			}
-			views++
-			err = bucket.Put(url, []byte(strconv.FormatUint(views, 10)))
-			return err
-		})
-		if err != nil {
-			log.Println(err)
-		}
-	}
-}
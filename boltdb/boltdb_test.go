@@ -0,0 +1,27 @@
+package boltdb_test
+
+import (
+	"testing"
+
+	"github.com/makkes/shorty/assert"
+)
+
+func TestReplaceURL(t *testing.T) {
+	t.Setenv("DB_DIR", t.TempDir())
+	db := openDB(t)
+	defer db.Close()
+
+	assert := assert.NewAssert(t)
+	assert.Nil(db.SaveURL(t.Context(), "http://example.org", []byte("a")), "unexpected error")
+	assert.Nil(db.ReplaceURL(t.Context(), "http://example.com", []byte("a")), "unexpected error")
+	assert.Nil(db.ReplaceURL(t.Context(), "http://example.net", []byte("b")), "unexpected error")
+	url, err := db.GetURL(t.Context(), []byte("a"))
+	assert.Nil(err, "unexpected error")
+	assert.Equal(string(url), "http://example.com", "URL has not been replaced")
+	url, err = db.GetURL(t.Context(), []byte("b"))
+	assert.Nil(err, "unexpected error")
+	assert.Equal(string(url), "http://example.net", "URL has not been saved")
+	stats, err := db.GetStats(t.Context())
+	assert.Nil(err, "unexpected error")
+	assert.Equal(stats.StoredURLs, 2, "unexpected number of URLs")
+}
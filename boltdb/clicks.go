@@ -0,0 +1,298 @@
+package boltdb
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	dbpkg "github.com/makkes/shorty/db"
+	"github.com/makkes/shorty/hll"
+)
+
+// The layout of the stats database is as follows:
+//
+//	views/<key>                         total number of clicks by humans
+//	clicks/<key>/<time><seq>            a single click, JSON-encoded
+//	rollups/<key>/<granularity>/<time>  the rolled up clicks of a period, JSON-encoded
+//	visitors/<key>/<time>               a HyperLogLog sketch of the visitors of a day
+//	salts/<time>                        the salt of the visitor identifiers of a day
+//
+// Times are encoded as big-endian Unix nanoseconds (clicks) or seconds
+// (rollups) so that cursors iterate in chronological order.
+var (
+	viewsBucket    = []byte("views")
+	clicksBucket   = []byte("clicks")
+	rollupsBucket  = []byte("rollups")
+	visitorsBucket = []byte("visitors")
+	saltsBucket    = []byte("salts")
+	granularities  = []dbpkg.Granularity{dbpkg.GranularityHour, dbpkg.GranularityDay}
+)
+
+// RecordClick queues click for being stored. It fails if the queue is full.
+func (db BoltDB) RecordClick(ctx context.Context, click dbpkg.Click) error {
+	select {
+	case db.clicks <- click:
+		return nil
+	default:
+		return errors.New("click queue is full, dropping click")
+	}
+}
+
+func (db BoltDB) GetClickStats(ctx context.Context, key []byte, from, to time.Time, granularity dbpkg.Granularity) ([]dbpkg.ClickStats, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	res := make([]dbpkg.ClickStats, 0)
+	err := db.stats.View(func(tx *bolt.Tx) error {
+		bucket := nestedBucket(tx.Bucket(rollupsBucket), key, []byte(granularity))
+		if bucket == nil {
+			return nil
+		}
+		c := bucket.Cursor()
+		for k, v := c.Seek(rollupKey(granularity.Truncate(from))); k != nil; k, v = c.Next() {
+			if !time.Unix(int64(binary.BigEndian.Uint64(k)), 0).Before(to) {
+				break
+			}
+			var stats dbpkg.ClickStats
+			if err := json.Unmarshal(v, &stats); err != nil {
+				return fmt.Errorf("Error decoding rollup of %q: %w", key, err)
+			}
+			if granularity == dbpkg.GranularityDay {
+				sketch, err := visitorSketch(tx, key, k)
+				if err != nil {
+					return err
+				}
+				stats.UniqueVisitors = sketch.Count()
+			}
+			res = append(res, stats)
+		}
+		return nil
+	})
+	return res, err
+}
+
+func (db BoltDB) GetUniqueVisitors(ctx context.Context, key []byte, from, to time.Time) (uint64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	merged := hll.New()
+	err := db.stats.View(func(tx *bolt.Tx) error {
+		bucket := nestedBucket(tx.Bucket(visitorsBucket), key)
+		if bucket == nil {
+			return nil
+		}
+		c := bucket.Cursor()
+		for k, v := c.Seek(rollupKey(dbpkg.GranularityDay.Truncate(from))); k != nil; k, v = c.Next() {
+			if !time.Unix(int64(binary.BigEndian.Uint64(k)), 0).Before(to) {
+				break
+			}
+			var sketch hll.Sketch
+			if err := sketch.UnmarshalBinary(v); err != nil {
+				return fmt.Errorf("Error decoding visitors of %q: %w", key, err)
+			}
+			merged.Merge(&sketch)
+		}
+		return nil
+	})
+	return merged.Count(), err
+}
+
+// VisitorSalt returns the salt of the day starting at day, creating it if
+// needed and deleting the salts of earlier days.
+func (db BoltDB) VisitorSalt(ctx context.Context, day time.Time) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var salt []byte
+	err := db.stats.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(saltsBucket)
+		if err != nil {
+			return fmt.Errorf("Error opening/creating bucket 'salts': %w", err)
+		}
+		dayKey := rollupKey(day)
+		c := bucket.Cursor()
+		for k, _ := c.First(); k != nil && bytes.Compare(k, dayKey) < 0; k, _ = c.First() {
+			if err := c.Delete(); err != nil {
+				return err
+			}
+		}
+		if salt = bytes.Clone(bucket.Get(dayKey)); salt != nil {
+			return nil
+		}
+		salt = make([]byte, 32)
+		// rand.Read never returns an error
+		_, _ = rand.Read(salt)
+		return bucket.Put(dayKey, salt)
+	})
+	return salt, err
+}
+
+// visitorSketch returns the visitors of key on the day encoded in dayKey.
+func visitorSketch(tx *bolt.Tx, key []byte, dayKey []byte) (*hll.Sketch, error) {
+	sketch := hll.New()
+	bucket := nestedBucket(tx.Bucket(visitorsBucket), key)
+	if bucket == nil {
+		return sketch, nil
+	}
+	if data := bucket.Get(dayKey); data != nil {
+		if err := sketch.UnmarshalBinary(data); err != nil {
+			return nil, fmt.Errorf("Error decoding visitors of %q: %w", key, err)
+		}
+	}
+	return sketch, nil
+}
+
+// nestedBucket descends into the nested buckets named by names, returning nil
+// if any of them doesn't exist.
+func nestedBucket(bucket *bolt.Bucket, names ...[]byte) *bolt.Bucket {
+	for _, name := range names {
+		if bucket == nil {
+			return nil
+		}
+		bucket = bucket.Bucket(name)
+	}
+	return bucket
+}
+
+// createNestedBucket is like nestedBucket but creates missing buckets.
+func createNestedBucket(tx *bolt.Tx, names ...[]byte) (*bolt.Bucket, error) {
+	bucket, err := tx.CreateBucketIfNotExists(names[0])
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names[1:] {
+		if bucket, err = bucket.CreateBucketIfNotExists(name); err != nil {
+			return nil, err
+		}
+	}
+	return bucket, nil
+}
+
+func rollupKey(start time.Time) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(start.Unix()))
+}
+
+// storeClick stores click and updates the counters and rollups of its key.
+// Clicks by bots aren't added to the total, just like they aren't counted as
+// clicks in the rollups.
+func storeClick(tx *bolt.Tx, click dbpkg.Click) error {
+	if click.Bot == "" {
+		views, err := tx.CreateBucketIfNotExists(viewsBucket)
+		if err != nil {
+			return fmt.Errorf("Error opening/creating bucket 'views': %w", err)
+		}
+		var count uint64
+		if countBytes := views.Get(click.Key); countBytes != nil {
+			count, err = strconv.ParseUint(string(countBytes), 10, 64)
+			if err != nil {
+				return fmt.Errorf("Error decoding views for %s: %w", click.Key, err)
+			}
+		}
+		if err := views.Put(click.Key, []byte(strconv.FormatUint(count+1, 10))); err != nil {
+			return err
+		}
+	}
+
+	clicks, err := createNestedBucket(tx, clicksBucket, click.Key)
+	if err != nil {
+		return fmt.Errorf("Error opening/creating clicks bucket for %q: %w", click.Key, err)
+	}
+	seq, err := clicks.NextSequence()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(click)
+	if err != nil {
+		return err
+	}
+	clickKey := binary.BigEndian.AppendUint64(nil, uint64(click.Time.UnixNano()))
+	clickKey = binary.BigEndian.AppendUint64(clickKey, seq)
+	if err := clicks.Put(clickKey, data); err != nil {
+		return err
+	}
+
+	for _, granularity := range granularities {
+		rollups, err := createNestedBucket(tx, rollupsBucket, click.Key, []byte(granularity))
+		if err != nil {
+			return fmt.Errorf("Error opening/creating rollups bucket for %q: %w", click.Key, err)
+		}
+		start := granularity.Truncate(click.Time)
+		stats := dbpkg.NewClickStats(start)
+		if data := rollups.Get(rollupKey(start)); data != nil {
+			if err := json.Unmarshal(data, &stats); err != nil {
+				return fmt.Errorf("Error decoding rollup of %q: %w", click.Key, err)
+			}
+		}
+		stats.Add(click)
+		if data, err = json.Marshal(stats); err != nil {
+			return err
+		}
+		if err := rollups.Put(rollupKey(start), data); err != nil {
+			return err
+		}
+	}
+
+	if click.Visitor != 0 {
+		dayKey := rollupKey(dbpkg.GranularityDay.Truncate(click.Time))
+		sketch, err := visitorSketch(tx, click.Key, dayKey)
+		if err != nil {
+			return err
+		}
+		sketch.Add(click.Visitor)
+		visitors, err := createNestedBucket(tx, visitorsBucket, click.Key)
+		if err != nil {
+			return fmt.Errorf("Error opening/creating visitors bucket for %q: %w", click.Key, err)
+		}
+		if data, err = sketch.MarshalBinary(); err != nil {
+			return err
+		}
+		if err := visitors.Put(dayKey, data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// collectStats stores all clicks received from clicks until the channel is
+// closed and then closes done. Clicks are written in batches to reduce the
+// number of transactions under load.
+func collectStats(db *bolt.DB, clicks <-chan dbpkg.Click, done chan<- struct{}, logger *slog.Logger) {
+	defer close(done)
+	for click := range clicks {
+		batch := []dbpkg.Click{click}
+	drain:
+		for len(batch) < 100 {
+			select {
+			case click, ok := <-clicks:
+				if !ok {
+					break drain
+				}
+				batch = append(batch, click)
+			default:
+				break drain
+			}
+		}
+
+		err := db.Update(func(tx *bolt.Tx) error {
+			for _, click := range batch {
+				if err := storeClick(tx, click); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			logger.Error("failed storing clicks", "count", len(batch), "error", err)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package boltdb_test
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/makkes/shorty/assert"
+	"github.com/makkes/shorty/boltdb"
+	dbpkg "github.com/makkes/shorty/db"
+)
+
+func openDB(t *testing.T) boltdb.BoltDB {
+	db, err := boltdb.NewBoltDB(slog.Default())
+	if err != nil {
+		t.Fatalf("failed opening DB: %v", err)
+	}
+	return db.(boltdb.BoltDB)
+}
+
+func TestClicksAreRolledUp(t *testing.T) {
+	t.Setenv("DB_DIR", t.TempDir())
+	db := openDB(t)
+
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	for _, click := range []dbpkg.Click{
+		{Key: []byte("a"), Time: day.Add(10 * time.Minute), Referrer: "example.org", Browser: "Firefox", Country: "DE", Visitor: 0xdeadbeef00000000},
+		{Key: []byte("a"), Time: day.Add(20 * time.Minute), Browser: "Firefox", Visitor: 0xdeadbeef00000000},
+		{Key: []byte("a"), Time: day.Add(3 * time.Hour), Browser: "Chrome", Visitor: 0xcafebabe00000000},
+		{Key: []byte("a"), Time: day.Add(3 * time.Hour), Browser: "Chrome", Bot: "unfurler"},
+		{Key: []byte("a"), Time: day.AddDate(0, 0, 1), Browser: "Chrome", Visitor: 0xdeadbeef00000000},
+		{Key: []byte("b"), Time: day, Browser: "Chrome"},
+	} {
+		if err := db.RecordClick(t.Context(), click); err != nil {
+			t.Fatalf("failed recording click: %v", err)
+		}
+	}
+	// closing waits for all queued clicks to be stored
+	if err := db.Close(); err != nil {
+		t.Fatalf("failed closing DB: %v", err)
+	}
+	db = openDB(t)
+	defer db.Close()
+
+	assert := assert.NewAssert(t)
+
+	hourly, err := db.GetClickStats(t.Context(), []byte("a"), day, day.AddDate(0, 0, 1), dbpkg.GranularityHour)
+	assert.Nil(err, "unexpected error")
+	assert.Equal(len(hourly), 2, "unexpected number of hourly periods")
+	assert.Equal(hourly[0].Start.Equal(day), true, "unexpected start of first period")
+	assert.Equal(hourly[0].Clicks, uint64(2), "unexpected clicks in first hour")
+	assert.Equal(hourly[0].Browsers["Firefox"], uint64(2), "unexpected browser count")
+	assert.Equal(hourly[0].Referrers["example.org"], uint64(1), "unexpected referrer count")
+	assert.Equal(hourly[0].Referrers["direct"], uint64(1), "unexpected direct count")
+	assert.Equal(hourly[0].Countries["DE"], uint64(1), "unexpected country count")
+	assert.Equal(hourly[0].Countries["unknown"], uint64(1), "unexpected unknown country count")
+	assert.Equal(hourly[1].Clicks, uint64(1), "unexpected clicks in second hour")
+	assert.Equal(hourly[1].BotClicks, uint64(1), "unexpected bot clicks in second hour")
+	assert.Equal(hourly[1].Bots["unfurler"], uint64(1), "unexpected unfurler count")
+
+	daily, err := db.GetClickStats(t.Context(), []byte("a"), day, day.AddDate(0, 0, 7), dbpkg.GranularityDay)
+	assert.Nil(err, "unexpected error")
+	assert.Equal(len(daily), 2, "unexpected number of daily periods")
+	assert.Equal(daily[0].Clicks, uint64(3), "unexpected clicks on first day")
+	assert.Equal(daily[0].BotClicks, uint64(1), "unexpected bot clicks on first day")
+	assert.Equal(daily[1].Clicks, uint64(1), "unexpected clicks on second day")
+	assert.Equal(daily[0].UniqueVisitors, uint64(2), "unexpected unique visitors on first day")
+	assert.Equal(daily[1].UniqueVisitors, uint64(1), "unexpected unique visitors on second day")
+
+	visitors, err := db.GetUniqueVisitors(t.Context(), []byte("a"), day, day.AddDate(0, 0, 7))
+	assert.Nil(err, "unexpected error")
+	assert.Equal(visitors, uint64(2), "unexpected unique visitors")
+	visitors, err = db.GetUniqueVisitors(t.Context(), []byte("b"), day, day.AddDate(0, 0, 7))
+	assert.Nil(err, "unexpected error")
+	assert.Equal(visitors, uint64(0), "unexpected unique visitors without identified visitors")
+
+	none, err := db.GetClickStats(t.Context(), []byte("unknown"), day, day.AddDate(0, 0, 7), dbpkg.GranularityDay)
+	assert.Nil(err, "unexpected error")
+	assert.Equal(len(none), 0, "unexpected stats for unknown key")
+}
+
+func TestVisitorSaltsAreKeptPerDay(t *testing.T) {
+	t.Setenv("DB_DIR", t.TempDir())
+	db := openDB(t)
+	assert := assert.NewAssert(t)
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	salt, err := db.VisitorSalt(t.Context(), day)
+	assert.Nil(err, "unexpected error")
+	assert.Equal(len(salt), 32, "unexpected salt length")
+	assert.Nil(db.Close(), "unexpected error")
+
+	db = openDB(t)
+	defer db.Close()
+	again, err := db.VisitorSalt(t.Context(), day)
+	assert.Nil(err, "unexpected error")
+	assert.Equal(string(again), string(salt), "salt must be kept across restarts")
+	next, err := db.VisitorSalt(t.Context(), day.AddDate(0, 0, 1))
+	assert.Nil(err, "unexpected error")
+	assert.Equal(string(next) == string(salt), false, "salt must differ on the next day")
+	again, err = db.VisitorSalt(t.Context(), day)
+	assert.Nil(err, "unexpected error")
+	assert.Equal(string(again) == string(salt), false, "salt of the previous day must have been deleted")
+}
+
+func TestExportIteratesLinksAndClicks(t *testing.T) {
+	t.Setenv("DB_DIR", t.TempDir())
+	db := openDB(t)
+
+	assert := assert.NewAssert(t)
+	assert.Nil(db.SaveURL(t.Context(), "http://example.org", []byte("b")), "unexpected error")
+	assert.Nil(db.SaveURL(t.Context(), "http://example.com", []byte("a")), "unexpected error")
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	for _, click := range []dbpkg.Click{
+		{Key: []byte("b"), Time: day.Add(time.Hour), Browser: "Firefox"},
+		{Key: []byte("a"), Time: day.AddDate(0, 0, 1)},
+		{Key: []byte("a"), Time: day.Add(time.Minute), Bot: "crawler"},
+		{Key: []byte("a"), Time: day.Add(-time.Minute)},
+	} {
+		assert.Nil(db.RecordClick(t.Context(), click), "unexpected error")
+	}
+	assert.Nil(db.Close(), "unexpected error")
+	db = openDB(t)
+	defer db.Close()
+
+	var links []string
+	assert.Nil(db.ListURLs(t.Context(), func(link dbpkg.Link) error {
+		links = append(links, fmt.Sprintf("%s=%s(%d)", link.Key, link.URL, link.Clicks))
+		return nil
+	}), "unexpected error")
+	assert.Equal(strings.Join(links, " "), "a=http://example.com(2) b=http://example.org(1)", "unexpected links or bot clicks counted in the total")
+
+	var clicks []dbpkg.Click
+	assert.Nil(db.ForEachClick(t.Context(), day, day.AddDate(0, 0, 1), func(click dbpkg.Click) error {
+		clicks = append(clicks, click)
+		return nil
+	}), "unexpected error")
+	assert.Equal(len(clicks), 2, "unexpected number of clicks")
+	assert.Equal(string(clicks[0].Key), "a", "unexpected key of first click")
+	assert.Equal(clicks[0].Bot, "crawler", "unexpected bot of first click")
+	assert.Equal(string(clicks[1].Key), "b", "unexpected key of second click")
+	assert.Equal(clicks[1].Browser, "Firefox", "unexpected browser of second click")
+}
+
+func TestExportReadsInPages(t *testing.T) {
+	t.Setenv("DB_DIR", t.TempDir())
+	db := openDB(t)
+
+	assert := assert.NewAssert(t)
+	start := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	for i := range 2100 {
+		click := dbpkg.Click{Key: []byte{byte('a' + i%2)}, Time: start.Add(time.Duration(i) * time.Second)}
+		for db.RecordClick(t.Context(), click) != nil {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	for i := range 1001 {
+		assert.Nil(db.SaveURL(t.Context(), "http://example.org", fmt.Appendf(nil, "%04d", i)), "unexpected error")
+	}
+	assert.Nil(db.Close(), "unexpected error")
+	db = openDB(t)
+	defer db.Close()
+
+	var links []string
+	assert.Nil(db.ListURLs(t.Context(), func(link dbpkg.Link) error {
+		links = append(links, link.Key)
+		return nil
+	}), "unexpected error")
+	assert.Equal(len(links), 1001, "unexpected number of links")
+	assert.Equal(slices.IsSorted(links) && len(slices.Compact(links)) == 1001, true, "links must be listed once and in order")
+
+	var clicks []dbpkg.Click
+	assert.Nil(db.ForEachClick(t.Context(), start, start.Add(time.Hour), func(click dbpkg.Click) error {
+		clicks = append(clicks, click)
+		return nil
+	}), "unexpected error")
+	assert.Equal(len(clicks), 2100, "unexpected number of clicks")
+	for i, click := range clicks {
+		key, offset := "a", 2*i
+		if i >= 1050 {
+			key, offset = "b", 2*(i-1050)+1
+		}
+		if string(click.Key) != key || !click.Time.Equal(start.Add(time.Duration(offset)*time.Second)) {
+			t.Fatalf("unexpected click %d: %s at %s", i, click.Key, click.Time)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package boltdb
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	dbpkg "github.com/makkes/shorty/db"
+)
+
+var _ dbpkg.Exporter = BoltDB{}
+
+// exportPageSize is the number of clicks or links read in a single
+// transaction when iterating over them. Reading in pages keeps transactions
+// short when callers are slow, e.g. when streaming to a remote client, as
+// open read transactions keep Bolt from growing the database file.
+const exportPageSize = 1000
+
+// ForEachClick calls fn for the clicks between from and to, reading them in
+// pages.
+func (db BoltDB) ForEachClick(ctx context.Context, from, to time.Time, fn func(dbpkg.Click) error) error {
+	fromKey := binary.BigEndian.AppendUint64(nil, uint64(from.UnixNano()))
+	toKey := binary.BigEndian.AppendUint64(nil, uint64(to.UnixNano()))
+	// the key of the link and the click read last
+	var lastLink, lastClick []byte
+	for {
+		page := make([]dbpkg.Click, 0, exportPageSize)
+		err := db.stats.View(func(tx *bolt.Tx) error {
+			clicks := tx.Bucket(clicksBucket)
+			if clicks == nil {
+				return nil
+			}
+			keys := clicks.Cursor()
+			key, _ := keys.First()
+			if lastLink != nil {
+				key, _ = keys.Seek(lastLink)
+			}
+			for ; key != nil; key, _ = keys.Next() {
+				bucket := clicks.Bucket(key)
+				if bucket == nil {
+					continue
+				}
+				c := bucket.Cursor()
+				k, v := c.Seek(fromKey)
+				if bytes.Equal(key, lastLink) {
+					if k, v = c.Seek(lastClick); bytes.Equal(k, lastClick) {
+						k, v = c.Next()
+					}
+				}
+				for ; k != nil && bytes.Compare(k[:8], toKey) < 0; k, v = c.Next() {
+					if len(page) == exportPageSize {
+						return nil
+					}
+					click := dbpkg.Click{Key: bytes.Clone(key)}
+					if err := json.Unmarshal(v, &click); err != nil {
+						return fmt.Errorf("Error decoding click of %q: %w", key, err)
+					}
+					page = append(page, click)
+					lastLink, lastClick = click.Key, bytes.Clone(k)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, click := range page {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := fn(click); err != nil {
+				return err
+			}
+		}
+		if len(page) < exportPageSize {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package boltdb
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	dbpkg "github.com/makkes/shorty/db"
+)
+
+// Pending deliveries are kept in the queue bucket until they are completed
+// and moved to the log bucket. Both are keyed by the big-endian delivery ID.
+// The due bucket indexes pending deliveries by the time of their next attempt
+// so that due deliveries are found without reading the whole queue.
+var (
+	deliveryQueueBucket = []byte("webhook_queue")
+	deliveryDueBucket   = []byte("webhook_due")
+	deliveryLogBucket   = []byte("webhook_log")
+)
+
+// dueKey returns the key of d in the due bucket: the big-endian Unix
+// nanoseconds of its next attempt followed by its ID.
+func dueKey(d dbpkg.Delivery) []byte {
+	var due int64
+	if !d.NextAttempt.IsZero() {
+		due = max(d.NextAttempt.UnixNano(), 0)
+	}
+	return binary.BigEndian.AppendUint64(binary.BigEndian.AppendUint64(nil, uint64(due)), d.ID)
+}
+
+// maxLoggedDeliveries is the number of completed deliveries kept in the log.
+const maxLoggedDeliveries = 1000
+
+var _ dbpkg.Webhooks = BoltDB{}
+
+func (db BoltDB) SaveDelivery(ctx context.Context, d *dbpkg.Delivery) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		queue, err := tx.CreateBucketIfNotExists(deliveryQueueBucket)
+		if err != nil {
+			return fmt.Errorf("Error opening/creating bucket 'webhook_queue': %w", err)
+		}
+		due, err := tx.CreateBucketIfNotExists(deliveryDueBucket)
+		if err != nil {
+			return fmt.Errorf("Error opening/creating bucket 'webhook_due': %w", err)
+		}
+		logged, err := tx.CreateBucketIfNotExists(deliveryLogBucket)
+		if err != nil {
+			return fmt.Errorf("Error opening/creating bucket 'webhook_log': %w", err)
+		}
+		if d.ID == 0 {
+			if d.ID, err = queue.NextSequence(); err != nil {
+				return err
+			}
+		}
+		data, err := json.Marshal(d)
+		if err != nil {
+			return err
+		}
+		key := binary.BigEndian.AppendUint64(nil, d.ID)
+		if queued := queue.Get(key); queued != nil {
+			var prev dbpkg.Delivery
+			if err := json.Unmarshal(queued, &prev); err != nil {
+				return fmt.Errorf("Error decoding delivery %d: %w", d.ID, err)
+			}
+			if err := due.Delete(dueKey(prev)); err != nil {
+				return err
+			}
+		}
+		if d.Status == dbpkg.DeliveryPending {
+			if err := due.Put(dueKey(*d), []byte{}); err != nil {
+				return err
+			}
+			return queue.Put(key, data)
+		}
+		if err := queue.Delete(key); err != nil {
+			return err
+		}
+		if err := logged.Put(key, data); err != nil {
+			return err
+		}
+		// skip the newest entries and drop all older ones
+		c := logged.Cursor()
+		k, _ := c.Last()
+		for n := 0; k != nil && n < maxLoggedDeliveries; n++ {
+			k, _ = c.Prev()
+		}
+		for ; k != nil; k, _ = c.Prev() {
+			if err := c.Delete(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (db BoltDB) DueDeliveries(ctx context.Context, now time.Time, limit int) ([]dbpkg.Delivery, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	res := make([]dbpkg.Delivery, 0)
+	end := dueKey(dbpkg.Delivery{NextAttempt: now, ID: math.MaxUint64})
+	err := db.View(func(tx *bolt.Tx) error {
+		queue, due := tx.Bucket(deliveryQueueBucket), tx.Bucket(deliveryDueBucket)
+		if queue == nil || due == nil {
+			return nil
+		}
+		c := due.Cursor()
+		for k, _ := c.First(); k != nil && bytes.Compare(k, end) <= 0 && len(res) < limit; k, _ = c.Next() {
+			id := k[8:]
+			var d dbpkg.Delivery
+			if err := json.Unmarshal(queue.Get(id), &d); err != nil {
+				return fmt.Errorf("Error decoding delivery %d: %w", binary.BigEndian.Uint64(id), err)
+			}
+			res = append(res, d)
+		}
+		return nil
+	})
+	return res, err
+}
+
+func (db BoltDB) Deliveries(ctx context.Context, limit int) ([]dbpkg.Delivery, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	res := make([]dbpkg.Delivery, 0)
+	err := db.View(func(tx *bolt.Tx) error {
+		// IDs are assigned in order, so merging both buckets from their
+		// ends yields the newest deliveries first
+		var cursors []*bolt.Cursor
+		for _, name := range [][]byte{deliveryQueueBucket, deliveryLogBucket} {
+			if bucket := tx.Bucket(name); bucket != nil {
+				cursors = append(cursors, bucket.Cursor())
+			}
+		}
+		keys := make([][]byte, len(cursors))
+		values := make([][]byte, len(cursors))
+		for idx, c := range cursors {
+			keys[idx], values[idx] = c.Last()
+		}
+		for len(res) < limit {
+			newest := -1
+			for idx, k := range keys {
+				if k != nil && (newest == -1 || binary.BigEndian.Uint64(k) > binary.BigEndian.Uint64(keys[newest])) {
+					newest = idx
+				}
+			}
+			if newest == -1 {
+				break
+			}
+			var d dbpkg.Delivery
+			if err := json.Unmarshal(values[newest], &d); err != nil {
+				return fmt.Errorf("Error decoding delivery %d: %w", binary.BigEndian.Uint64(keys[newest]), err)
+			}
+			res = append(res, d)
+			keys[newest], values[newest] = cursors[newest].Prev()
+		}
+		return nil
+	})
+	return res, err
+}
@@ -0,0 +1,74 @@
+package boltdb_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/makkes/shorty/assert"
+	dbpkg "github.com/makkes/shorty/db"
+)
+
+func TestDeliveriesAreQueuedAndLogged(t *testing.T) {
+	t.Setenv("DB_DIR", t.TempDir())
+	db := openDB(t)
+	defer db.Close()
+
+	assert := assert.NewAssert(t)
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	first := dbpkg.Delivery{Endpoint: "a", Payload: []byte(`{}`), Status: dbpkg.DeliveryPending, NextAttempt: now}
+	second := dbpkg.Delivery{Endpoint: "b", Payload: []byte(`{}`), Status: dbpkg.DeliveryPending, NextAttempt: now.Add(time.Minute)}
+	assert.Nil(db.SaveDelivery(t.Context(), &first), "unexpected error")
+	assert.Nil(db.SaveDelivery(t.Context(), &second), "unexpected error")
+	assert.Equal(first.ID, uint64(1), "unexpected ID of first delivery")
+	assert.Equal(second.ID, uint64(2), "unexpected ID of second delivery")
+
+	due, err := db.DueDeliveries(t.Context(), now, 10)
+	assert.Nil(err, "unexpected error")
+	assert.Equal(len(due), 1, "unexpected number of due deliveries")
+	assert.Equal(due[0].Endpoint, "a", "unexpected due delivery")
+
+	first.Status = dbpkg.DeliverySucceeded
+	assert.Nil(db.SaveDelivery(t.Context(), &first), "unexpected error")
+	due, err = db.DueDeliveries(t.Context(), now.Add(time.Hour), 10)
+	assert.Nil(err, "unexpected error")
+	assert.Equal(len(due), 1, "unexpected number of due deliveries")
+	assert.Equal(due[0].Endpoint, "b", "unexpected due delivery")
+
+	second.NextAttempt = now.Add(2 * time.Hour)
+	assert.Nil(db.SaveDelivery(t.Context(), &second), "unexpected error")
+	due, err = db.DueDeliveries(t.Context(), now.Add(time.Hour), 10)
+	assert.Nil(err, "unexpected error")
+	assert.Equal(len(due), 0, "rescheduled delivery must not be due")
+	due, err = db.DueDeliveries(t.Context(), now.Add(2*time.Hour), 10)
+	assert.Nil(err, "unexpected error")
+	assert.Equal(len(due), 1, "unexpected number of due deliveries")
+	assert.Equal(due[0].NextAttempt.Equal(now.Add(2*time.Hour)), true, "unexpected next attempt of due delivery")
+
+	third := dbpkg.Delivery{Endpoint: "c", Payload: []byte(`{}`), Status: dbpkg.DeliveryFailed}
+	assert.Nil(db.SaveDelivery(t.Context(), &third), "unexpected error")
+	all, err := db.Deliveries(t.Context(), 10)
+	assert.Nil(err, "unexpected error")
+	assert.Equal(len(all), 3, "unexpected number of deliveries")
+	assert.Equal(all[0].Endpoint, "c", "unexpected newest delivery")
+	assert.Equal(all[1].Status, dbpkg.DeliveryPending, "unexpected status of pending delivery")
+	assert.Equal(all[2].Status, dbpkg.DeliverySucceeded, "unexpected status of completed delivery")
+	all, err = db.Deliveries(t.Context(), 2)
+	assert.Nil(err, "unexpected error")
+	assert.Equal(len(all), 2, "limit has not been applied")
+}
+
+func TestDeliveryLogIsTrimmed(t *testing.T) {
+	t.Setenv("DB_DIR", t.TempDir())
+	db := openDB(t)
+	defer db.Close()
+
+	assert := assert.NewAssert(t)
+	for range 1005 {
+		assert.Nil(db.SaveDelivery(t.Context(), &dbpkg.Delivery{Payload: []byte(`{}`), Status: dbpkg.DeliverySucceeded}), "unexpected error")
+	}
+	all, err := db.Deliveries(t.Context(), 2000)
+	assert.Nil(err, "unexpected error")
+	assert.Equal(len(all), 1000, "unexpected number of logged deliveries")
+	assert.Equal(all[0].ID, uint64(1005), "unexpected newest delivery")
+	assert.Equal(all[999].ID, uint64(6), "unexpected oldest delivery")
+}
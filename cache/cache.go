@@ -0,0 +1,221 @@
+// Package cache provides a read-through cache for the links of any db.DB.
+package cache
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	dbpkg "github.com/makkes/shorty/db"
+)
+
+// resultKey tells in the span of a lookup whether it has been answered from
+// the cache, shared with a concurrent lookup or passed to the wrapped DB.
+const resultKey = attribute.Key("cache.result")
+
+// Options configure a DB.
+type Options struct {
+	// Size is the maximum number of cached keys, including unknown ones.
+	Size int
+	// TTL is the time after which cached links are looked up again.
+	TTL time.Duration
+	// NegativeTTL is the time after which unknown keys are looked up again.
+	// Unknown keys aren't cached if it is zero.
+	NegativeTTL time.Duration
+}
+
+// Stats are the counters of a DB.
+type Stats struct {
+	// Hits counts the lookups answered from the cache, including
+	// NegativeHits for unknown keys.
+	Hits         uint64 `json:"hits"`
+	NegativeHits uint64 `json:"negativeHits"`
+	// Misses counts the lookups not answered from the cache, including
+	// Shared lookups that waited for a concurrent lookup of the same key.
+	Misses    uint64 `json:"misses"`
+	Shared    uint64 `json:"shared"`
+	Evictions uint64 `json:"evictions"`
+	Entries   int    `json:"entries"`
+	Size      int    `json:"size"`
+}
+
+// A DB caches the URLs returned by GetURL of the wrapped DB in a
+// size-bounded LRU. Concurrent lookups of the same missing key are collapsed
+// into one. Cached keys are invalidated when saved through the DB, so other
+// writers to the wrapped DB, e.g. other instances sharing a database, are
+// only seen after the TTL.
+type DB struct {
+	dbpkg.DB
+
+	opts Options
+	now  func() time.Time
+
+	mu      sync.Mutex
+	lru     *list.List // of *entry, most recently used first
+	entries map[string]*list.Element
+	loads   map[string]*load
+	// gen is incremented on every invalidation so that loads started before
+	// don't cache stale URLs.
+	gen   uint64
+	stats Stats
+}
+
+var _ dbpkg.DB = (*DB)(nil)
+var _ dbpkg.LinkSaver = (*DB)(nil)
+
+type entry struct {
+	key     string
+	url     []byte // nil for unknown keys
+	expires time.Time
+}
+
+// load is a lookup in the wrapped DB that other lookups of the same key wait
+// for.
+type load struct {
+	done chan struct{}
+	url  []byte
+	err  error
+}
+
+// New returns a DB caching the links of db.
+func New(db dbpkg.DB, opts Options) *DB {
+	return &DB{
+		DB:      db,
+		opts:    opts,
+		now:     time.Now,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+		loads:   make(map[string]*load),
+	}
+}
+
+// GetURL returns the URL stored under key, looking it up in the wrapped DB
+// if it isn't cached.
+func (c *DB) GetURL(ctx context.Context, key []byte) ([]byte, error) {
+	k := string(key)
+	span := trace.SpanFromContext(ctx)
+	c.mu.Lock()
+	if el, ok := c.entries[k]; ok {
+		e := el.Value.(*entry)
+		if c.now().Before(e.expires) {
+			c.lru.MoveToFront(el)
+			c.stats.Hits++
+			if e.url == nil {
+				c.stats.NegativeHits++
+			}
+			c.mu.Unlock()
+			span.SetAttributes(resultKey.String("hit"))
+			return e.url, nil
+		}
+		c.remove(el)
+	}
+	c.stats.Misses++
+	if l, ok := c.loads[k]; ok {
+		c.stats.Shared++
+		c.mu.Unlock()
+		span.SetAttributes(resultKey.String("shared"))
+		select {
+		case <-l.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if l.err != nil && ctx.Err() == nil && (errors.Is(l.err, context.Canceled) || errors.Is(l.err, context.DeadlineExceeded)) {
+			// the lookup has been cancelled by the client that started it
+			return c.GetURL(ctx, key)
+		}
+		return l.url, l.err
+	}
+	l := &load{done: make(chan struct{})}
+	c.loads[k] = l
+	gen := c.gen
+	c.mu.Unlock()
+	span.SetAttributes(resultKey.String("miss"))
+
+	url, err := c.DB.GetURL(ctx, key)
+	// the wrapped DB may reuse the memory after the lookup
+	l.url, l.err = bytes.Clone(url), err
+
+	c.mu.Lock()
+	delete(c.loads, k)
+	if err == nil && gen == c.gen {
+		c.add(k, l.url)
+	}
+	c.mu.Unlock()
+	close(l.done)
+	return l.url, l.err
+}
+
+// add caches url under key, evicting the least recently used entry if the
+// cache is full. c.mu must be held.
+func (c *DB) add(key string, url []byte) {
+	ttl := c.opts.TTL
+	if url == nil {
+		ttl = c.opts.NegativeTTL
+	}
+	if ttl <= 0 || c.opts.Size <= 0 {
+		return
+	}
+	c.entries[key] = c.lru.PushFront(&entry{key: key, url: url, expires: c.now().Add(ttl)})
+	for c.lru.Len() > c.opts.Size {
+		c.remove(c.lru.Back())
+		c.stats.Evictions++
+	}
+}
+
+// remove removes el from the cache. c.mu must be held.
+func (c *DB) remove(el *list.Element) {
+	c.lru.Remove(el)
+	delete(c.entries, el.Value.(*entry).key)
+}
+
+// Invalidate removes key from the cache. It must be called whenever the
+// link is changed or deleted without going through c.
+func (c *DB) Invalidate(key []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	if el, ok := c.entries[string(key)]; ok {
+		c.remove(el)
+	}
+}
+
+// SaveURL saves url under key in the wrapped DB.
+func (c *DB) SaveURL(ctx context.Context, url string, key []byte) error {
+	defer c.Invalidate(key)
+	return c.DB.SaveURL(ctx, url, key)
+}
+
+// ReplaceURL replaces the URL stored under key in the wrapped DB.
+func (c *DB) ReplaceURL(ctx context.Context, url string, key []byte) error {
+	defer c.Invalidate(key)
+	return c.DB.ReplaceURL(ctx, url, key)
+}
+
+// SaveLink saves link in the wrapped DB, dropping its metadata if the
+// wrapped DB doesn't support it.
+func (c *DB) SaveLink(ctx context.Context, link dbpkg.Link, replace bool) error {
+	defer c.Invalidate([]byte(link.Key))
+	if saver, ok := c.DB.(dbpkg.LinkSaver); ok {
+		return saver.SaveLink(ctx, link, replace)
+	}
+	if replace {
+		return c.DB.ReplaceURL(ctx, link.URL, []byte(link.Key))
+	}
+	return c.DB.SaveURL(ctx, link.URL, []byte(link.Key))
+}
+
+// Stats returns the current counters.
+func (c *DB) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Entries = c.lru.Len()
+	stats.Size = c.opts.Size
+	return stats
+}
@@ -0,0 +1,170 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/makkes/shorty/assert"
+	dbpkg "github.com/makkes/shorty/db"
+)
+
+// countingDB counts the lookups of every key. Lookups block while block is
+// set.
+type countingDB struct {
+	mu      sync.Mutex
+	urls    map[string]string
+	lookups map[string]int
+	block   chan struct{}
+}
+
+func newCountingDB(urls map[string]string) *countingDB {
+	return &countingDB{urls: urls, lookups: make(map[string]int)}
+}
+
+func (db *countingDB) SaveURL(ctx context.Context, url string, key []byte) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if _, ok := db.urls[string(key)]; ok {
+		return dbpkg.NewErrKeyCollision(key)
+	}
+	db.urls[string(key)] = url
+	return nil
+}
+
+func (db *countingDB) ReplaceURL(ctx context.Context, url string, key []byte) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.urls[string(key)] = url
+	return nil
+}
+
+func (db *countingDB) GetURL(ctx context.Context, key []byte) ([]byte, error) {
+	if db.block != nil {
+		<-db.block
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.lookups[string(key)]++
+	if url, ok := db.urls[string(key)]; ok {
+		return []byte(url), nil
+	}
+	return nil, nil
+}
+
+func (db *countingDB) GetStats(ctx context.Context) (dbpkg.Stats, error) {
+	return dbpkg.Stats{StoredURLs: len(db.urls)}, nil
+}
+
+func (db *countingDB) ListURLs(ctx context.Context, fn func(dbpkg.Link) error) error {
+	return nil
+}
+
+func (db *countingDB) count(key string) int {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.lookups[key]
+}
+
+func TestLinksAreCached(t *testing.T) {
+	inner := newCountingDB(map[string]string{"a": "http://a", "b": "http://b"})
+	c := New(inner, Options{Size: 10, TTL: time.Minute, NegativeTTL: time.Second})
+	now := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	c.now = func() time.Time { return now }
+
+	assert := assert.NewAssert(t)
+	for range 3 {
+		url, err := c.GetURL(t.Context(), []byte("a"))
+		assert.Nil(err, "unexpected error")
+		assert.Equal(string(url), "http://a", "unexpected URL")
+		url, err = c.GetURL(t.Context(), []byte("unknown"))
+		assert.Nil(err, "unexpected error")
+		assert.Nil(url, "unexpected URL for unknown key")
+	}
+	assert.Equal(inner.count("a"), 1, "link has not been cached")
+	assert.Equal(inner.count("unknown"), 1, "unknown key has not been cached")
+	assert.Equal(c.Stats(), Stats{Hits: 4, NegativeHits: 2, Misses: 2, Entries: 2, Size: 10}, "unexpected stats")
+
+	// unknown keys expire first
+	now = now.Add(2 * time.Second)
+	c.GetURL(t.Context(), []byte("a"))
+	c.GetURL(t.Context(), []byte("unknown"))
+	assert.Equal(inner.count("a"), 1, "link has expired too early")
+	assert.Equal(inner.count("unknown"), 2, "unknown key has not expired")
+	now = now.Add(time.Minute)
+	c.GetURL(t.Context(), []byte("a"))
+	assert.Equal(inner.count("a"), 2, "link has not expired")
+}
+
+func TestCacheIsInvalidatedOnUpdates(t *testing.T) {
+	inner := newCountingDB(map[string]string{"a": "http://a"})
+	c := New(inner, Options{Size: 10, TTL: time.Minute, NegativeTTL: time.Minute})
+
+	assert := assert.NewAssert(t)
+	c.GetURL(t.Context(), []byte("a"))
+	c.GetURL(t.Context(), []byte("b"))
+	assert.Nil(c.ReplaceURL(t.Context(), "http://new", []byte("a")), "unexpected error")
+	assert.Nil(c.SaveURL(t.Context(), "http://b", []byte("b")), "unexpected error")
+	url, _ := c.GetURL(t.Context(), []byte("a"))
+	assert.Equal(string(url), "http://new", "replaced link has not been invalidated")
+	url, _ = c.GetURL(t.Context(), []byte("b"))
+	assert.Equal(string(url), "http://b", "created link has not been invalidated")
+
+	assert.Nil(c.SaveLink(t.Context(), dbpkg.Link{Key: "a", URL: "http://newer"}, true), "unexpected error")
+	url, _ = c.GetURL(t.Context(), []byte("a"))
+	assert.Equal(string(url), "http://newer", "saved link has not been invalidated")
+
+	inner.urls["a"] = "http://deleted"
+	c.Invalidate([]byte("a"))
+	url, _ = c.GetURL(t.Context(), []byte("a"))
+	assert.Equal(string(url), "http://deleted", "link has not been invalidated")
+}
+
+func TestLeastRecentlyUsedLinksAreEvicted(t *testing.T) {
+	urls := map[string]string{}
+	for i := range 4 {
+		urls[fmt.Sprint(i)] = fmt.Sprintf("http://%d", i)
+	}
+	inner := newCountingDB(urls)
+	c := New(inner, Options{Size: 3, TTL: time.Minute})
+
+	assert := assert.NewAssert(t)
+	for _, key := range []string{"0", "1", "2", "0", "3", "0", "1"} {
+		c.GetURL(t.Context(), []byte(key))
+	}
+	assert.Equal(inner.count("0"), 1, "recently used link has been evicted")
+	assert.Equal(inner.count("1"), 2, "least recently used link has not been evicted")
+	stats := c.Stats()
+	assert.Equal(stats.Evictions, uint64(2), "unexpected number of evictions")
+	assert.Equal(stats.Entries, 3, "unexpected number of entries")
+}
+
+func TestConcurrentMissesAreCollapsed(t *testing.T) {
+	inner := newCountingDB(map[string]string{"a": "http://a"})
+	inner.block = make(chan struct{})
+	c := New(inner, Options{Size: 10, TTL: time.Minute})
+
+	var wg sync.WaitGroup
+	urls := make([]string, 10)
+	for i := range urls {
+		wg.Go(func() {
+			url, _ := c.GetURL(t.Context(), []byte("a"))
+			urls[i] = string(url)
+		})
+	}
+	// wait for all lookups to be queued behind the first one
+	for c.Stats().Misses < uint64(len(urls)) {
+		time.Sleep(time.Millisecond)
+	}
+	close(inner.block)
+	wg.Wait()
+
+	assert := assert.NewAssert(t)
+	assert.Equal(inner.count("a"), 1, "concurrent lookups have not been collapsed")
+	assert.Equal(c.Stats().Shared, uint64(len(urls)-1), "unexpected number of shared lookups")
+	for _, url := range urls {
+		assert.Equal(url, "http://a", "unexpected URL")
+	}
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/makkes/shorty/boltdb"
+	dbpkg "github.com/makkes/shorty/db"
+	"github.com/makkes/shorty/export"
+	"github.com/makkes/shorty/portable"
+)
+
+// commands are the administrative subcommands of the shorty binary. Running
+// it without any arguments starts the server.
+var commands = map[string]func(logger *slog.Logger, args []string) error{
+	"export":  exportCommand,
+	"backup":  backupCommand,
+	"restore": restoreCommand,
+	"dump":    dumpCommand,
+	"import":  importCommand,
+}
+
+// runCommand runs the subcommand name and exits the process on failure.
+func runCommand(logger *slog.Logger, name string, args []string) {
+	cmd, ok := commands[name]
+	if !ok {
+		fatal(logger, "unknown command", "command", name)
+	}
+	if err := cmd(logger, args); err != nil {
+		fatal(logger, "command failed", "command", name, "error", err)
+	}
+}
+
+// withDB opens the configured database, calls fn and closes the database
+// again. The context passed to fn is cancelled on interrupt.
+func withDB(logger *slog.Logger, fn func(ctx context.Context, db dbpkg.DB) error) error {
+	db, err := openDB(logger)
+	if err != nil {
+		return err
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	err = fn(ctx, db)
+	if closer, ok := db.(io.Closer); ok {
+		err = errors.Join(err, closer.Close())
+	}
+	return err
+}
+
+// exportCommand writes links or clicks to a file or stdout.
+func exportCommand(logger *slog.Logger, args []string) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: shorty export [flags] links|clicks\n")
+		flags.PrintDefaults()
+	}
+	formatName := flags.String("format", string(export.FormatCSV), "output format, csv or jsonl")
+	fromParam := flags.String("from", "", "export clicks from this date or RFC 3339 timestamp on (default: all)")
+	toParam := flags.String("to", "", "export clicks before this date or RFC 3339 timestamp (default: now)")
+	compress := flags.Bool("gzip", false, "compress the output using gzip")
+	output := flags.String("o", "-", "output file, - for stdout")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return errors.New("expected exactly one of 'links' or 'clicks'")
+	}
+	format, err := export.ParseFormat(*formatName)
+	if err != nil {
+		return err
+	}
+	from, to, err := exportRange(*fromParam, *toParam)
+	if err != nil {
+		return err
+	}
+
+	var write func(ctx context.Context, w io.Writer, db dbpkg.DB) error
+	switch what := flags.Arg(0); what {
+	case "links":
+		write = func(ctx context.Context, w io.Writer, db dbpkg.DB) error {
+			return export.Links(ctx, w, format, db)
+		}
+	case "clicks":
+		write = func(ctx context.Context, w io.Writer, db dbpkg.DB) error {
+			exporter, ok := db.(dbpkg.Exporter)
+			if !ok {
+				return errors.New("the backend doesn't support exporting clicks")
+			}
+			return export.Clicks(ctx, w, format, exporter, from, to)
+		}
+	default:
+		return fmt.Errorf("cannot export %q, expected 'links' or 'clicks'", what)
+	}
+
+	return withDB(logger, func(ctx context.Context, db dbpkg.DB) error {
+		out, err := createOutput(*output)
+		if err != nil {
+			return err
+		}
+		w := io.Writer(out)
+		var gz *gzip.Writer
+		if *compress {
+			gz = gzip.NewWriter(out)
+			w = gz
+		}
+		err = write(ctx, w, db)
+		if gz != nil {
+			err = errors.Join(err, gz.Close())
+		}
+		if out != os.Stdout {
+			err = errors.Join(err, out.Close())
+		}
+		return err
+	})
+}
+
+// createOutput opens the file at name for writing, or stdout if name is "-".
+func createOutput(name string) (*os.File, error) {
+	if name == "-" {
+		return os.Stdout, nil
+	}
+	return os.Create(name)
+}
+
+// backupCommand writes a snapshot of the database to a file or stdout,
+// either by opening the database or by downloading it from a running server.
+func backupCommand(logger *slog.Logger, args []string) error {
+	flags := flag.NewFlagSet("backup", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: shorty backup [flags]\n")
+		flags.PrintDefaults()
+	}
+	server := flags.String("server", "", "download the backup from the Shorty server at this URL, authenticating with $ADMIN_TOKEN")
+	compress := flags.Bool("gzip", false, "compress the backup using gzip")
+	output := flags.String("o", "-", "output file, - for stdout")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 0 {
+		flags.Usage()
+		return errors.New("unexpected arguments")
+	}
+
+	write := func(w io.Writer) error {
+		return withDB(logger, func(ctx context.Context, db dbpkg.DB) error {
+			backuper, ok := db.(dbpkg.Backuper)
+			if !ok {
+				return errors.New("the backend doesn't support backups")
+			}
+			return backuper.Backup(ctx, w)
+		})
+	}
+	if *server != "" {
+		// the server compresses the backup itself
+		compressed := *compress
+		*compress = false
+		write = func(w io.Writer) error {
+			return downloadBackup(w, strings.TrimSuffix(*server, "/")+"/admin/backup", compressed)
+		}
+	}
+
+	out, err := createOutput(*output)
+	if err != nil {
+		return err
+	}
+	w := io.Writer(out)
+	var gz *gzip.Writer
+	if *compress {
+		gz = gzip.NewWriter(out)
+		w = gz
+	}
+	err = write(w)
+	if gz != nil {
+		err = errors.Join(err, gz.Close())
+	}
+	if out != os.Stdout {
+		err = errors.Join(err, out.Close())
+	}
+	return err
+}
+
+// downloadBackup writes the backup served at url to w.
+func downloadBackup(w io.Writer, url string, compressed bool) error {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+os.Getenv("ADMIN_TOKEN"))
+	if compressed {
+		// setting the header explicitly keeps the response compressed
+		req.Header.Set("Accept-Encoding", "gzip")
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("server responded with %s", res.Status)
+	}
+	_, err = io.Copy(w, res.Body)
+	return err
+}
+
+// restoreCommand replaces the database with a backup. The server must be
+// stopped.
+func restoreCommand(logger *slog.Logger, args []string) error {
+	flags := flag.NewFlagSet("restore", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: shorty restore FILE\n\nReplaces the database with the backup in FILE (- for stdin). Shorty must be stopped.\n")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return errors.New("expected exactly one backup file")
+	}
+	if backend := os.Getenv("BACKEND"); backend != "" && backend != "bolt" {
+		return fmt.Errorf("restoring the %s backend is not supported", backend)
+	}
+	in := os.Stdin
+	if name := flags.Arg(0); name != "-" {
+		var err error
+		if in, err = os.Open(name); err != nil {
+			return err
+		}
+		defer in.Close()
+	}
+	return boltdb.Restore(in)
+}
+
+// dumpCommand writes all links in the portable JSON format to a file or
+// stdout.
+func dumpCommand(logger *slog.Logger, args []string) error {
+	flags := flag.NewFlagSet("dump", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: shorty dump [flags]\n")
+		flags.PrintDefaults()
+	}
+	output := flags.String("o", "-", "output file, - for stdout")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 0 {
+		flags.Usage()
+		return errors.New("unexpected arguments")
+	}
+	return withDB(logger, func(ctx context.Context, db dbpkg.DB) error {
+		out, err := createOutput(*output)
+		if err != nil {
+			return err
+		}
+		err = portable.Export(ctx, out, db)
+		if out != os.Stdout {
+			err = errors.Join(err, out.Close())
+		}
+		return err
+	})
+}
+
+// importCommand imports links written by 'shorty dump' or exported from
+// other URL shorteners.
+func importCommand(logger *slog.Logger, args []string) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: shorty import [flags] FILE\n\nImports the links in FILE (- for stdin) written by 'shorty dump' or exported from another URL shortener.\n")
+		flags.PrintDefaults()
+	}
+	formatName := flags.String("format", string(portable.FormatShorty), "input format: shorty, yourls-sql, yourls-csv, kutt, shlink, bitly or csv")
+	onConflict := flags.String("on-conflict", "", "what to do with links whose key is already used: skip, overwrite or fail (default: fail for the shorty format, skip otherwise)")
+	dryRun := flags.Bool("dry-run", false, "only report what would be imported")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return errors.New("expected exactly one file")
+	}
+	opts := portable.Options{DryRun: *dryRun}
+	var err error
+	if opts.Format, err = portable.ParseFormat(*formatName); err != nil {
+		return err
+	}
+	if *onConflict != "" {
+		if opts.OnConflict, err = portable.ParseOnConflict(*onConflict); err != nil {
+			return err
+		}
+	}
+	in := os.Stdin
+	if name := flags.Arg(0); name != "-" {
+		if in, err = os.Open(name); err != nil {
+			return err
+		}
+		defer in.Close()
+	}
+	return withDB(logger, func(ctx context.Context, db dbpkg.DB) error {
+		res, err := portable.Import(ctx, in, db, opts)
+		for _, p := range res.Problems {
+			logger.Warn("skipped link", "record", p.Record, "key", p.Key, "url", p.URL, "reason", p.Reason)
+		}
+		logger.Info("imported links", "dry_run", res.DryRun, "created", res.Created, "overwritten", res.Overwritten, "skipped", res.Skipped, "invalid", res.Invalid)
+		return err
+	})
+}
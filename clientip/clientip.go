@@ -0,0 +1,222 @@
+// Package clientip determines the IP address of the client that originated an
+// HTTP request, taking proxy headers into account only when the request was
+// received from a trusted proxy.
+package clientip
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+type contextKey struct{}
+
+// The proxy headers a Resolver can evaluate.
+const (
+	HeaderForwarded     = "Forwarded"
+	HeaderXForwardedFor = "X-Forwarded-For"
+	HeaderXRealIP       = "X-Real-Ip"
+)
+
+// A Resolver extracts the client IP from HTTP requests. A single proxy header
+// (Forwarded, X-Forwarded-For or X-Real-IP) is honored when the immediate
+// peer is one of the trusted proxies; the others are ignored as the proxy
+// might pass them on unchanged from the client. X-Forwarded-For and Forwarded
+// are evaluated from right to left, skipping all trusted proxies, so that a
+// client cannot spoof its address by sending its own header.
+type Resolver struct {
+	trusted []netip.Prefix
+	header  string
+}
+
+// NewResolver returns a Resolver that trusts the given proxy header set by
+// any of the given networks. The header defaults to HeaderXForwardedFor.
+func NewResolver(trusted []netip.Prefix, header string) (*Resolver, error) {
+	header = http.CanonicalHeaderKey(header)
+	switch header {
+	case "":
+		header = HeaderXForwardedFor
+	case HeaderForwarded, HeaderXForwardedFor, HeaderXRealIP:
+	default:
+		return nil, fmt.Errorf("unsupported proxy header %q", header)
+	}
+	return &Resolver{
+		trusted: trusted,
+		header:  header,
+	}, nil
+}
+
+// ParsePrefixes parses a comma-separated list of CIDRs and plain IP addresses
+// such as "10.0.0.0/8, 192.168.1.1". Plain addresses are treated as
+// single-host networks.
+func ParsePrefixes(s string) ([]netip.Prefix, error) {
+	res := make([]netip.Prefix, 0)
+	for _, elem := range strings.Split(s, ",") {
+		elem = strings.TrimSpace(elem)
+		if elem == "" {
+			continue
+		}
+		if strings.Contains(elem, "/") {
+			prefix, err := netip.ParsePrefix(elem)
+			if err != nil {
+				return nil, fmt.Errorf("invalid CIDR %q: %w", elem, err)
+			}
+			res = append(res, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(elem)
+		if err != nil {
+			return nil, fmt.Errorf("invalid IP address %q: %w", elem, err)
+		}
+		addr = addr.Unmap()
+		res = append(res, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return res, nil
+}
+
+// Contains reports whether addr is contained in any of the given networks.
+func Contains(prefixes []netip.Prefix, addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func (res *Resolver) isTrusted(addr netip.Addr) bool {
+	return Contains(res.trusted, addr)
+}
+
+// ClientIP returns the IP address of the client that originated r. If the
+// address cannot be determined, the raw RemoteAddr is returned.
+func (res *Resolver) ClientIP(r *http.Request) string {
+	remote, err := parseHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	if !res.isTrusted(remote) {
+		return remote.String()
+	}
+
+	switch res.header {
+	case HeaderForwarded:
+		if chain := forwardedFor(r.Header.Values(HeaderForwarded)); len(chain) > 0 {
+			return res.rightmostUntrusted(chain).String()
+		}
+	case HeaderXForwardedFor:
+		if chain := xForwardedFor(r.Header.Values(HeaderXForwardedFor)); len(chain) > 0 {
+			return res.rightmostUntrusted(chain).String()
+		}
+	case HeaderXRealIP:
+		if realIP, err := parseHostPort(strings.TrimSpace(r.Header.Get(HeaderXRealIP))); err == nil {
+			return realIP.String()
+		}
+	}
+
+	return remote.String()
+}
+
+// rightmostUntrusted walks the proxy chain from right to left and returns the
+// first address that is not a trusted proxy. If all addresses are trusted,
+// the leftmost one is returned.
+func (res *Resolver) rightmostUntrusted(chain []netip.Addr) netip.Addr {
+	for idx := len(chain) - 1; idx >= 0; idx-- {
+		if !res.isTrusted(chain[idx]) {
+			return chain[idx]
+		}
+	}
+	return chain[0]
+}
+
+// Middleware returns an HTTP middleware that resolves the client IP once and
+// stores it in the request context, from where it can be retrieved using
+// FromRequest.
+func (res *Resolver) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := res.ClientIP(r)
+		next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), ip)))
+	})
+}
+
+// NewContext returns a copy of ctx carrying the client IP ip.
+func NewContext(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, contextKey{}, ip)
+}
+
+// FromContext returns the client IP stored in ctx, if any.
+func FromContext(ctx context.Context) (string, bool) {
+	ip, ok := ctx.Value(contextKey{}).(string)
+	return ip, ok
+}
+
+// FromRequest returns the client IP that has been resolved by a Resolver's
+// middleware. If the request didn't pass through the middleware, the host part
+// of RemoteAddr is returned.
+func FromRequest(r *http.Request) string {
+	if ip, ok := FromContext(r.Context()); ok {
+		return ip
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// parseHostPort parses an IP address that may carry a port, brackets or an
+// IPv6 zone, as found in RemoteAddr and proxy headers.
+func parseHostPort(s string) (netip.Addr, error) {
+	if addrPort, err := netip.ParseAddrPort(s); err == nil {
+		return addrPort.Addr().Unmap().WithZone(""), nil
+	}
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	return addr.Unmap().WithZone(""), nil
+}
+
+// xForwardedFor parses all X-Forwarded-For header values into a single chain.
+// Unparseable elements invalidate the whole chain because its order can no
+// longer be trusted.
+func xForwardedFor(values []string) []netip.Addr {
+	res := make([]netip.Addr, 0)
+	for _, value := range values {
+		for _, elem := range strings.Split(value, ",") {
+			addr, err := parseHostPort(strings.TrimSpace(elem))
+			if err != nil {
+				return nil
+			}
+			res = append(res, addr)
+		}
+	}
+	return res
+}
+
+// forwardedFor parses the "for" parameters of all RFC 7239 Forwarded header
+// values into a single chain. Obfuscated identifiers and "unknown" invalidate
+// the chain.
+func forwardedFor(values []string) []netip.Addr {
+	res := make([]netip.Addr, 0)
+	for _, value := range values {
+		for _, elem := range strings.Split(value, ",") {
+			for _, pair := range strings.Split(elem, ";") {
+				name, val, found := strings.Cut(strings.TrimSpace(pair), "=")
+				if !found || !strings.EqualFold(name, "for") {
+					continue
+				}
+				addr, err := parseHostPort(strings.Trim(val, `"`))
+				if err != nil {
+					return nil
+				}
+				res = append(res, addr)
+			}
+		}
+	}
+	return res
+}
@@ -0,0 +1,176 @@
+package clientip_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/makkes/shorty/assert"
+	"github.com/makkes/shorty/clientip"
+)
+
+func newResolver(t *testing.T, trusted, header string) *clientip.Resolver {
+	prefixes, err := clientip.ParsePrefixes(trusted)
+	if err != nil {
+		t.Fatalf("unexpected error parsing prefixes: %v", err)
+	}
+	res, err := clientip.NewResolver(prefixes, header)
+	if err != nil {
+		t.Fatalf("unexpected error creating resolver: %v", err)
+	}
+	return res
+}
+
+func TestNewResolverRejectsUnknownHeaders(t *testing.T) {
+	if _, err := clientip.NewResolver(nil, "X-Client-IP"); err == nil {
+		t.Error("expected an error for an unsupported header")
+	}
+}
+
+func TestParsePrefixesRejectsInvalidInput(t *testing.T) {
+	for _, in := range []string{"foo", "10.0.0.0/33", "10.0.0.1,bar"} {
+		if _, err := clientip.ParsePrefixes(in); err == nil {
+			t.Errorf("expected an error parsing %q", in)
+		}
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name       string
+		trusted    string
+		header     string
+		remoteAddr string
+		headers    map[string][]string
+		expected   string
+	}{
+		{
+			name:       "no headers",
+			remoteAddr: "1.2.3.4:5678",
+			expected:   "1.2.3.4",
+		},
+		{
+			name:       "XFF from untrusted peer is ignored",
+			remoteAddr: "1.2.3.4:5678",
+			headers:    map[string][]string{"X-Forwarded-For": {"9.9.9.9"}},
+			expected:   "1.2.3.4",
+		},
+		{
+			name:       "XFF from trusted peer",
+			trusted:    "10.0.0.0/8",
+			remoteAddr: "10.0.0.1:5678",
+			headers:    map[string][]string{"X-Forwarded-For": {"9.9.9.9"}},
+			expected:   "9.9.9.9",
+		},
+		{
+			name:       "spoofed XFF prefix is skipped",
+			trusted:    "10.0.0.0/8",
+			remoteAddr: "10.0.0.1:5678",
+			headers:    map[string][]string{"X-Forwarded-For": {"6.6.6.6, 9.9.9.9, 10.0.0.2"}},
+			expected:   "9.9.9.9",
+		},
+		{
+			name:       "multiple XFF header lines",
+			trusted:    "10.0.0.0/8",
+			remoteAddr: "10.0.0.1:5678",
+			headers:    map[string][]string{"X-Forwarded-For": {"6.6.6.6", "9.9.9.9"}},
+			expected:   "9.9.9.9",
+		},
+		{
+			name:       "only trusted proxies in XFF",
+			trusted:    "10.0.0.0/8",
+			remoteAddr: "10.0.0.1:5678",
+			headers:    map[string][]string{"X-Forwarded-For": {"10.0.0.3, 10.0.0.2"}},
+			expected:   "10.0.0.3",
+		},
+		{
+			name:       "garbage XFF falls back to peer",
+			trusted:    "10.0.0.0/8",
+			remoteAddr: "10.0.0.1:5678",
+			headers:    map[string][]string{"X-Forwarded-For": {"9.9.9.9, garbage"}},
+			expected:   "10.0.0.1",
+		},
+		{
+			name:       "Forwarded from trusted peer",
+			trusted:    "10.0.0.0/8",
+			header:     "forwarded",
+			remoteAddr: "10.0.0.1:5678",
+			headers: map[string][]string{
+				"Forwarded":       {`for=6.6.6.6, for="[2001:db8::1]:4711";proto=https`},
+				"X-Forwarded-For": {"9.9.9.9"},
+			},
+			expected: "2001:db8::1",
+		},
+		{
+			name:       "Forwarded is ignored if XFF is configured",
+			trusted:    "10.0.0.0/8",
+			remoteAddr: "10.0.0.1:5678",
+			headers:    map[string][]string{"Forwarded": {"for=6.6.6.6"}},
+			expected:   "10.0.0.1",
+		},
+		{
+			name:       "XFF is ignored if Forwarded is configured",
+			trusted:    "10.0.0.0/8",
+			header:     "Forwarded",
+			remoteAddr: "10.0.0.1:5678",
+			headers:    map[string][]string{"X-Forwarded-For": {"6.6.6.6"}},
+			expected:   "10.0.0.1",
+		},
+		{
+			name:       "X-Real-IP from trusted peer",
+			trusted:    "10.0.0.1",
+			header:     "X-Real-IP",
+			remoteAddr: "10.0.0.1:5678",
+			headers:    map[string][]string{"X-Real-Ip": {"9.9.9.9"}},
+			expected:   "9.9.9.9",
+		},
+		{
+			name:       "X-Real-IP is ignored if XFF is configured",
+			trusted:    "10.0.0.1",
+			remoteAddr: "10.0.0.1:5678",
+			headers:    map[string][]string{"X-Real-Ip": {"6.6.6.6"}},
+			expected:   "10.0.0.1",
+		},
+		{
+			name:       "IPv6 peer",
+			remoteAddr: "[2001:db8::2]:5678",
+			expected:   "2001:db8::2",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for name, values := range tt.headers {
+				req.Header[name] = values
+			}
+
+			assert := assert.NewAssert(t)
+			assert.Equal(newResolver(t, tt.trusted, tt.header).ClientIP(req), tt.expected, "unexpected client IP")
+		})
+	}
+}
+
+func TestMiddlewareStoresClientIPInContext(t *testing.T) {
+	var ip string
+	handler := newResolver(t, "10.0.0.0/8", "").Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip = clientip.FromRequest(r)
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "10.1.1.1:1234"
+	req.Header.Set("X-Forwarded-For", "9.9.9.9")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	assert := assert.NewAssert(t)
+	assert.Equal(ip, "9.9.9.9", "unexpected client IP")
+}
+
+func TestFromRequestFallsBackToRemoteAddr(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = "1.2.3.4:5678"
+	req.Header.Set("X-Forwarded-For", "9.9.9.9")
+
+	assert := assert.NewAssert(t)
+	assert.Equal(clientip.FromRequest(req), "1.2.3.4", "unexpected client IP")
+}
@@ -0,0 +1,266 @@
+// Package cors implements Cross-Origin Resource Sharing, allowing web apps on
+// other origins to call Shorty's API.
+package cors
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Config describes which cross-origin requests are allowed.
+type Config struct {
+	// AllowedOrigins lists origins such as "https://app.example.org".
+	// "https://*.example.org" allows all subdomains of example.org and "*"
+	// allows any origin.
+	AllowedOrigins []string `json:"allowedOrigins"`
+	// AllowedMethods defaults to GET, HEAD and POST.
+	AllowedMethods []string `json:"allowedMethods,omitempty"`
+	// AllowedHeaders lists the request header fields clients may send in
+	// addition to those always allowed by browsers, defaulting to
+	// Content-Type.
+	AllowedHeaders []string `json:"allowedHeaders,omitempty"`
+	// ExposedHeaders lists the response header fields readable by clients
+	// in addition to those always exposed by browsers.
+	ExposedHeaders []string `json:"exposedHeaders,omitempty"`
+	// AllowCredentials allows requests with cookies or HTTP authentication.
+	AllowCredentials bool `json:"allowCredentials,omitempty"`
+	// MaxAge is the number of seconds browsers may cache the result of a
+	// preflight request. Browsers use their own default if it is 0.
+	MaxAge int `json:"maxAge,omitempty"`
+}
+
+// LoadConfig reads a JSON configuration from the file at path.
+func LoadConfig(path string) (Config, error) {
+	var cfg Config
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, fmt.Errorf("failed reading CORS config: %w", err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&cfg); err != nil {
+		return cfg, fmt.Errorf("failed parsing CORS config %s: %w", path, err)
+	}
+	return cfg, cfg.Validate()
+}
+
+// validToken tells whether s is a valid method or header field name as
+// defined by RFC 9110.
+func validToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c > '~' || c <= ' ' || strings.ContainsRune(`"(),/:;<=>?@[\]{}`, c) {
+			return false
+		}
+	}
+	return true
+}
+
+func validateOrigin(origin string) error {
+	if origin == "*" {
+		return nil
+	}
+	u, err := url.Parse(strings.Replace(origin, "://*.", "://", 1))
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return errors.New("scheme must be http or https")
+	}
+	if u.Host == "" || u.User != nil || u.Path != "" || u.RawQuery != "" || u.Fragment != "" {
+		return errors.New("origin must only consist of scheme, host and port")
+	}
+	if strings.Contains(u.Host, "*") {
+		return errors.New("wildcard is only allowed at the beginning of the host")
+	}
+	return nil
+}
+
+// Validate checks the configuration for errors.
+func (cfg Config) Validate() error {
+	var errs []error
+	if len(cfg.AllowedOrigins) == 0 {
+		errs = append(errs, errors.New("no allowed origins"))
+	}
+	for _, origin := range cfg.AllowedOrigins {
+		if err := validateOrigin(origin); err != nil {
+			errs = append(errs, fmt.Errorf("invalid origin %q: %w", origin, err))
+		}
+	}
+	if cfg.AllowCredentials && slices.Contains(cfg.AllowedOrigins, "*") {
+		errs = append(errs, errors.New("credentials must not be allowed for any origin"))
+	}
+	for _, method := range cfg.AllowedMethods {
+		if !validToken(method) {
+			errs = append(errs, fmt.Errorf("invalid method %q", method))
+		}
+	}
+	for _, name := range slices.Concat(cfg.AllowedHeaders, cfg.ExposedHeaders) {
+		if !validToken(name) {
+			errs = append(errs, fmt.Errorf("invalid header field name %q", name))
+		}
+	}
+	if cfg.MaxAge < 0 {
+		errs = append(errs, fmt.Errorf("max age must not be negative but is %d", cfg.MaxAge))
+	}
+	return errors.Join(errs...)
+}
+
+// A Policy answers preflight requests and adds CORS headers to responses.
+type Policy struct {
+	anyOrigin      bool
+	origins        []string
+	suffixes       []string
+	methods        []string
+	headers        []string
+	exposedHeaders string
+	credentials    bool
+	maxAge         string
+}
+
+// New returns the Policy described by cfg.
+func New(cfg Config) (*Policy, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	p := &Policy{
+		methods:        []string{http.MethodGet, http.MethodHead, http.MethodPost},
+		headers:        []string{"Content-Type"},
+		exposedHeaders: strings.Join(cfg.ExposedHeaders, ", "),
+		credentials:    cfg.AllowCredentials,
+	}
+	for _, origin := range cfg.AllowedOrigins {
+		origin = strings.ToLower(origin)
+		switch scheme, host, _ := strings.Cut(origin, "://"); {
+		case origin == "*":
+			p.anyOrigin = true
+		case strings.HasPrefix(host, "*."):
+			// keeps the dot so that "https://evilexample.org" doesn't match
+			// "https://*.example.org"; ports have to match as they are part
+			// of the suffix
+			p.suffixes = append(p.suffixes, scheme+"://"+host[1:])
+		default:
+			p.origins = append(p.origins, origin)
+		}
+	}
+	if len(cfg.AllowedMethods) > 0 {
+		p.methods = cfg.AllowedMethods
+	}
+	if len(cfg.AllowedHeaders) > 0 {
+		p.headers = make([]string, len(cfg.AllowedHeaders))
+		for i, name := range cfg.AllowedHeaders {
+			p.headers[i] = http.CanonicalHeaderKey(name)
+		}
+	}
+	if cfg.MaxAge > 0 {
+		p.maxAge = strconv.Itoa(cfg.MaxAge)
+	}
+	return p, nil
+}
+
+// AllowsOrigin tells whether requests from origin are allowed.
+func (p *Policy) AllowsOrigin(origin string) bool {
+	if origin == "" || origin == "null" {
+		return false
+	}
+	return p.anyOrigin || p.ListsOrigin(origin)
+}
+
+// ListsOrigin tells whether origin is allowed by one of the origins or
+// subdomain wildcards of the policy, disregarding "*".
+func (p *Policy) ListsOrigin(origin string) bool {
+	if origin == "" || origin == "null" {
+		return false
+	}
+	origin = strings.ToLower(origin)
+	if slices.Contains(p.origins, origin) {
+		return true
+	}
+	for _, suffix := range p.suffixes {
+		scheme, domain, _ := strings.Cut(suffix, "://")
+		host, ok := strings.CutPrefix(origin, scheme+"://")
+		if ok && len(host) > len(domain) && strings.HasSuffix(host, domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowOrigin sets the header fields common to preflight and actual
+// responses for the request's origin. It returns false if the origin isn't
+// allowed.
+func (p *Policy) allowOrigin(w http.ResponseWriter, r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if !p.AllowsOrigin(origin) {
+		return false
+	}
+	if p.anyOrigin && !p.credentials {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+	if p.credentials {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+	return true
+}
+
+// Handler adds CORS headers to the responses of next to requests from
+// allowed origins.
+func (p *Policy) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Origin")
+		if p.allowOrigin(w, r) && p.exposedHeaders != "" {
+			w.Header().Set("Access-Control-Expose-Headers", p.exposedHeaders)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// allowsHeaders tells whether all fields in the comma-separated list
+// requested are allowed.
+func (p *Policy) allowsHeaders(requested string) bool {
+	for name := range strings.SplitSeq(requested, ",") {
+		if name = strings.TrimSpace(name); name != "" && !slices.Contains(p.headers, http.CanonicalHeaderKey(name)) {
+			return false
+		}
+	}
+	return true
+}
+
+// Preflight answers preflight requests. Requests that aren't allowed are
+// answered without CORS headers, causing browsers to block the actual
+// request.
+func (p *Policy) Preflight() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Origin, Access-Control-Request-Method, Access-Control-Request-Headers")
+		method := r.Header.Get("Access-Control-Request-Method")
+		requestedHeaders := r.Header.Get("Access-Control-Request-Headers")
+		if method == "" {
+			// not a preflight request
+			w.Header().Set("Allow", strings.Join(slices.Concat(p.methods, []string{http.MethodOptions}), ", "))
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if slices.Contains(p.methods, method) && p.allowsHeaders(requestedHeaders) && p.allowOrigin(w, r) {
+			w.Header().Set("Access-Control-Allow-Methods", strings.Join(p.methods, ", "))
+			if requestedHeaders != "" {
+				w.Header().Set("Access-Control-Allow-Headers", requestedHeaders)
+			}
+			if p.maxAge != "" {
+				w.Header().Set("Access-Control-Max-Age", p.maxAge)
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
@@ -0,0 +1,152 @@
+package cors_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/makkes/shorty/assert"
+	"github.com/makkes/shorty/cors"
+)
+
+func request(h http.Handler, method, origin string, headers ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "/shorten", nil)
+	if origin != "" {
+		req.Header.Set("Origin", origin)
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestAllowsOrigin(t *testing.T) {
+	p, err := cors.New(cors.Config{AllowedOrigins: []string{"https://app.example.org", "https://*.example.com", "http://*.local.test:8080"}})
+	assert := assert.NewAssert(t)
+	assert.Nil(err, "unexpected error")
+	for origin, expected := range map[string]bool{
+		"https://app.example.org":       true,
+		"https://APP.example.org":       true,
+		"http://app.example.org":        false,
+		"https://other.example.org":     false,
+		"https://a.example.com":         true,
+		"https://a.b.example.com":       true,
+		"https://example.com":           false,
+		"https://evilexample.com":       false,
+		"https://a.example.com.evil.io": false,
+		"https://a.example.com:8443":    false,
+		"http://app.local.test:8080":    true,
+		"http://app.local.test":         false,
+		"null":                          false,
+		"":                              false,
+	} {
+		assert.Equal(p.AllowsOrigin(origin), expected, "unexpected result for "+origin)
+		assert.Equal(p.ListsOrigin(origin), expected, "unexpected result for "+origin)
+	}
+
+	p, err = cors.New(cors.Config{AllowedOrigins: []string{"*", "https://*.example.com"}})
+	assert.Nil(err, "unexpected error")
+	assert.Equal(p.AllowsOrigin("https://anywhere.example"), true, "any origin must be allowed")
+	assert.Equal(p.ListsOrigin("https://anywhere.example"), false, "origin must not be listed")
+	assert.Equal(p.ListsOrigin("https://a.example.com"), true, "subdomain must be listed")
+}
+
+func TestHandler(t *testing.T) {
+	p, err := cors.New(cors.Config{
+		AllowedOrigins:   []string{"https://app.example.org"},
+		ExposedHeaders:   []string{"X-Request-ID"},
+		AllowCredentials: true,
+	})
+	assert := assert.NewAssert(t)
+	assert.Nil(err, "unexpected error")
+	h := p.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+
+	rr := request(h, http.MethodPost, "https://app.example.org")
+	assert.Equal(rr.Code, http.StatusTeapot, "unexpected status code")
+	assert.Equal(rr.Header().Get("Access-Control-Allow-Origin"), "https://app.example.org", "unexpected allowed origin")
+	assert.Equal(rr.Header().Get("Access-Control-Allow-Credentials"), "true", "credentials must be allowed")
+	assert.Equal(rr.Header().Get("Access-Control-Expose-Headers"), "X-Request-ID", "unexpected exposed headers")
+	assert.Equal(rr.Header().Get("Vary"), "Origin", "responses must vary by origin")
+
+	rr = request(h, http.MethodPost, "https://evil.example")
+	assert.Equal(rr.Code, http.StatusTeapot, "unexpected status code")
+	assert.Equal(rr.Header().Get("Access-Control-Allow-Origin"), "", "foreign origin must not be allowed")
+	assert.Equal(rr.Header().Get("Access-Control-Expose-Headers"), "", "headers must not be exposed to foreign origin")
+
+	p, err = cors.New(cors.Config{AllowedOrigins: []string{"*"}})
+	assert.Nil(err, "unexpected error")
+	rr = request(p.Handler(http.NotFoundHandler()), http.MethodGet, "https://anywhere.example")
+	assert.Equal(rr.Header().Get("Access-Control-Allow-Origin"), "*", "wildcard must be sent for any origin")
+}
+
+func TestPreflight(t *testing.T) {
+	p, err := cors.New(cors.Config{
+		AllowedOrigins: []string{"https://*.example.org"},
+		AllowedMethods: []string{"POST"},
+		AllowedHeaders: []string{"content-type", "X-Request-ID"},
+		MaxAge:         600,
+	})
+	assert := assert.NewAssert(t)
+	assert.Nil(err, "unexpected error")
+	preflight := p.Preflight()
+
+	rr := request(preflight, http.MethodOptions, "https://app.example.org",
+		"Access-Control-Request-Method", "POST", "Access-Control-Request-Headers", "content-type,x-request-id")
+	assert.Equal(rr.Code, http.StatusNoContent, "unexpected status code")
+	assert.Equal(rr.Header().Get("Access-Control-Allow-Origin"), "https://app.example.org", "unexpected allowed origin")
+	assert.Equal(rr.Header().Get("Access-Control-Allow-Methods"), "POST", "unexpected allowed methods")
+	assert.Equal(rr.Header().Get("Access-Control-Allow-Headers"), "content-type,x-request-id", "unexpected allowed headers")
+	assert.Equal(rr.Header().Get("Access-Control-Max-Age"), "600", "unexpected max age")
+	assert.Equal(rr.Header().Get("Access-Control-Allow-Credentials"), "", "credentials must not be allowed")
+
+	for name, headers := range map[string][]string{
+		"foreign origin":     {"Origin", "https://example.com", "Access-Control-Request-Method", "POST"},
+		"disallowed method":  {"Access-Control-Request-Method", "DELETE"},
+		"disallowed headers": {"Access-Control-Request-Method", "POST", "Access-Control-Request-Headers", "authorization"},
+	} {
+		rr := request(preflight, http.MethodOptions, "https://app.example.org", headers...)
+		assert.Equal(rr.Code, http.StatusNoContent, "unexpected status code for "+name)
+		assert.Equal(rr.Header().Get("Access-Control-Allow-Origin"), "", "preflight must fail for "+name)
+	}
+
+	rr = request(preflight, http.MethodOptions, "")
+	assert.Equal(rr.Header().Get("Allow"), "POST, OPTIONS", "unexpected allowed methods of plain OPTIONS request")
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	write := func(content string) string {
+		path := filepath.Join(dir, "cors.json")
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	assert := assert.NewAssert(t)
+	cfg, err := cors.LoadConfig(write(`{"allowedOrigins": ["https://*.example.org"], "allowCredentials": true, "maxAge": 3600}`))
+	assert.Nil(err, "unexpected error")
+	assert.Equal(cfg.AllowCredentials, true, "credentials haven't been read")
+	assert.Equal(cfg.MaxAge, 3600, "max age hasn't been read")
+
+	for _, doc := range []string{
+		`{"allowedOrigin": ["https://example.org"]}`,
+		`{}`,
+		`{"allowedOrigins": ["example.org"]}`,
+		`{"allowedOrigins": ["https://example.org/path"]}`,
+		`{"allowedOrigins": ["https://app.*.example.org"]}`,
+		`{"allowedOrigins": ["*"], "allowCredentials": true}`,
+		`{"allowedOrigins": ["*"], "allowedMethods": ["GET POST"]}`,
+		`{"allowedOrigins": ["*"], "allowedHeaders": ["X:Y"]}`,
+		`{"allowedOrigins": ["*"], "maxAge": -1}`,
+	} {
+		_, err := cors.LoadConfig(write(doc))
+		assert.NotNil(err, "expected error for "+doc)
+	}
+}
@@ -0,0 +1,141 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// A Click is a single visit of a short URL.
+type Click struct {
+	Key      []byte    `json:"-"`
+	Time     time.Time `json:"time"`
+	Referrer string    `json:"referrer,omitempty"` // the host name of the referring page
+	Browser  string    `json:"browser,omitempty"`
+	OS       string    `json:"os,omitempty"`
+	Device   string    `json:"device,omitempty"`
+	Country  string    `json:"country,omitempty"` // ISO 3166-1 alpha-2 code
+	// Bot is the category of the automated client, such as a link unfurler
+	// or crawler, that caused the click. It is empty for humans.
+	Bot string `json:"bot,omitempty"`
+	// Visitor is an anonymous hash identifying the visitor for counting
+	// unique visitors. It is only valid for a single day and never persisted
+	// as is. Zero means that the visitor must not be counted.
+	Visitor uint64 `json:"-"`
+}
+
+// Granularity is the length of the periods clicks are rolled up into.
+type Granularity string
+
+// These constants define all supported granularities.
+const (
+	GranularityHour Granularity = "hour"
+	GranularityDay  Granularity = "day"
+)
+
+// ParseGranularity returns the Granularity named s.
+func ParseGranularity(s string) (Granularity, error) {
+	switch g := Granularity(s); g {
+	case GranularityHour, GranularityDay:
+		return g, nil
+	default:
+		return "", fmt.Errorf("unknown granularity %q", s)
+	}
+}
+
+// Truncate returns the start of the period t falls into, in UTC.
+func (g Granularity) Truncate(t time.Time) time.Time {
+	t = t.UTC()
+	if g == GranularityDay {
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	}
+	return t.Truncate(time.Hour)
+}
+
+// Next returns the start of the period following the one starting at start.
+func (g Granularity) Next(start time.Time) time.Time {
+	if g == GranularityDay {
+		return start.AddDate(0, 0, 1)
+	}
+	return start.Add(time.Hour)
+}
+
+// ClickStats aggregates the clicks on a short URL within a single period.
+type ClickStats struct {
+	Start time.Time `json:"start"`
+	// Clicks counts the clicks by humans. All other counters except for
+	// BotClicks and Bots only account for these, too.
+	Clicks    uint64            `json:"clicks"`
+	Referrers map[string]uint64 `json:"referrers"`
+	Browsers  map[string]uint64 `json:"browsers"`
+	OS        map[string]uint64 `json:"os"`
+	Devices   map[string]uint64 `json:"devices"`
+	Countries map[string]uint64 `json:"countries"`
+	// BotClicks counts the clicks by automated clients, broken down by
+	// category in Bots.
+	BotClicks uint64            `json:"botClicks"`
+	Bots      map[string]uint64 `json:"bots"`
+	// UniqueVisitors is the approximate number of distinct visitors. It is
+	// only available for daily granularity.
+	UniqueVisitors uint64 `json:"uniqueVisitors,omitempty"`
+}
+
+// NewClickStats returns empty ClickStats for the period starting at start.
+func NewClickStats(start time.Time) ClickStats {
+	return ClickStats{
+		Start:     start,
+		Referrers: make(map[string]uint64),
+		Browsers:  make(map[string]uint64),
+		OS:        make(map[string]uint64),
+		Devices:   make(map[string]uint64),
+		Countries: make(map[string]uint64),
+		Bots:      make(map[string]uint64),
+	}
+}
+
+// Add accounts for click in cs. Unknown attributes are counted as "unknown",
+// clicks without referrer as "direct".
+func (cs *ClickStats) Add(click Click) {
+	if click.Bot != "" {
+		cs.BotClicks++
+		cs.Bots[click.Bot]++
+		return
+	}
+	orDefault := func(s, def string) string {
+		if s == "" {
+			return def
+		}
+		return s
+	}
+	cs.Clicks++
+	cs.Referrers[orDefault(click.Referrer, "direct")]++
+	cs.Browsers[orDefault(click.Browser, "unknown")]++
+	cs.OS[orDefault(click.OS, "unknown")]++
+	cs.Devices[orDefault(click.Device, "unknown")]++
+	cs.Countries[orDefault(click.Country, "unknown")]++
+}
+
+// Analytics is implemented by backends that support click analytics. Like
+// those of DB, its methods should stop as early as possible once ctx is done.
+type Analytics interface {
+	// RecordClick records click. Implementations may process clicks
+	// asynchronously, beyond the lifetime of ctx.
+	RecordClick(ctx context.Context, click Click) error
+	// GetClickStats returns the rolled up clicks on the short URL key for all
+	// periods of the given granularity that overlap with [from, to) and
+	// contain at least one click, ordered by time.
+	GetClickStats(ctx context.Context, key []byte, from, to time.Time, granularity Granularity) ([]ClickStats, error)
+	// GetUniqueVisitors returns the approximate number of distinct visitors
+	// of the short URL key on all days that overlap with [from, to).
+	GetUniqueVisitors(ctx context.Context, key []byte, from, to time.Time) (uint64, error)
+}
+
+// VisitorSalts is implemented by backends that store the daily salts of
+// visitor identifiers so that all instances sharing the backend identify
+// visitors alike, also across restarts.
+type VisitorSalts interface {
+	// VisitorSalt returns the random salt of the day starting at day,
+	// creating it if needed. Salts of earlier days are deleted so that
+	// identifiers can't be linked across days.
+	VisitorSalt(ctx context.Context, day time.Time) ([]byte, error)
+}
@@ -1,12 +1,81 @@
 package db
 
-import "fmt"
+import (
+	"context"
+	"fmt"
+	"io"
+)
 
-// DB is the interface for bundling all database operations.
+// DB is the interface for bundling all database operations. Implementations
+// should stop as early as possible once ctx is done and return ctx.Err().
 type DB interface {
+	SaveURL(ctx context.Context, url string, key []byte) error
+	// ReplaceURL saves url under key, replacing any URL already stored
+	// under it.
+	ReplaceURL(ctx context.Context, url string, key []byte) error
+	GetURL(ctx context.Context, key []byte) ([]byte, error)
+	GetStats(ctx context.Context) (Stats, error)
+	// ListURLs calls fn for every stored link, ordered by key, using a cursor
+	// so that memory usage doesn't grow with the number of links. Iteration
+	// stops at the first error returned by fn.
+	ListURLs(ctx context.Context, fn func(Link) error) error
+}
+
+// LegacyDB is the interface DB had before it accepted contexts.
+type LegacyDB interface {
 	SaveURL(url string, key []byte) error
+	ReplaceURL(url string, key []byte) error
 	GetURL(key []byte) ([]byte, error)
 	GetStats() (Stats, error)
+	ListURLs(fn func(Link) error) error
+}
+
+type legacyDB struct {
+	db LegacyDB
+}
+
+// FromLegacy adapts an implementation of LegacyDB to DB. As operations of
+// db can't be cancelled, the context is only checked before each operation
+// and between the links passed to ListURLs.
+func FromLegacy(db LegacyDB) DB {
+	return legacyDB{db: db}
+}
+
+func (l legacyDB) SaveURL(ctx context.Context, url string, key []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return l.db.SaveURL(url, key)
+}
+
+func (l legacyDB) ReplaceURL(ctx context.Context, url string, key []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return l.db.ReplaceURL(url, key)
+}
+
+func (l legacyDB) GetURL(ctx context.Context, key []byte) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return l.db.GetURL(key)
+}
+
+func (l legacyDB) GetStats(ctx context.Context) (Stats, error) {
+	if err := ctx.Err(); err != nil {
+		return Stats{}, err
+	}
+	return l.db.GetStats()
+}
+
+func (l legacyDB) ListURLs(ctx context.Context, fn func(Link) error) error {
+	return l.db.ListURLs(func(link Link) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return fn(link)
+	})
 }
 
 type ErrKeyCollision struct {
@@ -31,3 +100,8 @@ func (e ErrKeyCollision) Is(target error) bool {
 type Stats struct {
 	StoredURLs int
 }
+
+// A Backuper writes consistent snapshots of the database while it is in use.
+type Backuper interface {
+	Backup(ctx context.Context, w io.Writer) error
+}
@@ -1,6 +1,7 @@
 package db_test
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"testing"
@@ -21,3 +22,56 @@ func TestErrKeyCollisionIs(t *testing.T) {
 		t.Fatalf("expected err to be a ErrKeyCollision")
 	}
 }
+
+type legacy struct {
+	calls int
+}
+
+func (l *legacy) SaveURL(url string, key []byte) error    { l.calls++; return nil }
+func (l *legacy) ReplaceURL(url string, key []byte) error { l.calls++; return nil }
+func (l *legacy) GetURL(key []byte) ([]byte, error) {
+	l.calls++
+	return []byte("http://example.org"), nil
+}
+func (l *legacy) GetStats() (db.Stats, error) { l.calls++; return db.Stats{StoredURLs: 2}, nil }
+func (l *legacy) ListURLs(fn func(db.Link) error) error {
+	l.calls++
+	for _, key := range []string{"a", "b"} {
+		if err := fn(db.Link{Key: key}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestFromLegacy(t *testing.T) {
+	l := &legacy{}
+	adapted := db.FromLegacy(l)
+
+	url, err := adapted.GetURL(t.Context(), []byte("a"))
+	if err != nil || string(url) != "http://example.org" {
+		t.Fatalf("unexpected result %q, %v", url, err)
+	}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	var keys []string
+	err = adapted.ListURLs(ctx, func(link db.Link) error {
+		keys = append(keys, link.Key)
+		cancel()
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) || len(keys) != 1 {
+		t.Fatalf("iteration has not been cancelled: %v, %v", keys, err)
+	}
+
+	calls := l.calls
+	if err := adapted.SaveURL(ctx, "http://example.org", []byte("c")); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected cancellation but got %v", err)
+	}
+	if _, err := adapted.GetStats(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected cancellation but got %v", err)
+	}
+	if l.calls != calls {
+		t.Fatalf("legacy DB has been called after cancellation")
+	}
+}
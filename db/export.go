@@ -0,0 +1,33 @@
+package db
+
+import (
+	"context"
+	"time"
+)
+
+// A Link is a short URL and the URL it redirects to. Clicks counts the clicks
+// by humans, excluding bots. Created and Clicks are zero if unknown.
+type Link struct {
+	Key     string    `json:"key"`
+	URL     string    `json:"url"`
+	Created time.Time `json:"created,omitzero"`
+	Clicks  uint64    `json:"clicks,omitempty"`
+}
+
+// A LinkSaver saves links along with their metadata, e.g. when importing
+// them from another system.
+type LinkSaver interface {
+	// SaveLink saves link. If the key is already in use, the existing link
+	// is replaced if replace is true and ErrKeyCollision is returned
+	// otherwise.
+	SaveLink(ctx context.Context, link Link, replace bool) error
+}
+
+// An Exporter streams all stored clicks. Implementations iterate using
+// cursors so that memory usage doesn't grow with the size of the database.
+// Iteration stops at the first error returned by fn.
+type Exporter interface {
+	// ForEachClick calls fn for every click between from (inclusive) and to
+	// (exclusive), ordered by key and time.
+	ForEachClick(ctx context.Context, from, to time.Time, fn func(Click) error) error
+}
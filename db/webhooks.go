@@ -0,0 +1,52 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// DeliveryStatus is the state of a webhook delivery.
+type DeliveryStatus string
+
+// These constants define all delivery states.
+const (
+	DeliveryPending   DeliveryStatus = "pending"
+	DeliverySucceeded DeliveryStatus = "succeeded"
+	DeliveryFailed    DeliveryStatus = "failed"
+)
+
+// A Delivery is a single event sent to a single webhook endpoint.
+type Delivery struct {
+	ID       uint64          `json:"id"`
+	Endpoint string          `json:"endpoint"`
+	Event    string          `json:"event"`
+	Payload  json.RawMessage `json:"payload"`
+	Status   DeliveryStatus  `json:"status"`
+	Created  time.Time       `json:"created"`
+	// NextAttempt is the earliest time of the next attempt of a pending
+	// delivery.
+	NextAttempt time.Time         `json:"nextAttempt,omitzero"`
+	Attempts    []DeliveryAttempt `json:"attempts"`
+}
+
+// A DeliveryAttempt is a single try to send a Delivery.
+type DeliveryAttempt struct {
+	Time       time.Time `json:"time"`
+	StatusCode int       `json:"statusCode,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// Webhooks is the interface for persisting webhook deliveries. Pending
+// deliveries form a queue that survives restarts while completed ones are
+// kept in a log of limited size.
+type Webhooks interface {
+	// SaveDelivery stores d, assigning it an ID if it doesn't have one yet.
+	SaveDelivery(ctx context.Context, d *Delivery) error
+	// DueDeliveries returns up to limit pending deliveries whose next
+	// attempt is due at now, in the order they became due.
+	DueDeliveries(ctx context.Context, now time.Time, limit int) ([]Delivery, error)
+	// Deliveries returns up to limit pending and completed deliveries,
+	// newest first.
+	Deliveries(ctx context.Context, limit int) ([]Delivery, error)
+}
@@ -0,0 +1,144 @@
+// Package events provides an in-memory bus distributing events such as the
+// creation of links to live subscribers.
+package events
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// These constants define all event types.
+const (
+	LinkCreated = "link.created"
+	LinkClicked = "link.clicked"
+)
+
+// An Event is something that happened to a link.
+type Event struct {
+	// ID increases with every event. It is derived from the time the bus
+	// has been created so that IDs keep increasing across restarts.
+	ID   uint64    `json:"id"`
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+	Key  string    `json:"key"`
+	Data any       `json:"data"`
+}
+
+// A Filter selects the events a subscriber is interested in. Empty fields
+// match all events.
+type Filter struct {
+	Keys  []string
+	Types []string
+}
+
+func matches(values []string, value string) bool {
+	return len(values) == 0 || slices.Contains(values, value)
+}
+
+// Match tells whether e passes f.
+func (f Filter) Match(e Event) bool {
+	return matches(f.Keys, e.Key) && matches(f.Types, e.Type)
+}
+
+// A Subscription receives the events matching its filter on C. C is closed
+// when the subscriber couldn't keep up and has been dropped.
+type Subscription struct {
+	C      <-chan Event
+	c      chan Event
+	filter Filter
+}
+
+// A Bus keeps the most recent events in a ring buffer for replaying them to
+// reconnecting subscribers and forwards new events to all subscribers.
+// Publishing never blocks: subscribers whose queue is full are dropped.
+type Bus struct {
+	mu          sync.Mutex
+	lastID      uint64
+	ring        []Event
+	next        int
+	queueSize   int
+	subscribers map[*Subscription]struct{}
+	clock       func() time.Time
+}
+
+// NewBus returns a Bus keeping the latest size events and queueing up to
+// queueSize events per subscriber.
+func NewBus(size, queueSize int) *Bus {
+	return &Bus{
+		lastID:      uint64(time.Now().UnixMicro()),
+		ring:        make([]Event, 0, size),
+		queueSize:   queueSize,
+		subscribers: make(map[*Subscription]struct{}),
+		clock:       time.Now,
+	}
+}
+
+// Publish sends an event to all matching subscribers.
+func (b *Bus) Publish(eventType string, key string, data any) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.lastID++
+	e := Event{
+		ID:   b.lastID,
+		Type: eventType,
+		Time: b.clock().UTC(),
+		Key:  key,
+		Data: data,
+	}
+	if len(b.ring) < cap(b.ring) {
+		b.ring = append(b.ring, e)
+	} else if cap(b.ring) > 0 {
+		b.ring[b.next] = e
+		b.next = (b.next + 1) % cap(b.ring)
+	}
+	for sub := range b.subscribers {
+		if !sub.filter.Match(e) {
+			continue
+		}
+		select {
+		case sub.c <- e:
+		default:
+			b.drop(sub)
+		}
+	}
+}
+
+// Subscribe returns a Subscription for the events matching filter. If
+// lastID is not zero, buffered events newer than lastID are queued first.
+// Events older than the buffer are lost.
+func (b *Bus) Subscribe(filter Filter, lastID uint64) *Subscription {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var replay []Event
+	if lastID != 0 {
+		for idx := range b.ring {
+			e := b.ring[(b.next+idx)%len(b.ring)]
+			if e.ID > lastID && filter.Match(e) {
+				replay = append(replay, e)
+			}
+		}
+	}
+	c := make(chan Event, len(replay)+b.queueSize)
+	for _, e := range replay {
+		c <- e
+	}
+	sub := &Subscription{C: c, c: c, filter: filter}
+	b.subscribers[sub] = struct{}{}
+	return sub
+}
+
+// Unsubscribe stops sending events to sub.
+func (b *Bus) Unsubscribe(sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.drop(sub)
+}
+
+// drop removes sub and closes its channel. b.mu must be held.
+func (b *Bus) drop(sub *Subscription) {
+	if _, ok := b.subscribers[sub]; ok {
+		delete(b.subscribers, sub)
+		close(sub.c)
+	}
+}
@@ -0,0 +1,84 @@
+package events_test
+
+import (
+	"testing"
+
+	"github.com/makkes/shorty/assert"
+	"github.com/makkes/shorty/events"
+)
+
+func receive(sub *events.Subscription) []events.Event {
+	var res []events.Event
+	for {
+		select {
+		case e, ok := <-sub.C:
+			if !ok {
+				return res
+			}
+			res = append(res, e)
+		default:
+			return res
+		}
+	}
+}
+
+func TestSubscribersReceiveMatchingEvents(t *testing.T) {
+	bus := events.NewBus(10, 10)
+	all := bus.Subscribe(events.Filter{}, 0)
+	clicksOnA := bus.Subscribe(events.Filter{Keys: []string{"a"}, Types: []string{events.LinkClicked}}, 0)
+
+	bus.Publish(events.LinkCreated, "a", nil)
+	bus.Publish(events.LinkClicked, "a", nil)
+	bus.Publish(events.LinkClicked, "b", nil)
+
+	assert := assert.NewAssert(t)
+	received := receive(all)
+	assert.Equal(len(received), 3, "unexpected number of events")
+	assert.Equal(received[1].ID, received[0].ID+1, "IDs are not consecutive")
+	received = receive(clicksOnA)
+	assert.Equal(len(received), 1, "unexpected number of filtered events")
+	assert.Equal(received[0].Key, "a", "unexpected key")
+	assert.Equal(received[0].Type, events.LinkClicked, "unexpected type")
+
+	bus.Unsubscribe(all)
+	bus.Publish(events.LinkCreated, "c", nil)
+	_, ok := <-all.C
+	assert.Equal(ok, false, "unsubscribed subscription is still open")
+}
+
+func TestSubscribersResumeFromRingBuffer(t *testing.T) {
+	bus := events.NewBus(3, 10)
+	first := bus.Subscribe(events.Filter{}, 0)
+	for _, key := range []string{"a", "b", "c", "d", "e"} {
+		bus.Publish(events.LinkCreated, key, nil)
+	}
+	published := receive(first)
+
+	assert := assert.NewAssert(t)
+	resumed := receive(bus.Subscribe(events.Filter{}, published[2].ID))
+	assert.Equal(len(resumed), 2, "unexpected number of replayed events")
+	assert.Equal(resumed[0].Key, "d", "unexpected first replayed event")
+	assert.Equal(resumed[1].Key, "e", "unexpected second replayed event")
+
+	// events older than the buffer are lost
+	resumed = receive(bus.Subscribe(events.Filter{}, published[0].ID))
+	assert.Equal(len(resumed), 3, "unexpected number of replayed events")
+	assert.Equal(resumed[0].Key, "c", "unexpected first replayed event")
+
+	resumed = receive(bus.Subscribe(events.Filter{Keys: []string{"e"}}, published[0].ID))
+	assert.Equal(len(resumed), 1, "replayed events have not been filtered")
+}
+
+func TestSlowSubscribersAreDropped(t *testing.T) {
+	bus := events.NewBus(10, 2)
+	slow := bus.Subscribe(events.Filter{}, 0)
+	for range 5 {
+		bus.Publish(events.LinkClicked, "a", nil)
+	}
+
+	assert := assert.NewAssert(t)
+	assert.Equal(len(receive(slow)), 2, "unexpected number of queued events")
+	_, ok := <-slow.C
+	assert.Equal(ok, false, "slow subscriber has not been dropped")
+	bus.Unsubscribe(slow)
+}
@@ -0,0 +1,162 @@
+// Package export writes links and click events in formats suitable for
+// loading them into other systems.
+package export
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	dbpkg "github.com/makkes/shorty/db"
+)
+
+// Format is the encoding of exported records.
+type Format string
+
+// These constants define all supported formats.
+const (
+	FormatCSV   Format = "csv"
+	FormatJSONL Format = "jsonl"
+)
+
+// ParseFormat returns the Format named s.
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(s); f {
+	case FormatCSV, FormatJSONL:
+		return f, nil
+	default:
+		return "", fmt.Errorf("unknown format %q, must be one of %q or %q", s, FormatCSV, FormatJSONL)
+	}
+}
+
+// ContentType returns the media type of f.
+func (f Format) ContentType() string {
+	if f == FormatCSV {
+		return "text/csv; charset=utf-8"
+	}
+	return "application/jsonl"
+}
+
+// A record is a single exported row.
+type record interface {
+	fields() []string
+}
+
+type linkRecord struct {
+	Key string `json:"key"`
+	URL string `json:"url"`
+}
+
+var linkHeader = []string{"key", "url"}
+
+func (l linkRecord) fields() []string {
+	return []string{l.Key, l.URL}
+}
+
+type clickRecord struct {
+	Key      string    `json:"key"`
+	Time     time.Time `json:"time"`
+	Referrer string    `json:"referrer"`
+	Browser  string    `json:"browser"`
+	OS       string    `json:"os"`
+	Device   string    `json:"device"`
+	Country  string    `json:"country"`
+	Bot      string    `json:"bot"`
+}
+
+var clickHeader = []string{"key", "time", "referrer", "browser", "os", "device", "country", "bot"}
+
+func (c clickRecord) fields() []string {
+	return []string{c.Key, c.Time.Format(time.RFC3339Nano), c.Referrer, c.Browser, c.OS, c.Device, c.Country, c.Bot}
+}
+
+// encoder writes records to an underlying io.Writer.
+type encoder interface {
+	encode(r record) error
+	flush() error
+}
+
+type csvEncoder struct {
+	w *csv.Writer
+}
+
+func (e csvEncoder) encode(r record) error {
+	return e.w.Write(r.fields())
+}
+
+func (e csvEncoder) flush() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+type jsonlEncoder struct {
+	enc *json.Encoder
+}
+
+func (e jsonlEncoder) encode(r record) error {
+	return e.enc.Encode(r)
+}
+
+func (e jsonlEncoder) flush() error {
+	return nil
+}
+
+// newEncoder returns an encoder for f writing to w. CSV output starts with
+// header.
+func newEncoder(w io.Writer, f Format, header []string) (encoder, error) {
+	switch f {
+	case FormatCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(header); err != nil {
+			return nil, err
+		}
+		return csvEncoder{cw}, nil
+	case FormatJSONL:
+		return jsonlEncoder{json.NewEncoder(w)}, nil
+	default:
+		return nil, fmt.Errorf("unknown format %q", f)
+	}
+}
+
+// Links writes all links from db to w.
+func Links(ctx context.Context, w io.Writer, f Format, db dbpkg.DB) error {
+	enc, err := newEncoder(w, f, linkHeader)
+	if err != nil {
+		return err
+	}
+	err = db.ListURLs(ctx, func(link dbpkg.Link) error {
+		return enc.encode(linkRecord{Key: link.Key, URL: link.URL})
+	})
+	if err != nil {
+		return err
+	}
+	return enc.flush()
+}
+
+// Clicks writes all clicks from src between from (inclusive) and to
+// (exclusive) to w.
+func Clicks(ctx context.Context, w io.Writer, f Format, src dbpkg.Exporter, from, to time.Time) error {
+	enc, err := newEncoder(w, f, clickHeader)
+	if err != nil {
+		return err
+	}
+	err = src.ForEachClick(ctx, from, to, func(click dbpkg.Click) error {
+		return enc.encode(clickRecord{
+			Key:      string(click.Key),
+			Time:     click.Time.UTC(),
+			Referrer: click.Referrer,
+			Browser:  click.Browser,
+			OS:       click.OS,
+			Device:   click.Device,
+			Country:  click.Country,
+			Bot:      click.Bot,
+		})
+	})
+	if err != nil {
+		return err
+	}
+	return enc.flush()
+}
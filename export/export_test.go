@@ -0,0 +1,97 @@
+package export_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/makkes/shorty/assert"
+	dbpkg "github.com/makkes/shorty/db"
+	"github.com/makkes/shorty/export"
+)
+
+type source struct {
+	links  []dbpkg.Link
+	clicks []dbpkg.Click
+	err    error
+}
+
+func (s source) SaveURL(ctx context.Context, url string, key []byte) error    { return nil }
+func (s source) ReplaceURL(ctx context.Context, url string, key []byte) error { return nil }
+func (s source) GetURL(ctx context.Context, key []byte) ([]byte, error)       { return nil, nil }
+func (s source) GetStats(ctx context.Context) (dbpkg.Stats, error)            { return dbpkg.Stats{}, nil }
+
+func (s source) ListURLs(ctx context.Context, fn func(dbpkg.Link) error) error {
+	for _, link := range s.links {
+		if err := fn(link); err != nil {
+			return err
+		}
+	}
+	return s.err
+}
+
+func (s source) ForEachClick(ctx context.Context, from, to time.Time, fn func(dbpkg.Click) error) error {
+	for _, click := range s.clicks {
+		if err := fn(click); err != nil {
+			return err
+		}
+	}
+	return s.err
+}
+
+var testSource = source{
+	links: []dbpkg.Link{
+		{Key: "a", URL: "http://example.org/?q=1,2"},
+		{Key: "b", URL: "http://example.com"},
+	},
+	clicks: []dbpkg.Click{
+		{Key: []byte("a"), Time: time.Date(2024, 3, 1, 10, 0, 0, 0, time.FixedZone("CET", 3600)), Referrer: "example.com", Browser: "Firefox", Country: "DE", Visitor: 42},
+		{Key: []byte("b"), Time: time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC), Bot: "unfurler"},
+	},
+}
+
+func TestExportLinks(t *testing.T) {
+	assert := assert.NewAssert(t)
+
+	var buf bytes.Buffer
+	assert.Nil(export.Links(t.Context(), &buf, export.FormatCSV, testSource), "unexpected error")
+	assert.Equal(buf.String(), "key,url\na,\"http://example.org/?q=1,2\"\nb,http://example.com\n", "unexpected CSV")
+
+	buf.Reset()
+	assert.Nil(export.Links(t.Context(), &buf, export.FormatJSONL, testSource), "unexpected error")
+	assert.Equal(buf.String(), `{"key":"a","url":"http://example.org/?q=1,2"}`+"\n"+`{"key":"b","url":"http://example.com"}`+"\n", "unexpected JSONL")
+}
+
+func TestExportClicks(t *testing.T) {
+	assert := assert.NewAssert(t)
+
+	var buf bytes.Buffer
+	assert.Nil(export.Clicks(t.Context(), &buf, export.FormatCSV, testSource, time.Time{}, time.Now()), "unexpected error")
+	assert.Equal(buf.String(), "key,time,referrer,browser,os,device,country,bot\n"+
+		"a,2024-03-01T09:00:00Z,example.com,Firefox,,,DE,\n"+
+		"b,2024-03-02T00:00:00Z,,,,,,unfurler\n", "unexpected CSV")
+
+	buf.Reset()
+	assert.Nil(export.Clicks(t.Context(), &buf, export.FormatJSONL, testSource, time.Time{}, time.Now()), "unexpected error")
+	assert.Equal(buf.String(), `{"key":"a","time":"2024-03-01T09:00:00Z","referrer":"example.com","browser":"Firefox","os":"","device":"","country":"DE","bot":""}`+"\n"+
+		`{"key":"b","time":"2024-03-02T00:00:00Z","referrer":"","browser":"","os":"","device":"","country":"","bot":"unfurler"}`+"\n", "unexpected JSONL")
+}
+
+func TestExportFailsOnSourceErrors(t *testing.T) {
+	src := testSource
+	src.err = errors.New("boom")
+	var buf bytes.Buffer
+	assert := assert.NewAssert(t)
+	assert.NotNil(export.Links(t.Context(), &buf, export.FormatCSV, src), "expected error")
+}
+
+func TestParseFormat(t *testing.T) {
+	assert := assert.NewAssert(t)
+	f, err := export.ParseFormat("jsonl")
+	assert.Nil(err, "unexpected error")
+	assert.Equal(f, export.FormatJSONL, "unexpected format")
+	_, err = export.ParseFormat("xml")
+	assert.NotNil(err, "expected error")
+}
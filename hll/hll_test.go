@@ -0,0 +1,73 @@
+package hll_test
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"math"
+	"testing"
+
+	"github.com/makkes/shorty/hll"
+)
+
+func hash(n int) uint64 {
+	sum := sha256.Sum256(binary.BigEndian.AppendUint64(nil, uint64(n)))
+	return binary.BigEndian.Uint64(sum[:])
+}
+
+func assertWithin(t *testing.T, actual uint64, expected int, tolerance float64) {
+	t.Helper()
+	if math.Abs(float64(actual)-float64(expected)) > tolerance*float64(expected) {
+		t.Errorf("estimate %d is off by more than %.0f%% from %d", actual, tolerance*100, expected)
+	}
+}
+
+func TestCountIsAccurate(t *testing.T) {
+	for _, n := range []int{10, 1000, 100000} {
+		s := hll.New()
+		for i := range n {
+			s.Add(hash(i))
+			// duplicates must not be counted
+			s.Add(hash(i))
+		}
+		assertWithin(t, s.Count(), n, 0.05)
+	}
+}
+
+func TestEmptySketchCountsZero(t *testing.T) {
+	if count := hll.New().Count(); count != 0 {
+		t.Fatalf("expected 0 but got %d", count)
+	}
+}
+
+func TestMergeUnitesSets(t *testing.T) {
+	a, b := hll.New(), hll.New()
+	for i := range 3000 {
+		a.Add(hash(i))
+		b.Add(hash(i + 2000))
+	}
+	a.Merge(b)
+	assertWithin(t, a.Count(), 5000, 0.05)
+}
+
+func TestMarshalRoundTrip(t *testing.T) {
+	s := hll.New()
+	for i := range 500 {
+		s.Add(hash(i))
+	}
+	data, err := s.MarshalBinary()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var decoded hll.Sketch
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if decoded.Count() != s.Count() {
+		t.Fatalf("expected %d but got %d", s.Count(), decoded.Count())
+	}
+
+	if err := decoded.UnmarshalBinary(data[:100]); err == nil {
+		t.Fatalf("expected an error decoding truncated data")
+	}
+}
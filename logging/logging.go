@@ -0,0 +1,146 @@
+// Package logging configures the structured logger and provides the HTTP
+// middleware assigning request IDs and writing the access log.
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/makkes/shorty/clientip"
+)
+
+// RequestIDHeader is the header field carrying the ID of a request.
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds the length of request IDs sent by clients.
+const maxRequestIDLength = 128
+
+// New returns a logger writing records of at least level, one of "debug",
+// "info", "warn" or "error", to w in format, one of "json" or "text". Empty
+// values select info and json.
+func New(w io.Writer, level, format string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if level != "" {
+		if err := lvl.UnmarshalText([]byte(level)); err != nil {
+			return nil, fmt.Errorf("invalid level %q", level)
+		}
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+	switch format {
+	case "", "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	case "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying logger.
+func NewContext(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, logger)
+}
+
+// FromContext returns the logger carried by ctx, which is annotated with the
+// ID of the request being served, or the default logger.
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(contextKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// validRequestID tells whether id, sent by a client, is safe to be logged
+// and echoed.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// responseRecorder remembers the status code and size of a response.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *responseRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *responseRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap makes http.ResponseController work with the original writer.
+func (w *responseRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Middleware assigns every request an ID, taken from its X-Request-ID header
+// if valid, and returns it in the response's header. The request's context
+// carries logger annotated with the ID and the trace ID, if any, for
+// FromContext. A line is logged for every response. The client's IP is
+// taken from clientip.Resolver's middleware if it wraps this one.
+func Middleware(logger *slog.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = rand.Text()
+		}
+		w.Header().Set(RequestIDHeader, id)
+
+		reqLogger := logger.With("request_id", id)
+		span := trace.SpanFromContext(r.Context())
+		if sc := span.SpanContext(); sc.IsValid() {
+			reqLogger = reqLogger.With("trace_id", sc.TraceID().String())
+			span.SetAttributes(attribute.String("request.id", id))
+		}
+
+		rec := &responseRecorder{ResponseWriter: w}
+		req := r.WithContext(NewContext(r.Context(), reqLogger))
+		next.ServeHTTP(rec, req)
+		// pass the route matched by a mux on to outer middleware
+		r.Pattern = req.Pattern
+
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		// the query is left out as it may contain the URLs being shortened
+		reqLogger.LogAttrs(r.Context(), slog.LevelInfo, "request",
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", rec.status),
+			slog.Int64("bytes", rec.bytes),
+			slog.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000),
+			slog.String("client_ip", clientip.FromRequest(r)),
+			slog.String("user_agent", r.UserAgent()),
+		)
+	})
+}
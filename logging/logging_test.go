@@ -0,0 +1,100 @@
+package logging_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/makkes/shorty/assert"
+	"github.com/makkes/shorty/logging"
+)
+
+// records decodes the JSON log records in buf.
+func records(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	var res []map[string]any
+	for line := range strings.Lines(buf.String()) {
+		var record map[string]any
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("invalid log record %q: %v", line, err)
+		}
+		res = append(res, record)
+	}
+	return res
+}
+
+func TestNew(t *testing.T) {
+	var buf bytes.Buffer
+	assert := assert.NewAssert(t)
+
+	logger, err := logging.New(&buf, "", "")
+	assert.Nil(err, "unexpected error")
+	logger.Debug("hidden")
+	logger.Info("shown", "answer", 42)
+	assert.Match(`^\{"time":"[^"]+","level":"INFO","msg":"shown","answer":42\}\n$`, buf.String(), "unexpected output")
+
+	buf.Reset()
+	logger, err = logging.New(&buf, "debug", "text")
+	assert.Nil(err, "unexpected error")
+	logger.Debug("shown")
+	assert.Match(`level=DEBUG msg=shown`, buf.String(), "unexpected output")
+
+	_, err = logging.New(&buf, "verbose", "")
+	assert.NotNil(err, "expected error for unknown level")
+	_, err = logging.New(&buf, "", "xml")
+	assert.NotNil(err, "expected error for unknown format")
+}
+
+func TestMiddleware(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := logging.New(&buf, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := logging.Middleware(logger, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logging.FromContext(r.Context()).Info("handling")
+		http.Error(w, "nope", http.StatusTeapot)
+	}))
+
+	for _, tc := range []struct {
+		name      string
+		requestID string
+		expected  string
+	}{
+		{"propagated ID", "abc-123", "^abc-123$"},
+		{"no ID", "", "^[A-Z2-7]{26}$"},
+		{"invalid ID", "abc\n123", "^[A-Z2-7]{26}$"},
+		{"overlong ID", strings.Repeat("a", 129), "^[A-Z2-7]{26}$"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			buf.Reset()
+			req := httptest.NewRequest(http.MethodGet, "/some/path?url=secret", nil)
+			if tc.requestID != "" {
+				req.Header.Set(logging.RequestIDHeader, tc.requestID)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			assert := assert.NewAssert(t)
+			id := rr.Header().Get(logging.RequestIDHeader)
+			assert.Match(tc.expected, id, "unexpected request ID")
+			recs := records(t, &buf)
+			assert.Equal(len(recs), 2, "unexpected number of log records")
+			assert.Equal(recs[0]["msg"], "handling", "unexpected handler record")
+			assert.Equal(recs[0]["request_id"], id, "handler record lacks the request ID")
+			access := recs[1]
+			assert.Equal(access["msg"], "request", "unexpected access log record")
+			assert.Equal(access["request_id"], id, "access log record lacks the request ID")
+			assert.Equal(access["method"], http.MethodGet, "unexpected method")
+			assert.Equal(access["path"], "/some/path", "unexpected path")
+			assert.Equal(access["status"], float64(http.StatusTeapot), "unexpected status")
+			assert.Equal(access["bytes"], float64(len("nope\n")), "unexpected size")
+			assert.Equal(access["client_ip"], "192.0.2.1", "unexpected client IP")
+			_, ok := access["duration_ms"].(float64)
+			assert.Equal(ok, true, "latency is missing")
+			assert.Equal(strings.Contains(buf.String(), "secret"), false, "query has been logged")
+		})
+	}
+}
@@ -1,67 +1,190 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"html/template"
 	"log"
 	"log/slog"
+	"mime"
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"regexp"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/makkes/shorty/analytics"
+	"github.com/makkes/shorty/assets"
 	"github.com/makkes/shorty/boltdb"
+	"github.com/makkes/shorty/cache"
+	"github.com/makkes/shorty/clientip"
+	"github.com/makkes/shorty/cors"
 	"github.com/makkes/shorty/db"
 	dbpkg "github.com/makkes/shorty/db"
+	"github.com/makkes/shorty/events"
+	"github.com/makkes/shorty/logging"
 	"github.com/makkes/shorty/ratelimiter"
+	"github.com/makkes/shorty/secheaders"
+	"github.com/makkes/shorty/sqldb"
+	"github.com/makkes/shorty/tracing"
 	"github.com/makkes/shorty/version"
+	"github.com/makkes/shorty/webhook"
 )
 
-func unshorten(db dbpkg.DB) http.HandlerFunc {
+// linkCreated is the data of events.LinkCreated.
+type linkCreated struct {
+	URL      string `json:"url"`
+	ShortURL string `json:"shortUrl"`
+}
+
+// textPlain is the content type of plain text responses. Setting it
+// explicitly prevents clients from guessing another type from the content.
+const textPlain = "text/plain; charset=utf-8"
+
+// keyAttribute holds the key of the short URL in the span of a request.
+const keyAttribute = attribute.Key("shorty.key")
+
+// dbTimeout limits the database operations serving a single request to a
+// short URL, configured by DB_TIMEOUT.
+var dbTimeout = 5 * time.Second
+
+// dbContext returns the context for the database operations serving r. It
+// is cancelled when the client disconnects or after dbTimeout.
+func dbContext(r *http.Request) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(r.Context(), dbTimeout)
+}
+
+// dbErrorStatus returns the status code for a failed database operation.
+func dbErrorStatus(err error) int {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+func unshorten(db dbpkg.DB, tracker *analytics.Tracker, bus *events.Bus) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		key := []byte(r.URL.Path[1:][strings.LastIndex(r.URL.Path[1:], "/")+1:])
 		if len(key) == 0 {
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
-		var err error
-		url, err := db.GetURL(key)
+		span := trace.SpanFromContext(r.Context())
+		span.SetAttributes(keyAttribute.String(string(key)))
+		ctx, cancel := dbContext(r)
+		defer cancel()
+		url, err := db.GetURL(ctx, key)
 		if err != nil {
-			log.Println(err)
-			w.WriteHeader(http.StatusInternalServerError)
+			logging.FromContext(r.Context()).Error("failed retrieving URL", "key", string(key), "error", err)
+			w.WriteHeader(dbErrorStatus(err))
+			return
 		}
 		if url == nil {
-			log.Printf("no URL found for key %q", key)
+			logging.FromContext(r.Context()).Info("no URL found", "key", string(key))
 			w.WriteHeader(http.StatusNotFound)
 			return
 		}
+		click := dbpkg.Click{Key: key, Time: time.Now().UTC()}
+		if tracker != nil {
+			click = tracker.Track(r, key)
+		}
+		if bus != nil {
+			bus.Publish(events.LinkClicked, string(key), click)
+			span.AddEvent("published " + events.LinkClicked)
+		}
 		w.Header().Add("Location", string(url))
+		w.Header().Set("Content-Type", textPlain)
 		w.WriteHeader(http.StatusMovedPermanently)
 		_, err = w.Write(url)
 		if err != nil {
-			log.Printf("failed writing response: %v", err)
+			logging.FromContext(r.Context()).Warn("failed writing response", "error", err)
 		}
 	}
 }
 
 func info(db dbpkg.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		stats, err := db.GetStats()
+		ctx, cancel := dbContext(r)
+		defer cancel()
+		stats, err := db.GetStats(ctx)
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
+			logging.FromContext(r.Context()).Error("failed retrieving stats", "error", err)
+			w.WriteHeader(dbErrorStatus(err))
 			return
 		}
+		w.Header().Set("Content-Type", textPlain)
 		fmt.Fprintf(w, "This is Shorty %s (%s), currently serving %d shortened URLs\n", version.Get().Version, version.Get().GitCommit, stats.StoredURLs)
 	}
 }
 
-func shorten(protocol string, host string, keybuffer <-chan []byte, db dbpkg.DB) http.HandlerFunc {
+// shortenRequest is the URL to shorten and the key chosen by the client, if
+// any, as sent in the body of a JSON request.
+type shortenRequest struct {
+	URL string `json:"url"`
+	Key string `json:"key,omitempty"`
+}
+
+// maxShortenBody limits the size of the body of requests to shorten a URL.
+const maxShortenBody = 64 << 10
+
+// errUnsupportedMediaType is returned for bodies that are neither a form nor
+// JSON.
+var errUnsupportedMediaType = errors.New("expected a form or JSON body")
+
+// readShortenRequest reads the parameters of r from its query if it is a GET
+// request and from its form or JSON body otherwise. It tells whether the body
+// has been JSON.
+func readShortenRequest(w http.ResponseWriter, r *http.Request) (shortenRequest, bool, error) {
+	if r.Method == http.MethodGet {
+		query := r.URL.Query()
+		return shortenRequest{URL: query.Get("url"), Key: query.Get("key")}, false, nil
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxShortenBody)
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "application/json":
+		var req shortenRequest
+		dec := json.NewDecoder(r.Body)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&req); err != nil {
+			return req, true, fmt.Errorf("invalid JSON body: %w", err)
+		}
+		return req, true, nil
+	case "application/x-www-form-urlencoded", "multipart/form-data":
+		if err := r.ParseMultipartForm(maxShortenBody); err != nil && !errors.Is(err, http.ErrNotMultipart) {
+			return shortenRequest{}, false, fmt.Errorf("invalid form: %w", err)
+		}
+		return shortenRequest{URL: r.PostForm.Get("url"), Key: r.PostForm.Get("key")}, false, nil
+	default:
+		return shortenRequest{}, false, errUnsupportedMediaType
+	}
+}
+
+// shorten creates short URLs. The response is the short URL as plain text,
+// or a JSON object if the request has been JSON.
+func shorten(protocol string, host string, keybuffer <-chan []byte, db dbpkg.DB, bus *events.Bus) http.HandlerFunc {
 	urlProtoRE := regexp.MustCompile("^http(s?)://")
 
 	return func(w http.ResponseWriter, r *http.Request) {
-		url := r.URL.Query().Get("url")
+		req, asJSON, err := readShortenRequest(w, r)
+		if err != nil {
+			status := http.StatusBadRequest
+			if errors.Is(err, errUnsupportedMediaType) {
+				status = http.StatusUnsupportedMediaType
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		url := req.URL
 		if url == "" {
 			w.WriteHeader(http.StatusBadRequest)
 			return
@@ -70,39 +193,172 @@ func shorten(protocol string, host string, keybuffer <-chan []byte, db dbpkg.DB)
 			url = "http://" + url
 		}
 
-		key := []byte(r.URL.Query().Get("key"))
+		key := []byte(req.Key)
 		if len(key) == 0 {
 			key = <-keybuffer
 		}
+		span := trace.SpanFromContext(r.Context())
+		span.SetAttributes(keyAttribute.String(string(key)))
 
-		err := db.SaveURL(url, key)
+		ctx, cancel := dbContext(r)
+		defer cancel()
+		err = db.SaveURL(ctx, url, key)
 		if err != nil {
 			if errors.Is(err, dbpkg.ErrKeyCollision{}) {
 				http.Error(w, fmt.Sprintf("key %q is already used", key), http.StatusConflict)
 				return
 			}
-			log.Printf("failed saving URL to DB: %v", err)
-			w.WriteHeader(http.StatusInternalServerError)
+			logging.FromContext(r.Context()).Error("failed saving URL", "key", string(key), "error", err)
+			w.WriteHeader(dbErrorStatus(err))
 			return
 		}
-		_, err = fmt.Fprintf(w, "%s://%s/%s\n", protocol, host, key)
+		shortURL := fmt.Sprintf("%s://%s/%s", protocol, host, key)
+		created := linkCreated{URL: url, ShortURL: shortURL}
+		if bus != nil {
+			bus.Publish(events.LinkCreated, string(key), created)
+			span.AddEvent("published " + events.LinkCreated)
+		}
+		if asJSON {
+			writeJSON(w, r, created)
+			return
+		}
+		// the URL contains the key chosen by the client
+		w.Header().Set("Content-Type", textPlain)
+		_, err = fmt.Fprintln(w, shortURL)
 		if err != nil {
-			log.Printf("Error returning shortened URL: %v", err)
+			logging.FromContext(r.Context()).Warn("failed writing response", "error", err)
 		}
 	}
 }
 
-func main() {
-	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
-		Level:     slog.LevelDebug,
-		AddSource: false,
+var backends = map[string]func(logger *slog.Logger) (db.DB, error){
+	"bolt":     boltdb.NewBoltDB,
+	"sqlite":   sqldb.NewSQLite,
+	"postgres": sqldb.NewPostgres,
+}
+
+// openDB opens the persistence backend configured in the environment. It
+// logs failures in the background to logger.
+func openDB(logger *slog.Logger) (dbpkg.DB, error) {
+	backend := os.Getenv("BACKEND")
+	if backend == "" {
+		backend = "bolt"
+	}
+	newDB, ok := backends[backend]
+	if !ok {
+		return nil, fmt.Errorf("unknown backend %q", backend)
+	}
+	return newDB(logger)
+}
+
+// cacheOptions reads the configuration of the link cache from the
+// environment.
+func cacheOptions() (cache.Options, error) {
+	opts := cache.Options{Size: 10000, TTL: time.Minute, NegativeTTL: 10 * time.Second}
+	if s := os.Getenv("CACHE_SIZE"); s != "" {
+		size, err := strconv.Atoi(s)
+		if err != nil || size < 0 {
+			return opts, fmt.Errorf("invalid CACHE_SIZE %q", s)
+		}
+		opts.Size = size
+	}
+	for name, ttl := range map[string]*time.Duration{"CACHE_TTL": &opts.TTL, "CACHE_NEGATIVE_TTL": &opts.NegativeTTL} {
+		if s := os.Getenv(name); s != "" {
+			d, err := time.ParseDuration(s)
+			if err != nil || d < 0 {
+				return opts, fmt.Errorf("invalid %s %q", name, s)
+			}
+			*ttl = d
+		}
+	}
+	return opts, nil
+}
+
+// uiConfig reads the settings of the web UI from the environment.
+func uiConfig(baseURL string) (assets.Config, error) {
+	cfg := assets.DefaultConfig()
+	cfg.BaseURL = baseURL
+	if title := os.Getenv("UI_TITLE"); title != "" {
+		cfg.Title = title
+	}
+	if footer, ok := os.LookupEnv("UI_FOOTER"); ok {
+		cfg.Footer = template.HTML(footer)
+	}
+	for name, toggle := range map[string]*bool{"UI_CUSTOM_KEYS": &cfg.CustomKeys, "UI_GITHUB_RIBBON": &cfg.GitHubRibbon} {
+		if s := os.Getenv(name); s != "" {
+			enabled, err := strconv.ParseBool(s)
+			if err != nil {
+				return cfg, fmt.Errorf("invalid %s %q", name, s)
+			}
+			*toggle = enabled
+		}
+	}
+	return cfg, nil
+}
+
+// crossOriginProtection returns a middleware rejecting cross-origin requests
+// unless they come from one of the comma-separated trustedOrigins or from an
+// origin listed by corsPolicy, if not nil. The corsPolicy's "*" isn't trusted
+// as it would allow any site to create short URLs on behalf of visitors.
+func crossOriginProtection(trustedOrigins string, corsPolicy *cors.Policy) (func(http.Handler) http.Handler, error) {
+	protection := http.NewCrossOriginProtection()
+	for origin := range strings.SplitSeq(trustedOrigins, ",") {
+		if origin = strings.TrimSpace(origin); origin == "" {
+			continue
+		}
+		if err := protection.AddTrustedOrigin(origin); err != nil {
+			return nil, fmt.Errorf("invalid CSRF_TRUSTED_ORIGINS: %w", err)
+		}
+	}
+	protection.SetDenyHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logging.FromContext(r.Context()).Info("rejected cross-origin request", "origin", r.Header.Get("Origin"), "sec_fetch_site", r.Header.Get("Sec-Fetch-Site"))
+		http.Error(w, "cross-origin request rejected", http.StatusForbidden)
 	}))
-	logger.Info("application initialized", "version", version.Get())
+	return func(next http.Handler) http.Handler {
+		protected := protection.Handler(next)
+		if corsPolicy == nil {
+			return protected
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if corsPolicy.ListsOrigin(r.Header.Get("Origin")) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			protected.ServeHTTP(w, r)
+		})
+	}, nil
+}
+
+// deprecated logs a warning for each request to next, which is going to be
+// removed in favor of replacement.
+func deprecated(replacement string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logging.FromContext(r.Context()).Warn("deprecated request", "method", r.Method, "path", r.URL.Path, "replacement", replacement)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// fatal logs msg along with args and exits.
+func fatal(logger *slog.Logger, msg string, args ...any) {
+	logger.Error(msg, args...)
+	os.Exit(1)
+}
+
+func main() {
+	logger, err := logging.New(os.Stderr, os.Getenv("LOG_LEVEL"), os.Getenv("LOG_FORMAT"))
+	if err != nil {
+		log.Fatalf("Error configuring logging: %s", err)
+	}
+	// dependencies logging using the log package end up in logger, too
+	slog.SetDefault(logger)
 
-	backends := map[string]func() (db.DB, error){
-		"bolt": boltdb.NewBoltDB,
+	if len(os.Args) > 1 {
+		runCommand(logger, os.Args[1], os.Args[2:])
+		return
 	}
 
+	logger.Info("application initialized", "version", version.Get())
+
 	serveHost := os.Getenv("SERVE_HOST")
 	if serveHost == "" {
 		serveHost = "localhost"
@@ -123,37 +379,265 @@ func main() {
 		serveProtocol = "https"
 	}
 
-	backend := os.Getenv("BACKEND")
-	if backend == "" {
-		backend = "bolt"
+	trustedProxies, err := clientip.ParsePrefixes(os.Getenv("TRUSTED_PROXIES"))
+	if err != nil {
+		fatal(logger, "invalid TRUSTED_PROXIES", "error", err)
+	}
+	resolver, err := clientip.NewResolver(trustedProxies, os.Getenv("TRUSTED_PROXY_HEADER"))
+	if err != nil {
+		fatal(logger, "invalid TRUSTED_PROXY_HEADER", "error", err)
+	}
+
+	shutdownTracing, err := tracing.Setup(context.Background(), os.Getenv("TRACING_EXPORTER"), os.Getenv("TRACING_FILE"))
+	if err != nil {
+		fatal(logger, "failed setting up tracing", "error", err)
 	}
 
 	keybuffer := make(chan []byte, 1000)
 	go keygen(keybuffer)
 
-	db, err := backends[backend]()
+	if s := os.Getenv("DB_TIMEOUT"); s != "" {
+		if dbTimeout, err = time.ParseDuration(s); err != nil || dbTimeout <= 0 {
+			fatal(logger, "invalid DB_TIMEOUT", "value", s)
+		}
+	}
+	db, err := openDB(logger)
 	if err != nil {
-		log.Fatalf("Error creating DB backend: %s", err)
+		fatal(logger, "failed creating DB backend", "error", err)
 	}
 
-	fs := http.FileServer(http.Dir("assets"))
-	http.Handle("/{$}", fs)
-	http.Handle("/css/", fs)
-	http.Handle("/js/", fs)
+	baseURL := serveProtocol + "://" + serveHost + "/"
+	uiCfg, err := uiConfig(baseURL)
+	if err != nil {
+		fatal(logger, "failed configuring UI", "error", err)
+	}
+	ui, err := assets.New(uiCfg, os.Getenv("ASSETS_DIR"))
+	if err != nil {
+		fatal(logger, "failed loading assets", "error", err)
+	}
+	headersConfig := secheaders.DefaultConfig(serveProtocol == "https")
+	if headersConfigPath := os.Getenv("SECURITY_HEADERS_CONFIG"); headersConfigPath != "" {
+		if headersConfig, err = secheaders.LoadConfig(headersConfigPath, headersConfig); err != nil {
+			fatal(logger, "failed loading security headers config", "error", err)
+		}
+	}
+	secure := headersConfig.Route
 
-	limiter := ratelimiter.NewRateLimiter(5, time.Minute)
+	mux := http.NewServeMux()
+	// crossOrigin allows calling the API from the origins of the CORS policy;
+	// it must not be applied to redirects
+	var corsPolicy *cors.Policy
+	crossOrigin := func(next http.Handler) http.Handler { return next }
+	// preflight answers preflight requests to path if CORS is configured;
+	// it must only be registered for paths using crossOrigin
+	preflight := func(route, path string) {}
+	if corsConfigPath := os.Getenv("CORS_CONFIG"); corsConfigPath != "" {
+		corsConfig, err := cors.LoadConfig(corsConfigPath)
+		if err != nil {
+			fatal(logger, "failed loading CORS config", "error", err)
+		}
+		if corsPolicy, err = cors.New(corsConfig); err != nil {
+			fatal(logger, "invalid CORS config", "error", err)
+		}
+		crossOrigin = corsPolicy.Handler
+		preflight = func(route, path string) {
+			mux.Handle("OPTIONS "+path, secure(route, corsPolicy.Preflight()))
+		}
+	}
+	mux.Handle("/{$}", secure("ui", ui.Index()))
+	mux.Handle("/css/", secure("ui", ui.Files()))
+	mux.Handle("/fonts/", secure("ui", ui.Files()))
+	mux.Handle("/js/", secure("ui", ui.Files()))
 
-	http.Handle("/shorten", limiter.Middleware(shorten(serveProtocol, serveHost, keybuffer, db)))
-	http.HandleFunc("/info", info(db))
+	var limiterStore ratelimiter.Store
+	switch rateLimitStore := os.Getenv("RATE_LIMIT_STORE"); rateLimitStore {
+	case "", "memory":
+		limiterStore = ratelimiter.NewMemoryStore()
+	case "redis":
+		redisAddr := os.Getenv("REDIS_ADDR")
+		if redisAddr == "" {
+			redisAddr = "localhost:6379"
+		}
+		limiterStore = ratelimiter.NewRedisStore(redisAddr, os.Getenv("REDIS_PASSWORD"))
+	default:
+		fatal(logger, "unknown rate limit store", "store", rateLimitStore)
+	}
+	limitConfig := ratelimiter.DefaultConfig()
+	if limitConfigPath := os.Getenv("RATE_LIMIT_CONFIG"); limitConfigPath != "" {
+		limitConfig, err = ratelimiter.LoadConfig(limitConfigPath)
+		if err != nil {
+			fatal(logger, "failed loading rate limit config", "error", err)
+		}
+	}
+	limit := func(route string, handler http.Handler) http.Handler {
+		limiter, err := limitConfig.NewRateLimiter(route, limiterStore)
+		if err != nil {
+			fatal(logger, "failed creating rate limiter", "route", route, "error", err)
+		}
+		if limiter == nil {
+			return handler
+		}
+		return limiter.Middleware(handler)
+	}
 
-	http.HandleFunc("/", unshorten(db))
-	listener, err := net.Listen("tcp", listenHost+":"+listenPort)
+	var dispatcher *webhook.Dispatcher
+	if webhooksConfigPath := os.Getenv("WEBHOOKS_CONFIG"); webhooksConfigPath != "" {
+		webhooksConfig, err := webhook.LoadConfig(webhooksConfigPath)
+		if err != nil {
+			fatal(logger, "failed loading webhook config", "error", err)
+		}
+		webhookStore, ok := db.(dbpkg.Webhooks)
+		if !ok {
+			fatal(logger, "the configured backend doesn't support webhooks")
+		}
+		dispatcher = webhook.NewDispatcher(webhooksConfig, webhookStore, baseURL)
+		dispatcher.SetLogger(logger)
+		go dispatcher.Run(context.Background())
+	}
+
+	// linkDB is used for all operations on links so that the cache sees all
+	// updates
+	linkDB := db
+	var linkCache *cache.DB
+	if cacheOpts, err := cacheOptions(); err != nil {
+		fatal(logger, "failed configuring cache", "error", err)
+	} else if cacheOpts.Size > 0 {
+		linkCache = cache.New(db, cacheOpts)
+		linkDB = linkCache
+	}
+	// writeDB is used for all operations creating or replacing links so that
+	// webhooks are notified about them
+	writeDB := linkDB
+	if dispatcher != nil {
+		writeDB = dispatcher.WrapDB(linkDB)
+	}
+	bus := events.NewBus(1024, 64)
+	eventsToken := os.Getenv("EVENTS_TOKEN")
+	if eventsToken == "" {
+		eventsToken = os.Getenv("ADMIN_TOKEN")
+	}
+	if eventsToken != "" {
+		mux.Handle("GET /api/v1/events", crossOrigin(secure("api", limit("api", requireStreamToken(eventsToken, eventStream(bus))))))
+		preflight("api", "/api/v1/events")
+	}
+	csrf, err := crossOriginProtection(os.Getenv("CSRF_TRUSTED_ORIGINS"), corsPolicy)
 	if err != nil {
-		log.Fatal("Error starting HTTP server", err)
+		fatal(logger, "failed configuring cross-origin protection", "error", err)
+	}
+	shortenHandler := secure("shorten", limit("shorten", shorten(serveProtocol, serveHost, keybuffer, writeDB, bus)))
+	mux.Handle("POST /shorten", crossOrigin(csrf(shortenHandler)))
+	preflight("shorten", "/shorten")
+	allowGet := false
+	if s := os.Getenv("SHORTEN_ALLOW_GET"); s != "" {
+		if allowGet, err = strconv.ParseBool(s); err != nil {
+			fatal(logger, "invalid SHORTEN_ALLOW_GET", "value", s)
+		}
 	}
-	log.Printf("Shorty listening on %s:%s\n", listenHost, listenPort)
-	err = http.Serve(listener, nil)
+	if allowGet {
+		logger.Warn("GET /shorten is deprecated and will be removed, use POST /shorten instead")
+		mux.Handle("GET /shorten", crossOrigin(deprecated("POST /shorten", shortenHandler)))
+	} else {
+		// keeps the request from being handled as short URL
+		mux.Handle("GET /shorten", crossOrigin(secure("shorten", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "use POST to shorten URLs", http.StatusMethodNotAllowed)
+		}))))
+	}
+	mux.Handle("/info", secure("api", limit("api", info(linkDB))))
+
+	var tracker *analytics.Tracker
+	if dbAnalytics, ok := db.(dbpkg.Analytics); ok {
+		var countries analytics.CountryLookup
+		if geoIPPath := os.Getenv("GEOIP_DB"); geoIPPath != "" {
+			geoIP, err := analytics.OpenGeoIP(geoIPPath)
+			if err != nil {
+				fatal(logger, "failed opening GeoIP database", "error", err)
+			}
+			countries = geoIP
+		}
+		var bots *analytics.BotClassifier
+		if botRulesPath := os.Getenv("BOT_RULES"); botRulesPath != "" {
+			bots, err = analytics.LoadBotClassifier(botRulesPath)
+			if err != nil {
+				fatal(logger, "failed loading bot rules", "error", err)
+			}
+		}
+		var trackedAnalytics dbpkg.Analytics = dbAnalytics
+		if dispatcher != nil {
+			trackedAnalytics = dispatcher.WrapAnalytics(dbAnalytics)
+		}
+		tracker = analytics.NewTracker(trackedAnalytics, countries, bots)
+		if salts, ok := db.(dbpkg.VisitorSalts); ok {
+			tracker.UseVisitorSalts(salts)
+		}
+		publicStats := false
+		if s := os.Getenv("PUBLIC_STATS"); s != "" {
+			if publicStats, err = strconv.ParseBool(s); err != nil {
+				fatal(logger, "invalid PUBLIC_STATS", "value", s)
+			}
+		}
+		statsToken := os.Getenv("STATS_TOKEN")
+		if statsToken == "" {
+			statsToken = os.Getenv("ADMIN_TOKEN")
+		}
+		var statsHandler http.Handler = clickStats(linkDB, dbAnalytics)
+		if !publicStats {
+			statsHandler = requireAdmin(statsToken, statsHandler)
+		}
+		if publicStats || statsToken != "" {
+			mux.Handle("GET /api/v1/links/{key}/stats", crossOrigin(secure("api", limit("api", statsHandler))))
+			preflight("api", "/api/v1/links/{key}/stats")
+		}
+	}
+
+	if adminToken := os.Getenv("ADMIN_TOKEN"); adminToken != "" {
+		mux.Handle("GET /admin/export/links", secure("admin", requireAdmin(adminToken, exportLinks(linkDB))))
+		mux.Handle("GET /admin/links", secure("admin", requireAdmin(adminToken, dumpLinks(linkDB))))
+		mux.Handle("POST /admin/links/import", secure("admin", requireAdmin(adminToken, importLinks(writeDB))))
+		if linkCache != nil {
+			mux.Handle("GET /admin/cache", secure("admin", requireAdmin(adminToken, cacheStats(linkCache))))
+		}
+		if exporter, ok := db.(dbpkg.Exporter); ok {
+			mux.Handle("GET /admin/export/clicks", secure("admin", requireAdmin(adminToken, exportClicks(exporter))))
+		}
+		if backuper, ok := db.(dbpkg.Backuper); ok {
+			mux.Handle("GET /admin/backup", secure("admin", requireAdmin(adminToken, backup(backuper))))
+		}
+		if webhookStore, ok := db.(dbpkg.Webhooks); ok {
+			mux.Handle("GET /admin/webhooks/deliveries", secure("admin", requireAdmin(adminToken, webhookDeliveries(webhookStore))))
+		}
+	}
+
+	mux.Handle("/", secure("unshorten", limit("unshorten", unshorten(linkDB, tracker, bus))))
+	listener, err := net.Listen("tcp", listenHost+":"+listenPort)
 	if err != nil {
-		log.Panic(err)
+		fatal(logger, "failed starting HTTP server", "error", err)
+	}
+	logger.Info("Shorty listening", "address", listener.Addr().String())
+	srv := &http.Server{
+		Handler:  resolver.Middleware(tracing.Middleware(logging.Middleware(logger, headersConfig.Middleware(mux)))),
+		ErrorLog: slog.NewLogLogger(logger.Handler(), slog.LevelWarn),
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			logger.Error("failed shutting down HTTP server", "error", err)
+		}
+	}()
+	if err := srv.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+		fatal(logger, "failed serving HTTP", "error", err)
+	}
+	// flush the spans of the requests still being served
+	<-stopped
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		logger.Error("failed flushing traces", "error", err)
 	}
 }
@@ -1,13 +1,17 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"slices"
+	"strings"
 	"testing"
 
 	"github.com/makkes/shorty/assert"
+	"github.com/makkes/shorty/cors"
 	"github.com/makkes/shorty/db"
 )
 
@@ -18,13 +22,24 @@ type TestDB struct {
 	url     []byte
 }
 
-func (tdb *TestDB) SaveURL(url string, key []byte) error {
+func (tdb *TestDB) SaveURL(ctx context.Context, url string, key []byte) error {
 	tdb.key = key
 	tdb.url = []byte(url)
 	return tdb.saveErr
 }
 
-func (tdb *TestDB) GetURL(key []byte) ([]byte, error) {
+func (tdb *TestDB) ReplaceURL(ctx context.Context, url string, key []byte) error {
+	return tdb.SaveURL(ctx, url, key)
+}
+
+func (tdb *TestDB) ListURLs(ctx context.Context, fn func(db.Link) error) error {
+	if tdb.key == nil {
+		return nil
+	}
+	return fn(db.Link{Key: string(tdb.key), URL: string(tdb.url)})
+}
+
+func (tdb *TestDB) GetURL(ctx context.Context, key []byte) ([]byte, error) {
 	if tdb.getErr != nil {
 		return nil, tdb.getErr
 	}
@@ -35,14 +50,14 @@ func (tdb *TestDB) GetURL(key []byte) ([]byte, error) {
 	return nil, nil
 }
 
-func (tdb *TestDB) GetStats() (db.Stats, error) {
+func (tdb *TestDB) GetStats(ctx context.Context) (db.Stats, error) {
 	return db.Stats{}, nil
 }
 
 func setupShorten(url, key, proto string, db db.DB) *httptest.ResponseRecorder {
 	keybuffer := make(chan []byte, 1)
 	keybuffer <- []byte(key)
-	handler := shorten(proto, "sho.rt", keybuffer, db)
+	handler := shorten(proto, "sho.rt", keybuffer, db, nil)
 	req, _ := http.NewRequest("GET", url, nil)
 	w := httptest.NewRecorder()
 	handler.ServeHTTP(w, req)
@@ -50,7 +65,7 @@ func setupShorten(url, key, proto string, db db.DB) *httptest.ResponseRecorder {
 }
 
 func setupUnshorten(url string, db db.DB) *httptest.ResponseRecorder {
-	handler := unshorten(db)
+	handler := unshorten(db, nil, nil)
 	req, _ := http.NewRequest("GET", url, nil)
 	w := httptest.NewRecorder()
 	handler.ServeHTTP(w, req)
@@ -72,6 +87,7 @@ func TestShortenFollowsTheHappyPath(t *testing.T) {
 	assert := assert.NewAssert(t)
 	assert.Equal(w.Body.String(), "http://sho.rt/A new key\n", "Returned URL is incorrect")
 	assert.Equal(w.Code, http.StatusOK, "Returned status code is incorrect")
+	assert.Equal(w.Header().Get("Content-Type"), "text/plain; charset=utf-8", "Returned content type is incorrect")
 }
 
 func TestShortenDoesntAcceptEmptyURLs(t *testing.T) {
@@ -122,6 +138,89 @@ func TestShortenCorrectlyStoresProvidedKey(t *testing.T) {
 	a.Equal(w.Body.String(), "http://sho.rt/my_key\n", "returned URL is incorrect")
 }
 
+func postShorten(contentType, body string, db db.DB, headers ...string) *httptest.ResponseRecorder {
+	keybuffer := make(chan []byte, 1)
+	keybuffer <- []byte("generated")
+	req := httptest.NewRequest(http.MethodPost, "/shorten", strings.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	protection, err := crossOriginProtection("https://trusted.example", nil)
+	if err != nil {
+		panic(err)
+	}
+	w := httptest.NewRecorder()
+	protection(shorten("https", "sho.rt", keybuffer, db, nil)).ServeHTTP(w, req)
+	return w
+}
+
+func TestShortenAcceptsForms(t *testing.T) {
+	db := &TestDB{}
+	w := postShorten("application/x-www-form-urlencoded", "url=example.org&key=mine", db)
+	assert := assert.NewAssert(t)
+	assert.Equal(w.Code, http.StatusOK, "Returned status code is incorrect")
+	assert.Equal(w.Body.String(), "https://sho.rt/mine\n", "Returned URL is incorrect")
+	assert.Equal(string(db.url), "http://example.org", "Stored URL is incorrect")
+}
+
+func TestShortenAcceptsJSON(t *testing.T) {
+	db := &TestDB{}
+	w := postShorten("application/json", `{"url": "https://example.org"}`, db)
+	assert := assert.NewAssert(t)
+	assert.Equal(w.Code, http.StatusOK, "Returned status code is incorrect")
+	assert.Equal(w.Header().Get("Content-Type"), "application/json", "Returned content type is incorrect")
+	var res linkCreated
+	assert.Nil(json.Unmarshal(w.Body.Bytes(), &res), "Invalid JSON response")
+	assert.Equal(res, linkCreated{URL: "https://example.org", ShortURL: "https://sho.rt/generated"}, "Unexpected response")
+
+	w = postShorten("application/json", `{"url": "https://example.org", "extra": true}`, &TestDB{})
+	assert.Equal(w.Code, http.StatusBadRequest, "Unknown fields must be rejected")
+	w = postShorten("text/plain", "https://example.org", &TestDB{})
+	assert.Equal(w.Code, http.StatusUnsupportedMediaType, "Unsupported bodies must be rejected")
+}
+
+func TestShortenRejectsCrossOriginRequests(t *testing.T) {
+	const form = "application/x-www-form-urlencoded"
+	assert := assert.NewAssert(t)
+	w := postShorten(form, "url=example.org", &TestDB{}, "Sec-Fetch-Site", "cross-site")
+	assert.Equal(w.Code, http.StatusForbidden, "Cross-site request must be rejected")
+	w = postShorten(form, "url=example.org", &TestDB{}, "Origin", "https://evil.example")
+	assert.Equal(w.Code, http.StatusForbidden, "Request from foreign origin must be rejected")
+	w = postShorten(form, "url=example.org", &TestDB{}, "Sec-Fetch-Site", "cross-site", "Origin", "https://trusted.example")
+	assert.Equal(w.Code, http.StatusOK, "Request from trusted origin must be accepted")
+	w = postShorten(form, "url=example.org", &TestDB{}, "Sec-Fetch-Site", "same-origin")
+	assert.Equal(w.Code, http.StatusOK, "Same-origin request must be accepted")
+
+	_, err := crossOriginProtection("https://ok.example, not an origin", nil)
+	assert.NotNil(err, "expected error for invalid origin")
+}
+
+func TestCORSPolicyTrustsListedOrigins(t *testing.T) {
+	policy, err := cors.New(cors.Config{AllowedOrigins: []string{"*", "https://*.example.org", "https://app.example.com"}})
+	assert := assert.NewAssert(t)
+	assert.Nil(err, "unexpected error")
+	protection, err := crossOriginProtection("", policy)
+	assert.Nil(err, "unexpected error")
+	handler := protection(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	for origin, expected := range map[string]int{
+		"https://evil.example":    http.StatusForbidden,
+		"https://app.example.org": http.StatusNoContent,
+		"https://example.org":     http.StatusForbidden,
+		"https://app.example.com": http.StatusNoContent,
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/shorten", nil)
+		req.Header.Set("Sec-Fetch-Site", "cross-site")
+		req.Header.Set("Origin", origin)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		assert.Equal(w.Code, expected, "unexpected status code for "+origin)
+	}
+}
+
 func TestUnshortenFollowsTheHappyPath(t *testing.T) {
 	w := setupUnshorten("/unshorten/veryShort", &TestDB{
 		key: []byte("veryShort"),
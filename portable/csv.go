@@ -0,0 +1,154 @@
+package portable
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path"
+	"strings"
+
+	dbpkg "github.com/makkes/shorty/db"
+)
+
+// Column aliases used by the CSV exports of other shorteners, normalized by
+// normalizeColumn.
+var (
+	keyColumns      = []string{"key", "keyword", "shortcode", "code", "slug", "address", "custombackhalf"}
+	shortURLColumns = []string{"shorturl", "bitlink", "link", "shortlink"}
+	urlColumns      = []string{"url", "longurl", "target", "destination", "originalurl"}
+	createdColumns  = []string{"created", "createdat", "datecreated", "creationdate", "timestamp"}
+	clicksColumns   = []string{"clicks", "visits", "visitscount", "visitcount", "totalclicks", "engagements"}
+)
+
+// normalizeColumn lowercases name and strips everything but letters and
+// digits so that e.g. "Long URL", "long_url" and "longUrl" are the same.
+func normalizeColumn(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r + 'a' - 'A'
+		default:
+			return -1
+		}
+	}, name)
+}
+
+// columnIndex returns the index of the first header matching one of aliases
+// or -1.
+func columnIndex(header []string, aliases []string) int {
+	for _, alias := range aliases {
+		for idx, name := range header {
+			if normalizeColumn(name) == alias {
+				return idx
+			}
+		}
+	}
+	return -1
+}
+
+func newCSVReader(r io.Reader) *csv.Reader {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.LazyQuotes = true
+	return cr
+}
+
+// field returns the trimmed field idx of record or the empty string if the
+// record doesn't have it.
+func field(record []string, idx int) string {
+	if idx < 0 || idx >= len(record) {
+		return ""
+	}
+	return strings.TrimSpace(record[idx])
+}
+
+// keyFromShortURL returns the last path segment of a short URL such as
+// "https://bit.ly/abc" or "bit.ly/abc".
+func keyFromShortURL(s string) string {
+	if !strings.Contains(s, "://") {
+		s = "https://" + s
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return ""
+	}
+	return path.Base("/" + strings.Trim(u.Path, "/"))
+}
+
+// readHeaderCSV reads CSV documents whose first line names the columns, such
+// as the exports of YOURLS, Shlink and Bitly.
+func readHeaderCSV(r io.Reader, fn func(dbpkg.Link) error) error {
+	cr := newCSVReader(r)
+	header, err := cr.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return errors.New("missing header")
+		}
+		return err
+	}
+	if len(header) > 0 {
+		header[0] = strings.TrimPrefix(header[0], "\ufeff")
+	}
+	keyIdx := columnIndex(header, keyColumns)
+	shortURLIdx := columnIndex(header, shortURLColumns)
+	urlIdx := columnIndex(header, urlColumns)
+	createdIdx := columnIndex(header, createdColumns)
+	clicksIdx := columnIndex(header, clicksColumns)
+	if urlIdx < 0 {
+		return fmt.Errorf("header %q lacks a URL column", header)
+	}
+	if keyIdx < 0 && shortURLIdx < 0 {
+		return fmt.Errorf("header %q lacks a key or short URL column", header)
+	}
+	for {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		key := field(record, keyIdx)
+		if key == "" && shortURLIdx >= 0 {
+			if shortURL := field(record, shortURLIdx); shortURL != "" {
+				key = keyFromShortURL(shortURL)
+			}
+		}
+		if err := fn(dbpkg.Link{
+			Key:     key,
+			URL:     field(record, urlIdx),
+			Created: parseTime(field(record, createdIdx)),
+			Clicks:  parseClicks(field(record, clicksIdx)),
+		}); err != nil {
+			return err
+		}
+	}
+}
+
+// readSimpleCSV reads records of the form key,url. A first line of
+// "key,url" is skipped as header.
+func readSimpleCSV(r io.Reader, fn func(dbpkg.Link) error) error {
+	cr := newCSVReader(r)
+	for first := true; ; first = false {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if first && len(record) > 0 {
+			record[0] = strings.TrimPrefix(record[0], "\ufeff")
+			if normalizeColumn(field(record, 0)) == "key" && normalizeColumn(field(record, 1)) == "url" {
+				continue
+			}
+		}
+		if err := fn(dbpkg.Link{Key: field(record, 0), URL: field(record, 1)}); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package portable
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	dbpkg "github.com/makkes/shorty/db"
+)
+
+// Format is the format of an imported document.
+type Format string
+
+// These constants define all supported import formats.
+const (
+	// FormatShorty is the document written by Export.
+	FormatShorty Format = "shorty"
+	// FormatYOURLSSQL is a MySQL dump of the YOURLS database.
+	FormatYOURLSSQL Format = "yourls-sql"
+	// FormatYOURLSCSV is a CSV export of the yourls_url table.
+	FormatYOURLSCSV Format = "yourls-csv"
+	// FormatKutt is the JSON returned by Kutt's links API.
+	FormatKutt Format = "kutt"
+	// FormatShlink is the CSV export of the Shlink web client.
+	FormatShlink Format = "shlink"
+	// FormatBitly is the CSV export of Bitly links.
+	FormatBitly Format = "bitly"
+	// FormatCSV is a CSV document with the columns key and url and an
+	// optional header.
+	FormatCSV Format = "csv"
+)
+
+// readers maps each format to a function that calls fn for every link read
+// from r.
+var readers = map[Format]func(r io.Reader, fn func(dbpkg.Link) error) error{
+	FormatShorty:    readShorty,
+	FormatYOURLSSQL: readYOURLSSQL,
+	FormatYOURLSCSV: readHeaderCSV,
+	FormatKutt:      readKutt,
+	FormatShlink:    readHeaderCSV,
+	FormatBitly:     readHeaderCSV,
+	FormatCSV:       readSimpleCSV,
+}
+
+// Formats returns the names of all supported import formats.
+func Formats() []Format {
+	return []Format{FormatShorty, FormatYOURLSSQL, FormatYOURLSCSV, FormatKutt, FormatShlink, FormatBitly, FormatCSV}
+}
+
+// ParseFormat returns the Format named s.
+func ParseFormat(s string) (Format, error) {
+	f := Format(s)
+	if _, ok := readers[f]; !ok {
+		names := make([]string, 0, len(readers))
+		for _, f := range Formats() {
+			names = append(names, string(f))
+		}
+		return "", fmt.Errorf("unknown format %q, must be one of %s", s, strings.Join(names, ", "))
+	}
+	return f, nil
+}
+
+// timeLayouts are the layouts of the dates found in other shorteners'
+// exports. Dates without a time zone are taken as UTC.
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05 -0700 MST",
+	"2006-01-02",
+}
+
+// parseTime returns the zero time if s is empty or cannot be parsed as the
+// creation date is not essential to a link.
+func parseTime(s string) time.Time {
+	s = strings.TrimSpace(s)
+	for _, layout := range timeLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.UTC()
+		}
+	}
+	return time.Time{}
+}
+
+// parseClicks returns 0 if s cannot be parsed. Thousands separators are
+// ignored.
+func parseClicks(s string) uint64 {
+	n, _ := strconv.ParseUint(strings.ReplaceAll(strings.TrimSpace(s), ",", ""), 10, 64)
+	return n
+}
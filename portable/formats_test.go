@@ -0,0 +1,136 @@
+package portable_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/makkes/shorty/assert"
+	dbpkg "github.com/makkes/shorty/db"
+	"github.com/makkes/shorty/portable"
+)
+
+// linkDB keeps the metadata of saved links.
+type linkDB struct {
+	memoryDB
+	links map[string]dbpkg.Link
+}
+
+func (db linkDB) SaveLink(ctx context.Context, link dbpkg.Link, replace bool) error {
+	if !replace {
+		if err := db.SaveURL(ctx, link.URL, []byte(link.Key)); err != nil {
+			return err
+		}
+	}
+	db.memoryDB[link.Key] = link.URL
+	db.links[link.Key] = link
+	return nil
+}
+
+func newLinkDB() linkDB {
+	return linkDB{memoryDB: memoryDB{}, links: map[string]dbpkg.Link{}}
+}
+
+func TestImportFormats(t *testing.T) {
+	created := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	for _, tc := range []struct {
+		format portable.Format
+		doc    string
+	}{
+		{portable.FormatYOURLSSQL, "-- MySQL dump\n" +
+			"/*!40101 SET NAMES utf8mb4 */;\n" +
+			"CREATE TABLE `yourls_url` (\n" +
+			"  `keyword` varchar(100) NOT NULL DEFAULT '',\n" +
+			"  `url` text NOT NULL,\n" +
+			"  `title` text,\n" +
+			"  `timestamp` timestamp NOT NULL DEFAULT current_timestamp(),\n" +
+			"  `ip` varchar(41) NOT NULL,\n" +
+			"  `clicks` int(10) unsigned NOT NULL,\n" +
+			"  PRIMARY KEY (`keyword`),\n" +
+			"  KEY `ip` (`ip`)\n" +
+			") ENGINE=InnoDB;\n" +
+			"INSERT INTO `yourls_options` VALUES (1,'version','1.9');\n" +
+			"INSERT INTO `yourls_url` VALUES ('a','https://example.org/a','It\\'s ''A''; (really)','2021-03-04 05:06:07','127.0.0.1',42)," +
+			"('b','https://example.org/b?x=1,2',NULL,'2021-03-04 05:06:07','127.0.0.1',0);\n"},
+		{portable.FormatYOURLSSQL, "INSERT INTO yourls.yourls_url (url, keyword, clicks, timestamp) VALUES\n" +
+			"('https://example.org/a', 'a', 42, '2021-03-04 05:06:07'),\n" +
+			"('https://example.org/b?x=1,2', 'b', 0, '2021-03-04 05:06:07');"},
+		{portable.FormatYOURLSCSV, "keyword,url,title,timestamp,ip,clicks\n" +
+			"a,https://example.org/a,A,2021-03-04 05:06:07,127.0.0.1,42\n" +
+			"b,\"https://example.org/b?x=1,2\",B,2021-03-04 05:06:07,127.0.0.1,0\n"},
+		{portable.FormatKutt, `{"limit":10,"skip":0,"total":2,"data":[
+			{"id":"1","address":"a","target":"https://example.org/a","created_at":"2021-03-04T05:06:07Z","visit_count":42},
+			{"id":"2","address":"b","target":"https://example.org/b?x=1,2","created_at":"2021-03-04T05:06:07.000Z","visit_count":0}]}`},
+		{portable.FormatShlink, "createdAt,domain,shortCode,shortUrl,longUrl,title,tags,visits\n" +
+			"2021-03-04T05:06:07+00:00,,a,https://s.test/a,https://example.org/a,,,42\n" +
+			"2021-03-04T07:06:07+02:00,,b,https://s.test/b,\"https://example.org/b?x=1,2\",,,0\n"},
+		{portable.FormatBitly, "Created,Title,Long URL,Bitlink,Clicks\n" +
+			"2021-03-04 05:06:07,A,https://example.org/a,bit.ly/a,42\n" +
+			"2021-03-04 05:06:07,B,\"https://example.org/b?x=1,2\",https://bit.ly/b/,0\n"},
+	} {
+		t.Run(string(tc.format), func(t *testing.T) {
+			assert := assert.NewAssert(t)
+			db := newLinkDB()
+			res, err := portable.Import(t.Context(), strings.NewReader(tc.doc), db, portable.Options{Format: tc.format})
+			assert.Nil(err, fmt.Sprintf("unexpected error: %v", err))
+			assertResult(assert, res, portable.Result{Created: 2}, "unexpected result")
+			assert.Equal(db.links["a"], dbpkg.Link{Key: "a", URL: "https://example.org/a", Created: created, Clicks: 42}, "unexpected link a")
+			assert.Equal(db.links["b"], dbpkg.Link{Key: "b", URL: "https://example.org/b?x=1,2", Created: created}, "unexpected link b")
+		})
+	}
+}
+
+func TestImportReportsProblems(t *testing.T) {
+	doc := "key,url\n" +
+		"a,https://example.org/new\n" +
+		",https://example.org/empty\n" +
+		"b,javascript:alert(1)\n" +
+		"c/d,https://example.org/slash\n" +
+		"e,https://example.org/e\n" +
+		"f,/relative\n"
+
+	assert := assert.NewAssert(t)
+	db := memoryDB{"a": "https://example.org/old"}
+	res, err := portable.Import(t.Context(), strings.NewReader(doc), db, portable.Options{Format: portable.FormatCSV})
+	assert.Nil(err, "unexpected error")
+	assertResult(assert, res, portable.Result{Created: 1, Skipped: 1, Invalid: 4, Problems: []portable.Problem{
+		{Record: 1, Key: "a", URL: "https://example.org/new", Reason: "key is already used for https://example.org/old"},
+		{Record: 2, URL: "https://example.org/empty", Reason: "key is empty"},
+		{Record: 3, Key: "b", URL: "javascript:alert(1)", Reason: "invalid URL: must be an absolute http or https URL"},
+		{Record: 4, Key: "c/d", URL: "https://example.org/slash", Reason: "key contains a slash"},
+		{Record: 6, Key: "f", URL: "/relative", Reason: "invalid URL: must be an absolute http or https URL"},
+	}}, "unexpected result")
+	assert.Equal(len(db), 2, "unexpected number of links")
+	assert.Equal(db["e"], "https://example.org/e", "valid link has not been imported")
+}
+
+func TestImportRejectsInvalidFormats(t *testing.T) {
+	for _, tc := range []struct {
+		format portable.Format
+		doc    string
+	}{
+		{"unknown", ``},
+		{portable.FormatBitly, ``},
+		{portable.FormatShlink, "shortCode,title\na,A\n"},
+		{portable.FormatYOURLSCSV, "url,title\nhttps://example.org,A\n"},
+		{portable.FormatKutt, `"links"`},
+		{portable.FormatKutt, `{"data":[{"address":1}]}`},
+		{portable.FormatYOURLSSQL, "INSERT INTO `yourls_url` VALUES ('a','https://example.org"},
+		{portable.FormatYOURLSSQL, "INSERT INTO `yourls_url` SET keyword='a';"},
+	} {
+		_, err := portable.Import(t.Context(), strings.NewReader(tc.doc), memoryDB{}, portable.Options{Format: tc.format})
+		assert := assert.NewAssert(t)
+		assert.NotNil(err, fmt.Sprintf("expected error for %s document %q", tc.format, tc.doc))
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	assert := assert.NewAssert(t)
+	f, err := portable.ParseFormat("kutt")
+	assert.Nil(err, "unexpected error")
+	assert.Equal(f, portable.FormatKutt, "unexpected format")
+	_, err = portable.ParseFormat("tinyurl")
+	assert.NotNil(err, "expected error for unknown format")
+}
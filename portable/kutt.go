@@ -0,0 +1,64 @@
+package portable
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	dbpkg "github.com/makkes/shorty/db"
+)
+
+// kuttLink is a link as returned by Kutt's API.
+type kuttLink struct {
+	Address    string `json:"address"`
+	Target     string `json:"target"`
+	CreatedAt  string `json:"created_at"`
+	VisitCount uint64 `json:"visit_count"`
+}
+
+// readKutt reads the response of Kutt's GET /api/v2/links, i.e. an object
+// with the links in its data field, or a bare array of links.
+func readKutt(r io.Reader, fn func(dbpkg.Link) error) error {
+	dec := json.NewDecoder(r)
+	readLink := func(idx int) error {
+		var link kuttLink
+		if err := dec.Decode(&link); err != nil {
+			return fmt.Errorf("link %d: %w", idx, err)
+		}
+		return fn(dbpkg.Link{
+			Key:     link.Address,
+			URL:     link.Target,
+			Created: parseTime(link.CreatedAt),
+			Clicks:  link.VisitCount,
+		})
+	}
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	switch tok {
+	case json.Delim('['):
+		return readElements(dec, readLink)
+	case json.Delim('{'):
+	default:
+		return fmt.Errorf("expected an object or array but got %v", tok)
+	}
+	for dec.More() {
+		field, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		if field == "data" {
+			if err := readArray(dec, readLink); err != nil {
+				return err
+			}
+			continue
+		}
+		// skip pagination fields such as "total"
+		var ignored json.RawMessage
+		if err := dec.Decode(&ignored); err != nil {
+			return err
+		}
+	}
+	return expectDelim(dec, '}')
+}
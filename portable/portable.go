@@ -0,0 +1,228 @@
+// Package portable moves links between Shorty instances and backends using a
+// versioned JSON document of the form
+//
+//	{"version":1,"exported":"2024-03-01T12:00:00Z","links":[{"key":"abc","url":"https://example.org","created":"2024-01-01T00:00:00Z","clicks":42}]}
+//
+// and imports links from the exports of other URL shorteners. Both
+// directions are streamed so that the number of links isn't limited by the
+// available memory.
+package portable
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+
+	dbpkg "github.com/makkes/shorty/db"
+)
+
+// Version is the version of the format written by Export. Importing
+// FormatShorty accepts documents up to this version.
+const Version = 1
+
+// Export writes all links of db to w.
+func Export(ctx context.Context, w io.Writer, db dbpkg.DB) error {
+	header, err := json.Marshal(time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, `{"version":%d,"exported":%s,"links":[`, Version, header); err != nil {
+		return err
+	}
+	sep := "\n"
+	err = db.ListURLs(ctx, func(link dbpkg.Link) error {
+		data, err := json.Marshal(link)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%s%s", sep, data)
+		sep = ",\n"
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "\n]}\n")
+	return err
+}
+
+// OnConflict determines how Import handles links whose key is already in
+// use.
+type OnConflict string
+
+// These constants define all conflict strategies.
+const (
+	ConflictSkip      OnConflict = "skip"
+	ConflictOverwrite OnConflict = "overwrite"
+	ConflictFail      OnConflict = "fail"
+)
+
+// ParseOnConflict returns the OnConflict named s.
+func ParseOnConflict(s string) (OnConflict, error) {
+	switch c := OnConflict(s); c {
+	case ConflictSkip, ConflictOverwrite, ConflictFail:
+		return c, nil
+	default:
+		return "", fmt.Errorf("unknown conflict strategy %q, must be one of %q, %q or %q", s, ConflictSkip, ConflictOverwrite, ConflictFail)
+	}
+}
+
+// Options control an import.
+type Options struct {
+	// Format is the format of the imported document, defaulting to
+	// FormatShorty.
+	Format Format
+	// OnConflict defaults to ConflictFail for FormatShorty and to
+	// ConflictSkip for all other formats.
+	OnConflict OnConflict
+	// DryRun only reports what would be imported without changing the
+	// database.
+	DryRun bool
+}
+
+// A Problem is a record that has not been imported.
+type Problem struct {
+	// Record is the 1-based position of the record in the document.
+	Record int    `json:"record"`
+	Key    string `json:"key"`
+	URL    string `json:"url"`
+	Reason string `json:"reason"`
+}
+
+// Result summarizes an import.
+type Result struct {
+	Created     int  `json:"created"`
+	Overwritten int  `json:"overwritten"`
+	Skipped     int  `json:"skipped"`
+	Invalid     int  `json:"invalid"`
+	DryRun      bool `json:"dryRun"`
+	// Problems lists all invalid records and skipped collisions.
+	Problems []Problem `json:"problems,omitempty"`
+}
+
+// A DBError is an error of the database links are imported into, as opposed
+// to problems with the imported document.
+type DBError struct {
+	Key string
+	Err error
+}
+
+func (e DBError) Error() string {
+	return fmt.Sprintf("link %q: %v", e.Key, e.Err)
+}
+
+func (e DBError) Unwrap() error {
+	return e.Err
+}
+
+// Import reads links in the given format from r and saves them in db. Links
+// are saved one at a time, so a failing import leaves the links imported so
+// far in place. Invalid links are skipped and reported in the result along
+// with skipped collisions. Failures of db are returned as DBError.
+func Import(ctx context.Context, r io.Reader, db dbpkg.DB, opts Options) (Result, error) {
+	if opts.Format == "" {
+		opts.Format = FormatShorty
+	}
+	read, ok := readers[opts.Format]
+	if !ok {
+		return Result{DryRun: opts.DryRun}, fmt.Errorf("unknown format %q", opts.Format)
+	}
+	if opts.OnConflict == "" {
+		opts.OnConflict = ConflictSkip
+		if opts.Format == FormatShorty {
+			opts.OnConflict = ConflictFail
+		}
+	}
+	imp := importer{ctx: ctx, db: db, opts: opts, res: Result{DryRun: opts.DryRun}}
+	err := read(r, imp.save)
+	return imp.res, err
+}
+
+// importer saves links one by one, keeping track of the result.
+type importer struct {
+	ctx     context.Context
+	db      dbpkg.DB
+	opts    Options
+	res     Result
+	records int
+}
+
+// validate checks whether link can be served by Shorty.
+func validate(link dbpkg.Link) error {
+	if link.Key == "" {
+		return errors.New("key is empty")
+	}
+	if strings.Contains(link.Key, "/") {
+		return errors.New("key contains a slash")
+	}
+	u, err := url.Parse(link.URL)
+	if err != nil {
+		return fmt.Errorf("invalid URL: %w", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("invalid URL: must be an absolute http or https URL")
+	}
+	return nil
+}
+
+func (imp *importer) problem(link dbpkg.Link, reason string) {
+	imp.res.Problems = append(imp.res.Problems, Problem{
+		Record: imp.records,
+		Key:    link.Key,
+		URL:    link.URL,
+		Reason: reason,
+	})
+}
+
+func (imp *importer) save(link dbpkg.Link) error {
+	imp.records++
+	if err := validate(link); err != nil {
+		imp.res.Invalid++
+		imp.problem(link, err.Error())
+		return nil
+	}
+	existing, err := imp.db.GetURL(imp.ctx, []byte(link.Key))
+	if err != nil {
+		return DBError{Key: link.Key, Err: err}
+	}
+	replace := false
+	switch {
+	case existing == nil:
+		imp.res.Created++
+	case string(existing) == link.URL:
+		imp.res.Skipped++
+		return nil
+	case imp.opts.OnConflict == ConflictSkip:
+		imp.res.Skipped++
+		imp.problem(link, fmt.Sprintf("key is already used for %s", existing))
+		return nil
+	case imp.opts.OnConflict == ConflictOverwrite:
+		imp.res.Overwritten++
+		replace = true
+	default:
+		return fmt.Errorf("link %q: %w", link.Key, dbpkg.NewErrKeyCollision([]byte(link.Key)))
+	}
+	if imp.opts.DryRun {
+		return nil
+	}
+	if saver, ok := imp.db.(dbpkg.LinkSaver); ok {
+		err = saver.SaveLink(imp.ctx, link, replace)
+	} else if replace {
+		err = imp.db.ReplaceURL(imp.ctx, link.URL, []byte(link.Key))
+	} else {
+		err = imp.db.SaveURL(imp.ctx, link.URL, []byte(link.Key))
+	}
+	if errors.Is(err, dbpkg.ErrKeyCollision{}) {
+		// the key has been taken since checking it
+		return fmt.Errorf("link %q: %w", link.Key, err)
+	}
+	if err != nil {
+		return DBError{Key: link.Key, Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package portable_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/makkes/shorty/assert"
+	dbpkg "github.com/makkes/shorty/db"
+	"github.com/makkes/shorty/portable"
+)
+
+type memoryDB map[string]string
+
+func (db memoryDB) SaveURL(ctx context.Context, url string, key []byte) error {
+	if _, ok := db[string(key)]; ok {
+		return dbpkg.NewErrKeyCollision(key)
+	}
+	db[string(key)] = url
+	return nil
+}
+
+func (db memoryDB) ReplaceURL(ctx context.Context, url string, key []byte) error {
+	db[string(key)] = url
+	return nil
+}
+
+func (db memoryDB) GetURL(ctx context.Context, key []byte) ([]byte, error) {
+	if url, ok := db[string(key)]; ok {
+		return []byte(url), nil
+	}
+	return nil, nil
+}
+
+func (db memoryDB) GetStats(ctx context.Context) (dbpkg.Stats, error) {
+	return dbpkg.Stats{StoredURLs: len(db)}, nil
+}
+
+func (db memoryDB) ListURLs(ctx context.Context, fn func(dbpkg.Link) error) error {
+	for _, key := range slices.Sorted(maps.Keys(db)) {
+		if err := fn(dbpkg.Link{Key: key, URL: db[key]}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// assertResult compares results by their printed form as they aren't
+// comparable.
+func assertResult(assert *assert.Assert, actual, expected portable.Result, msg string) {
+	assert.Equal(fmt.Sprintf("%+v", actual), fmt.Sprintf("%+v", expected), msg)
+}
+
+func TestExportAndImport(t *testing.T) {
+	src := memoryDB{"a": "http://example.org/a", "b": `http://example.org/"b"`}
+	var buf bytes.Buffer
+
+	assert := assert.NewAssert(t)
+	assert.Nil(portable.Export(t.Context(), &buf, src), "unexpected error")
+	assert.Match(`^\{"version":1,"exported":"[^"]+","links":\[\n\{"key":"a","url":"http://example.org/a"\},\n\{"key":"b",`, buf.String(), "unexpected document")
+
+	dst := memoryDB{}
+	res, err := portable.Import(t.Context(), bytes.NewReader(buf.Bytes()), dst, portable.Options{})
+	assert.Nil(err, "unexpected error")
+	assertResult(assert, res, portable.Result{Created: 2}, "unexpected result")
+	assert.Equal(maps.Equal(src, dst), true, "links have not been imported")
+
+	// exporting an empty database yields a valid document, too
+	buf.Reset()
+	assert.Nil(portable.Export(t.Context(), &buf, memoryDB{}), "unexpected error")
+	res, err = portable.Import(t.Context(), &buf, dst, portable.Options{})
+	assert.Nil(err, "unexpected error")
+	assertResult(assert, res, portable.Result{}, "unexpected result")
+}
+
+func TestImportHandlesConflicts(t *testing.T) {
+	doc := `{"version":1,"links":[{"key":"a","url":"http://a"},{"key":"b","url":"http://new"},{"key":"c","url":"http://c"}]}`
+	newDB := func() memoryDB {
+		return memoryDB{"a": "http://a", "b": "http://old"}
+	}
+
+	assert := assert.NewAssert(t)
+	db := newDB()
+	res, err := portable.Import(t.Context(), strings.NewReader(doc), db, portable.Options{OnConflict: portable.ConflictSkip})
+	assert.Nil(err, "unexpected error")
+	assertResult(assert, res, portable.Result{Created: 1, Skipped: 2, Problems: []portable.Problem{
+		{Record: 2, Key: "b", URL: "http://new", Reason: "key is already used for http://old"},
+	}}, "unexpected result when skipping")
+	assert.Equal(db["b"], "http://old", "conflicting link has been overwritten")
+
+	db = newDB()
+	res, err = portable.Import(t.Context(), strings.NewReader(doc), db, portable.Options{OnConflict: portable.ConflictOverwrite})
+	assert.Nil(err, "unexpected error")
+	assertResult(assert, res, portable.Result{Created: 1, Overwritten: 1, Skipped: 1}, "unexpected result when overwriting")
+	assert.Equal(db["b"], "http://new", "conflicting link has not been overwritten")
+
+	db = newDB()
+	res, err = portable.Import(t.Context(), strings.NewReader(doc), db, portable.Options{OnConflict: portable.ConflictFail})
+	assert.Equal(errors.Is(err, dbpkg.ErrKeyCollision{}), true, "expected key collision")
+	assertResult(assert, res, portable.Result{Skipped: 1}, "unexpected result when failing")
+	assert.Equal(len(db), 2, "unexpected links after failed import")
+
+	db = newDB()
+	res, err = portable.Import(t.Context(), strings.NewReader(doc), db, portable.Options{OnConflict: portable.ConflictOverwrite, DryRun: true})
+	assert.Nil(err, "unexpected error")
+	assertResult(assert, res, portable.Result{Created: 1, Overwritten: 1, Skipped: 1, DryRun: true}, "unexpected result of dry run")
+	assert.Equal(maps.Equal(db, newDB()), true, "dry run has changed the database")
+}
+
+func TestImportRejectsInvalidDocuments(t *testing.T) {
+	for _, doc := range []string{
+		``,
+		`[]`,
+		`{"links":[]}`,
+		`{"links":[],"version":1}`,
+		`{"version":2,"links":[]}`,
+		`{"version":1,"links":[{"key":"a","url":3}]}`,
+		`{"version":1,"links":[{"key":"a","url":"http://a"}`,
+		`{"version":1,"links":{}}`,
+	} {
+		_, err := portable.Import(t.Context(), strings.NewReader(doc), memoryDB{}, portable.Options{})
+		assert := assert.NewAssert(t)
+		assert.NotNil(err, "expected error for "+doc)
+	}
+}
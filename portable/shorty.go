@@ -0,0 +1,88 @@
+package portable
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	dbpkg "github.com/makkes/shorty/db"
+)
+
+// readShorty reads a document written by Export.
+func readShorty(r io.Reader, fn func(dbpkg.Link) error) error {
+	dec := json.NewDecoder(r)
+	if err := expectDelim(dec, '{'); err != nil {
+		return err
+	}
+	version := 0
+	for dec.More() {
+		field, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		switch field {
+		case "version":
+			if err := dec.Decode(&version); err != nil {
+				return fmt.Errorf("invalid version: %w", err)
+			}
+			if version < 1 || version > Version {
+				return fmt.Errorf("unsupported version %d", version)
+			}
+		case "links":
+			if version == 0 {
+				return errors.New("version must precede links")
+			}
+			if err := readArray(dec, func(idx int) error {
+				var link dbpkg.Link
+				if err := dec.Decode(&link); err != nil {
+					return fmt.Errorf("link %d: %w", idx, err)
+				}
+				return fn(link)
+			}); err != nil {
+				return err
+			}
+		default:
+			// skip unknown fields such as "exported"
+			var ignored json.RawMessage
+			if err := dec.Decode(&ignored); err != nil {
+				return err
+			}
+		}
+	}
+	if version == 0 {
+		return errors.New("missing version")
+	}
+	return expectDelim(dec, '}')
+}
+
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok != delim {
+		return fmt.Errorf("expected %q but got %v", delim, tok)
+	}
+	return nil
+}
+
+// readArray calls fn for every element of the array at the current position
+// of dec. fn must decode the element.
+func readArray(dec *json.Decoder, fn func(idx int) error) error {
+	if err := expectDelim(dec, '['); err != nil {
+		return err
+	}
+	return readElements(dec, fn)
+}
+
+// readElements is like readArray but expects the opening bracket to have been
+// read already.
+func readElements(dec *json.Decoder, fn func(idx int) error) error {
+	for idx := 0; dec.More(); idx++ {
+		if err := fn(idx); err != nil {
+			return err
+		}
+	}
+	return expectDelim(dec, ']')
+}
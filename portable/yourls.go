@@ -0,0 +1,458 @@
+package portable
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	dbpkg "github.com/makkes/shorty/db"
+)
+
+// yourlsColumns is the column order of the yourls_url table, used for INSERT
+// statements without column list when the dump lacks the CREATE TABLE
+// statement.
+var yourlsColumns = []string{"keyword", "url", "title", "timestamp", "ip", "clicks"}
+
+// readYOURLSSQL reads the INSERT statements into the yourls_url table from a
+// MySQL dump, e.g. created by mysqldump or phpMyAdmin. All other statements
+// are ignored.
+func readYOURLSSQL(r io.Reader, fn func(dbpkg.Link) error) error {
+	p := sqlParser{lex: sqlLexer{r: bufio.NewReader(r)}, columns: yourlsColumns}
+	for {
+		tok, err := p.lex.next()
+		if err != nil {
+			return err
+		}
+		switch {
+		case tok.kind == sqlEOF:
+			return nil
+		case tok.isWord("CREATE"):
+			err = p.createTable()
+		case tok.isWord("INSERT"), tok.isWord("REPLACE"):
+			err = p.insert(fn)
+		default:
+			err = p.skipStatement(tok)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+type sqlTokenKind int
+
+const (
+	sqlEOF sqlTokenKind = iota
+	// sqlWord is a keyword, unquoted identifier or number.
+	sqlWord
+	// sqlIdent is a backquoted identifier.
+	sqlIdent
+	sqlString
+	sqlPunct
+)
+
+type sqlToken struct {
+	kind sqlTokenKind
+	text string
+}
+
+func (t sqlToken) isWord(w string) bool {
+	return t.kind == sqlWord && strings.EqualFold(t.text, w)
+}
+
+func (t sqlToken) isPunct(p string) bool {
+	return t.kind == sqlPunct && t.text == p
+}
+
+// name returns the identifier t denotes, lowercased.
+func (t sqlToken) name() string {
+	return strings.ToLower(t.text)
+}
+
+// sqlLexer splits a MySQL dump into tokens, skipping whitespace and comments.
+type sqlLexer struct {
+	r      *bufio.Reader
+	peeked *sqlToken
+}
+
+func (l *sqlLexer) unread(tok sqlToken) {
+	l.peeked = &tok
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || r == '.' || r == '$' || r == '-' || r == '+' ||
+		(r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r > 127
+}
+
+// peekIs tells whether the next rune is r without consuming it.
+func (l *sqlLexer) peekIs(r byte) bool {
+	next, err := l.r.Peek(1)
+	return err == nil && next[0] == r
+}
+
+func (l *sqlLexer) skipLine() error {
+	_, err := l.r.ReadString('\n')
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+	return err
+}
+
+func (l *sqlLexer) skipBlockComment() error {
+	prev := rune(0)
+	for {
+		r, _, err := l.r.ReadRune()
+		if err != nil {
+			return fmt.Errorf("unterminated comment: %w", err)
+		}
+		if prev == '*' && r == '/' {
+			return nil
+		}
+		prev = r
+	}
+}
+
+func (l *sqlLexer) next() (sqlToken, error) {
+	if l.peeked != nil {
+		tok := *l.peeked
+		l.peeked = nil
+		return tok, nil
+	}
+	for {
+		r, _, err := l.r.ReadRune()
+		if errors.Is(err, io.EOF) {
+			return sqlToken{kind: sqlEOF}, nil
+		}
+		if err != nil {
+			return sqlToken{}, err
+		}
+		switch {
+		case r == ' ' || r == '\t' || r == '\n' || r == '\r':
+			continue
+		case r == '#', r == '-' && l.peekIs('-'):
+			if err := l.skipLine(); err != nil {
+				return sqlToken{}, err
+			}
+			continue
+		case r == '/' && l.peekIs('*'):
+			if err := l.skipBlockComment(); err != nil {
+				return sqlToken{}, err
+			}
+			continue
+		case r == '`':
+			text, err := l.quoted('`', false)
+			return sqlToken{kind: sqlIdent, text: text}, err
+		case r == '\'' || r == '"':
+			text, err := l.quoted(byte(r), true)
+			return sqlToken{kind: sqlString, text: text}, err
+		case isWordRune(r):
+			var sb strings.Builder
+			sb.WriteRune(r)
+			for {
+				r, _, err := l.r.ReadRune()
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				if err != nil {
+					return sqlToken{}, err
+				}
+				if !isWordRune(r) {
+					_ = l.r.UnreadRune()
+					break
+				}
+				sb.WriteRune(r)
+			}
+			return sqlToken{kind: sqlWord, text: sb.String()}, nil
+		default:
+			return sqlToken{kind: sqlPunct, text: string(r)}, nil
+		}
+	}
+}
+
+// sqlEscapes maps MySQL's backslash escape sequences to the characters they
+// represent. Other escaped characters stand for themselves.
+var sqlEscapes = map[rune]rune{
+	'0': 0,
+	'b': '\b',
+	'n': '\n',
+	'r': '\r',
+	't': '\t',
+	'Z': 26,
+}
+
+// quoted reads up to the closing quote. A doubled quote stands for the quote
+// itself.
+func (l *sqlLexer) quoted(quote byte, backslash bool) (string, error) {
+	var sb strings.Builder
+	for {
+		r, _, err := l.r.ReadRune()
+		if err != nil {
+			return "", fmt.Errorf("unterminated string: %w", err)
+		}
+		switch {
+		case backslash && r == '\\':
+			r, _, err = l.r.ReadRune()
+			if err != nil {
+				return "", fmt.Errorf("unterminated string: %w", err)
+			}
+			if unescaped, ok := sqlEscapes[r]; ok {
+				r = unescaped
+			}
+		case r == rune(quote):
+			if !l.peekIs(quote) {
+				return sb.String(), nil
+			}
+			_, _ = l.r.ReadByte()
+		}
+		sb.WriteRune(r)
+	}
+}
+
+type sqlParser struct {
+	lex     sqlLexer
+	columns []string
+}
+
+// skipStatement skips tokens up to and including the next semicolon.
+func (p *sqlParser) skipStatement(tok sqlToken) error {
+	for tok.kind != sqlEOF && !tok.isPunct(";") {
+		var err error
+		if tok, err = p.lex.next(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tableName reads a possibly qualified table name and returns the name
+// without database.
+func (p *sqlParser) tableName() (string, error) {
+	tok, err := p.lex.next()
+	if err != nil {
+		return "", err
+	}
+	name := tok.name()
+	for {
+		dot, err := p.lex.next()
+		if err != nil {
+			return "", err
+		}
+		if !(dot.isWord(".") || dot.isPunct(".")) {
+			p.lex.unread(dot)
+			break
+		}
+		if tok, err = p.lex.next(); err != nil {
+			return "", err
+		}
+		name = tok.name()
+	}
+	// unquoted qualified names are read as one word
+	if idx := strings.LastIndex(name, "."); idx >= 0 && tok.kind == sqlWord {
+		name = name[idx+1:]
+	}
+	return name, nil
+}
+
+func isURLTable(name string) bool {
+	return name == "url" || strings.HasSuffix(name, "_url")
+}
+
+// isWords reads the given words and tells whether they were all present. The
+// first mismatching token is left unread.
+func (p *sqlParser) isWords(words ...string) (bool, error) {
+	for _, w := range words {
+		tok, err := p.lex.next()
+		if err != nil {
+			return false, err
+		}
+		if !tok.isWord(w) {
+			p.lex.unread(tok)
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// tableDefinitionKeywords start the definitions within CREATE TABLE that
+// aren't columns.
+var tableDefinitionKeywords = map[string]bool{
+	"primary": true, "key": true, "unique": true, "index": true, "constraint": true,
+	"fulltext": true, "spatial": true, "foreign": true, "check": true,
+}
+
+// createTable learns the column order of the URL table from its definition.
+func (p *sqlParser) createTable() error {
+	if ok, err := p.isWords("TABLE"); err != nil || !ok {
+		return errors.Join(err, p.skipStatement(sqlToken{kind: sqlWord}))
+	}
+	if _, err := p.isWords("IF", "NOT", "EXISTS"); err != nil {
+		return err
+	}
+	name, err := p.tableName()
+	if err != nil {
+		return err
+	}
+	tok, err := p.lex.next()
+	if err != nil {
+		return err
+	}
+	if !isURLTable(name) || !tok.isPunct("(") {
+		return p.skipStatement(tok)
+	}
+	var columns []string
+	depth, itemStart := 1, true
+	for depth > 0 {
+		tok, err := p.lex.next()
+		if err != nil {
+			return err
+		}
+		switch {
+		case tok.kind == sqlEOF:
+			return errors.New("unterminated CREATE TABLE statement")
+		case tok.isPunct("("):
+			depth++
+		case tok.isPunct(")"):
+			depth--
+		case tok.isPunct(",") && depth == 1:
+			itemStart = true
+			continue
+		case itemStart && depth == 1 && (tok.kind == sqlIdent || tok.kind == sqlWord && !tableDefinitionKeywords[tok.name()]):
+			columns = append(columns, tok.name())
+		}
+		itemStart = false
+	}
+	if len(columns) > 0 {
+		p.columns = columns
+	}
+	return p.skipStatement(sqlToken{kind: sqlWord})
+}
+
+// insert reads the rows inserted into the URL table.
+func (p *sqlParser) insert(fn func(dbpkg.Link) error) error {
+	var tok sqlToken
+	var err error
+	// skip modifiers such as IGNORE
+	for {
+		if tok, err = p.lex.next(); err != nil {
+			return err
+		}
+		if tok.isWord("INTO") || tok.kind == sqlEOF || tok.isPunct(";") {
+			break
+		}
+	}
+	if !tok.isWord("INTO") {
+		return p.skipStatement(tok)
+	}
+	name, err := p.tableName()
+	if err != nil {
+		return err
+	}
+	if tok, err = p.lex.next(); err != nil {
+		return err
+	}
+	if !isURLTable(name) {
+		return p.skipStatement(tok)
+	}
+	columns := p.columns
+	if tok.isPunct("(") {
+		if columns, err = p.columnList(); err != nil {
+			return err
+		}
+		if tok, err = p.lex.next(); err != nil {
+			return err
+		}
+	}
+	if !tok.isWord("VALUES") && !tok.isWord("VALUE") {
+		return fmt.Errorf("unsupported INSERT statement into %s: expected VALUES but got %q", name, tok.text)
+	}
+	index := make(map[string]int, len(columns))
+	for idx, col := range columns {
+		index[col] = idx
+	}
+	col := func(row []string, name string) string {
+		idx, ok := index[name]
+		if !ok {
+			return ""
+		}
+		return field(row, idx)
+	}
+	for {
+		if tok, err = p.lex.next(); err != nil {
+			return err
+		}
+		if !tok.isPunct("(") {
+			return fmt.Errorf("expected row but got %q", tok.text)
+		}
+		row, err := p.row()
+		if err != nil {
+			return err
+		}
+		if err := fn(dbpkg.Link{
+			Key:     col(row, "keyword"),
+			URL:     col(row, "url"),
+			Created: parseTime(col(row, "timestamp")),
+			Clicks:  parseClicks(col(row, "clicks")),
+		}); err != nil {
+			return err
+		}
+		if tok, err = p.lex.next(); err != nil {
+			return err
+		}
+		if !tok.isPunct(",") {
+			return p.skipStatement(tok)
+		}
+	}
+}
+
+// columnList reads the column names up to the closing parenthesis.
+func (p *sqlParser) columnList() ([]string, error) {
+	var columns []string
+	for {
+		tok, err := p.lex.next()
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case tok.kind == sqlIdent || tok.kind == sqlWord:
+			columns = append(columns, tok.name())
+		case tok.isPunct(","):
+		case tok.isPunct(")"):
+			return columns, nil
+		default:
+			return nil, fmt.Errorf("unexpected %q in column list", tok.text)
+		}
+	}
+}
+
+// row reads the values of a row up to the closing parenthesis. NULL is read
+// as the empty string and nested expressions are ignored.
+func (p *sqlParser) row() ([]string, error) {
+	var row []string
+	value, depth := "", 0
+	for {
+		tok, err := p.lex.next()
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case tok.kind == sqlEOF:
+			return nil, errors.New("unterminated row")
+		case tok.isPunct("("):
+			depth++
+		case tok.isPunct(")") && depth > 0:
+			depth--
+		case depth > 0:
+		case tok.isPunct(","), tok.isPunct(")"):
+			row = append(row, value)
+			value = ""
+			if tok.isPunct(")") {
+				return row, nil
+			}
+		case tok.isWord("NULL"):
+		case tok.kind == sqlString || tok.kind == sqlWord:
+			value = tok.text
+		}
+	}
+}
@@ -0,0 +1,277 @@
+package ratelimiter
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// A Decision is the outcome of an Algorithm taking a request.
+type Decision struct {
+	// Allowed tells whether the request may pass.
+	Allowed bool
+	// Limit is the maximum number of requests a client may send at once.
+	Limit int
+	// Window is the time it takes to restore a quota of Limit requests.
+	Window time.Duration
+	// Remaining is the number of requests the client may still send right now.
+	Remaining int
+	// Reset is the time until the client's quota is fully restored.
+	Reset time.Duration
+	// RetryAfter is the time until the next request will be allowed. It is
+	// zero for allowed requests.
+	RetryAfter time.Duration
+}
+
+// An Algorithm implements a rate limiting strategy on top of the opaque state
+// kept in a Store.
+type Algorithm interface {
+	// Take accounts for a request at time now, given the client's current
+	// state, which is nil for new clients. It returns the new state.
+	Take(state []byte, now time.Time, limit Limit) ([]byte, Decision, error)
+	// TTL returns how long a client's state needs to be kept. Expired state
+	// must be equivalent to a new client.
+	TTL(limit Limit) time.Duration
+}
+
+// Algorithm names as used in a Policy.
+const (
+	AlgorithmTokenBucket          = "token-bucket"
+	AlgorithmSlidingWindowLog     = "sliding-window-log"
+	AlgorithmSlidingWindowCounter = "sliding-window-counter"
+	AlgorithmGCRA                 = "gcra"
+)
+
+var algorithms = map[string]Algorithm{
+	AlgorithmTokenBucket:          TokenBucket{},
+	AlgorithmSlidingWindowLog:     SlidingWindowLog{},
+	AlgorithmSlidingWindowCounter: SlidingWindowCounter{},
+	AlgorithmGCRA:                 GCRA{},
+}
+
+// interval returns the time it takes to replenish a single request.
+func (l Limit) interval() time.Duration {
+	return time.Duration(l.Period) / time.Duration(l.Rate)
+}
+
+func putInt64s(values ...int64) []byte {
+	res := make([]byte, 8*len(values))
+	for idx, value := range values {
+		binary.BigEndian.PutUint64(res[8*idx:], uint64(value))
+	}
+	return res
+}
+
+func getInt64s(data []byte, n int) ([]int64, error) {
+	if len(data) != 8*n {
+		return nil, fmt.Errorf("invalid state of length %d, expected %d", len(data), 8*n)
+	}
+	res := make([]int64, n)
+	for idx := range res {
+		res[idx] = int64(binary.BigEndian.Uint64(data[8*idx:]))
+	}
+	return res, nil
+}
+
+// TokenBucket gives each client a bucket of Burst tokens that is refilled
+// continuously at Rate tokens per Period. Each request takes one token.
+type TokenBucket struct{}
+
+var _ Algorithm = TokenBucket{}
+
+// Take implements Algorithm.
+func (TokenBucket) Take(state []byte, now time.Time, limit Limit) ([]byte, Decision, error) {
+	burst := float64(limit.burst())
+	interval := float64(limit.interval())
+	tokens := burst
+	if state != nil {
+		values, err := getInt64s(state, 2)
+		if err != nil {
+			return nil, Decision{}, err
+		}
+		elapsed := max(0, now.UnixNano()-values[1])
+		tokens = math.Min(burst, math.Float64frombits(uint64(values[0]))+float64(elapsed)/interval)
+	}
+
+	d := Decision{
+		Limit:   limit.burst(),
+		Window:  time.Duration(limit.burst()) * limit.interval(),
+		Allowed: tokens >= 1,
+	}
+	if d.Allowed {
+		tokens--
+	} else {
+		d.RetryAfter = time.Duration((1 - tokens) * interval)
+	}
+	d.Remaining = int(tokens)
+	d.Reset = time.Duration((burst - tokens) * interval)
+
+	return putInt64s(int64(math.Float64bits(tokens)), now.UnixNano()), d, nil
+}
+
+// TTL implements Algorithm.
+func (TokenBucket) TTL(limit Limit) time.Duration {
+	return time.Duration(limit.burst()) * limit.interval()
+}
+
+// SlidingWindowLog records the time of each request and allows at most Rate
+// requests within any interval of length Period. Burst is ignored. The state
+// grows linearly with Rate.
+type SlidingWindowLog struct{}
+
+var _ Algorithm = SlidingWindowLog{}
+
+// Take implements Algorithm.
+func (SlidingWindowLog) Take(state []byte, now time.Time, limit Limit) ([]byte, Decision, error) {
+	if len(state)%8 != 0 {
+		return nil, Decision{}, fmt.Errorf("invalid state of length %d", len(state))
+	}
+	log, _ := getInt64s(state, len(state)/8)
+	sort.Slice(log, func(i, j int) bool { return log[i] < log[j] })
+
+	// drop all requests that have left the window
+	cutoff := now.UnixNano() - int64(limit.Period)
+	idx := sort.Search(len(log), func(i int) bool { return log[i] > cutoff })
+	log = log[idx:]
+
+	d := Decision{
+		Limit:   limit.Rate,
+		Window:  time.Duration(limit.Period),
+		Allowed: len(log) < limit.Rate,
+	}
+	if d.Allowed {
+		log = append(log, now.UnixNano())
+	} else {
+		// the oldest request within the window has to leave it first
+		d.RetryAfter = time.Duration(log[len(log)-limit.Rate] - cutoff)
+	}
+	d.Remaining = max(0, limit.Rate-len(log))
+	if len(log) > 0 {
+		d.Reset = time.Duration(log[len(log)-1] - cutoff)
+	}
+
+	return putInt64s(log...), d, nil
+}
+
+// TTL implements Algorithm.
+func (SlidingWindowLog) TTL(limit Limit) time.Duration {
+	return time.Duration(limit.Period)
+}
+
+// SlidingWindowCounter approximates a sliding window by weighting the count of
+// the previous fixed window by its overlap with the sliding window. It needs
+// constant space per client. Burst is ignored.
+type SlidingWindowCounter struct{}
+
+var _ Algorithm = SlidingWindowCounter{}
+
+// Take implements Algorithm.
+func (SlidingWindowCounter) Take(state []byte, now time.Time, limit Limit) ([]byte, Decision, error) {
+	period := int64(limit.Period)
+	start := now.UnixNano() - now.UnixNano()%period
+	var prev, curr int64
+	if state != nil {
+		values, err := getInt64s(state, 3)
+		if err != nil {
+			return nil, Decision{}, err
+		}
+		switch {
+		case values[0] == start:
+			prev, curr = values[1], values[2]
+		case values[0] == start-period:
+			prev = values[2]
+		}
+	}
+
+	elapsed := now.UnixNano() - start
+	rate := float64(limit.Rate)
+	estimate := func(prev, curr int64, elapsed int64) float64 {
+		return float64(prev)*float64(period-elapsed)/float64(period) + float64(curr)
+	}
+
+	d := Decision{
+		Limit:   limit.Rate,
+		Window:  time.Duration(limit.Period),
+		Allowed: estimate(prev, curr, elapsed)+1 <= rate,
+	}
+	if d.Allowed {
+		curr++
+	} else {
+		d.RetryAfter = retryAfterSlidingWindow(prev, curr, elapsed, period, int64(limit.Rate))
+	}
+	d.Remaining = max(0, int(math.Floor(rate-estimate(prev, curr, elapsed))))
+	switch {
+	case curr > 0:
+		d.Reset = time.Duration(2*period - elapsed)
+	case prev > 0:
+		d.Reset = time.Duration(period - elapsed)
+	}
+
+	return putInt64s(start, prev, curr), d, nil
+}
+
+// retryAfterSlidingWindow returns the time until the estimated count drops
+// low enough to allow another request.
+func retryAfterSlidingWindow(prev, curr, elapsed, period, rate int64) time.Duration {
+	// decayedAt returns the offset into a window at which count, weighted by
+	// the remaining part of that window, drops to at most budget.
+	decayedAt := func(count, budget int64) int64 {
+		return period - (period/count)*budget - ((period%count)*budget)/count
+	}
+	if curr+1 <= rate && prev > 0 {
+		// the request is allowed once the previous window's weight has decayed enough
+		return time.Duration(max(0, decayedAt(prev, rate-1-curr)-elapsed))
+	}
+	// the current window becomes the previous one and has to decay
+	return time.Duration(period - elapsed + decayedAt(curr, rate-1))
+}
+
+// TTL implements Algorithm.
+func (SlidingWindowCounter) TTL(limit Limit) time.Duration {
+	return 2 * time.Duration(limit.Period)
+}
+
+// GCRA implements the generic cell rate algorithm. It behaves like
+// TokenBucket but only stores the theoretical arrival time of the next
+// request.
+type GCRA struct{}
+
+var _ Algorithm = GCRA{}
+
+// Take implements Algorithm.
+func (GCRA) Take(state []byte, now time.Time, limit Limit) ([]byte, Decision, error) {
+	interval := int64(limit.interval())
+	tolerance := int64(limit.burst()) * interval
+	tat := now.UnixNano()
+	if state != nil {
+		values, err := getInt64s(state, 1)
+		if err != nil {
+			return nil, Decision{}, err
+		}
+		tat = max(tat, values[0])
+	}
+
+	newTAT := tat + interval
+	allowAt := newTAT - tolerance
+	d := Decision{
+		Limit:   limit.burst(),
+		Window:  time.Duration(tolerance),
+		Allowed: now.UnixNano() >= allowAt,
+	}
+	if d.Allowed {
+		tat = newTAT
+	} else {
+		d.RetryAfter = time.Duration(allowAt - now.UnixNano())
+	}
+	d.Remaining = max(0, int((tolerance-(tat-now.UnixNano()))/interval))
+	d.Reset = time.Duration(tat - now.UnixNano())
+
+	return putInt64s(tat), d, nil
+}
+
+// TTL implements Algorithm.
+func (GCRA) TTL(limit Limit) time.Duration {
+	return time.Duration(limit.burst()) * limit.interval()
+}
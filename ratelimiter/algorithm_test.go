@@ -0,0 +1,188 @@
+package ratelimiter_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/makkes/shorty/assert"
+	"github.com/makkes/shorty/ratelimiter"
+)
+
+// fakeClock is a manually advanced clock.
+type fakeClock struct {
+	now time.Time
+}
+
+func (fc *fakeClock) Now() time.Time {
+	return fc.now
+}
+
+func (fc *fakeClock) Advance(d time.Duration) {
+	fc.now = fc.now.Add(d)
+}
+
+func newFakeClock() *fakeClock {
+	// aligned to a full minute so that fixed windows are predictable
+	return &fakeClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+}
+
+type step struct {
+	advance    time.Duration
+	allowed    bool
+	remaining  int
+	reset      time.Duration
+	retryAfter time.Duration
+}
+
+func runSteps(t *testing.T, algo ratelimiter.Algorithm, limit ratelimiter.Limit, steps []step) {
+	clock := newFakeClock()
+	var state []byte
+	for idx, s := range steps {
+		clock.Advance(s.advance)
+		var d ratelimiter.Decision
+		var err error
+		state, d, err = algo.Take(state, clock.Now(), limit)
+		if err != nil {
+			t.Fatalf("step %d: unexpected error: %v", idx, err)
+		}
+		if d.Allowed != s.allowed {
+			t.Errorf("step %d: expected allowed=%t but got %t", idx, s.allowed, d.Allowed)
+		}
+		if d.Remaining != s.remaining {
+			t.Errorf("step %d: expected remaining=%d but got %d", idx, s.remaining, d.Remaining)
+		}
+		if d.Reset != s.reset {
+			t.Errorf("step %d: expected reset=%s but got %s", idx, s.reset, d.Reset)
+		}
+		if d.RetryAfter != s.retryAfter {
+			t.Errorf("step %d: expected retryAfter=%s but got %s", idx, s.retryAfter, d.RetryAfter)
+		}
+	}
+}
+
+var threePerMinute = ratelimiter.Limit{Rate: 3, Period: ratelimiter.Duration(time.Minute)}
+
+func TestTokenBucket(t *testing.T) {
+	runSteps(t, ratelimiter.TokenBucket{}, threePerMinute, []step{
+		{allowed: true, remaining: 2, reset: 20 * time.Second},
+		{allowed: true, remaining: 1, reset: 40 * time.Second},
+		{allowed: true, remaining: 0, reset: time.Minute},
+		{allowed: false, remaining: 0, reset: time.Minute, retryAfter: 20 * time.Second},
+		// half a token has been refilled
+		{advance: 10 * time.Second, allowed: false, remaining: 0, reset: 50 * time.Second, retryAfter: 10 * time.Second},
+		{advance: 10 * time.Second, allowed: true, remaining: 0, reset: time.Minute},
+		// the bucket never holds more than its capacity
+		{advance: time.Hour, allowed: true, remaining: 2, reset: 20 * time.Second},
+	})
+}
+
+func TestTokenBucketHonorsBurst(t *testing.T) {
+	limit := ratelimiter.Limit{Rate: 3, Period: ratelimiter.Duration(time.Minute), Burst: 1}
+	runSteps(t, ratelimiter.TokenBucket{}, limit, []step{
+		{allowed: true, remaining: 0, reset: 20 * time.Second},
+		{allowed: false, remaining: 0, reset: 20 * time.Second, retryAfter: 20 * time.Second},
+	})
+}
+
+func TestGCRA(t *testing.T) {
+	runSteps(t, ratelimiter.GCRA{}, threePerMinute, []step{
+		{allowed: true, remaining: 2, reset: 20 * time.Second},
+		{allowed: true, remaining: 1, reset: 40 * time.Second},
+		{allowed: true, remaining: 0, reset: time.Minute},
+		{allowed: false, remaining: 0, reset: time.Minute, retryAfter: 20 * time.Second},
+		{advance: 10 * time.Second, allowed: false, remaining: 0, reset: 50 * time.Second, retryAfter: 10 * time.Second},
+		{advance: 10 * time.Second, allowed: true, remaining: 0, reset: time.Minute},
+		{advance: time.Hour, allowed: true, remaining: 2, reset: 20 * time.Second},
+	})
+}
+
+func TestSlidingWindowLog(t *testing.T) {
+	runSteps(t, ratelimiter.SlidingWindowLog{}, threePerMinute, []step{
+		{advance: 50 * time.Second, allowed: true, remaining: 2, reset: time.Minute},
+		{advance: 5 * time.Second, allowed: true, remaining: 1, reset: time.Minute},
+		{advance: 5 * time.Second, allowed: true, remaining: 0, reset: time.Minute},
+		// a new fixed window would start here, but the sliding window still
+		// contains all three requests
+		{advance: 5 * time.Second, allowed: false, remaining: 0, reset: 55 * time.Second, retryAfter: 45 * time.Second},
+		{advance: 45 * time.Second, allowed: true, remaining: 0, reset: time.Minute},
+		{advance: 2 * time.Minute, allowed: true, remaining: 2, reset: time.Minute},
+	})
+}
+
+func TestSlidingWindowCounter(t *testing.T) {
+	runSteps(t, ratelimiter.SlidingWindowCounter{}, threePerMinute, []step{
+		{advance: 45 * time.Second, allowed: true, remaining: 2, reset: 75 * time.Second},
+		{allowed: true, remaining: 1, reset: 75 * time.Second},
+		{allowed: true, remaining: 0, reset: 75 * time.Second},
+		// 15s into the next window the previous one is weighted with 3/4
+		{advance: 30 * time.Second, allowed: false, remaining: 0, reset: 45 * time.Second, retryAfter: 5 * time.Second},
+		// at 20s the previous window is weighted with 2/3
+		{advance: 5 * time.Second, allowed: true, remaining: 0, reset: 100 * time.Second},
+		{allowed: false, remaining: 0, reset: 100 * time.Second, retryAfter: 20 * time.Second},
+		// after two full windows the client is new again
+		{advance: 2 * time.Minute, allowed: true, remaining: 2, reset: 100 * time.Second},
+	})
+}
+
+func TestAlgorithmsRejectCorruptState(t *testing.T) {
+	for _, algo := range []ratelimiter.Algorithm{
+		ratelimiter.TokenBucket{},
+		ratelimiter.SlidingWindowLog{},
+		ratelimiter.SlidingWindowCounter{},
+		ratelimiter.GCRA{},
+	} {
+		if _, _, err := algo.Take([]byte("garbage"), time.Now(), threePerMinute); err == nil {
+			t.Errorf("%T: expected an error for corrupt state", algo)
+		}
+	}
+}
+
+func exceedGCRA(t *testing.T, mode ratelimiter.HeaderMode) *httptest.ResponseRecorder {
+	clock := newFakeClock()
+	policy := ratelimiter.Policy{Limit: threePerMinute, Algorithm: ratelimiter.AlgorithmGCRA}
+	limiter := ratelimiter.NewRateLimiter("test", policy, ratelimiter.NewMemoryStore())
+	limiter.SetClock(clock.Now)
+	limiter.SetHeaderMode(mode)
+	handler := limiter.Middleware(noop)
+
+	for range 3 {
+		serve(handler, "1.2.3.4:5678", nil)
+	}
+	clock.Advance(5 * time.Second)
+	w := serve(handler, "1.2.3.4:5678", nil)
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("unexpected status code %d", w.Code)
+	}
+	return w
+}
+
+func TestMiddlewareSetsStandardHeaders(t *testing.T) {
+	w := exceedGCRA(t, ratelimiter.HeadersStandard)
+
+	assert := assert.NewAssert(t)
+	assert.Equal(w.Header().Get("RateLimit-Policy"), `"test";q=3;w=60`, "unexpected RateLimit-Policy")
+	assert.Equal(w.Header().Get("RateLimit"), `"test";r=0;t=55`, "unexpected RateLimit")
+	assert.Equal(w.Header().Get("Retry-After"), "15", "unexpected Retry-After")
+	assert.Equal(w.Header().Get("X-RateLimit-Limit"), "", "unexpected legacy header")
+}
+
+func TestMiddlewareSetsLegacyHeaders(t *testing.T) {
+	w := exceedGCRA(t, ratelimiter.HeadersLegacy)
+
+	assert := assert.NewAssert(t)
+	assert.Equal(w.Header().Get("X-RateLimit-Limit"), "3", "unexpected X-RateLimit-Limit")
+	assert.Equal(w.Header().Get("X-RateLimit-Remaining"), "0", "unexpected X-RateLimit-Remaining")
+	assert.Equal(w.Header().Get("X-RateLimit-Reset"), "1704110460", "unexpected X-RateLimit-Reset")
+	assert.Equal(w.Header().Get("Retry-After"), "15", "unexpected Retry-After")
+	assert.Equal(w.Header().Get("RateLimit"), "", "unexpected standard header")
+}
+
+func TestMiddlewareSetsBothHeaders(t *testing.T) {
+	w := exceedGCRA(t, ratelimiter.HeadersBoth)
+
+	assert := assert.NewAssert(t)
+	assert.Equal(w.Header().Get("RateLimit"), `"test";r=0;t=55`, "unexpected RateLimit")
+	assert.Equal(w.Header().Get("X-RateLimit-Remaining"), "0", "unexpected X-RateLimit-Remaining")
+}
@@ -0,0 +1,220 @@
+package ratelimiter
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/makkes/shorty/clientip"
+)
+
+// Duration is a time.Duration that is encoded in JSON as a string such as
+// "1m30s".
+type Duration time.Duration
+
+// MarshalJSON implements json.Marshaler.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// A Limit allows Rate requests per Period on average and up to Burst requests
+// at once. Tokens are refilled continuously.
+type Limit struct {
+	Rate   int      `json:"rate"`
+	Period Duration `json:"period"`
+	Burst  int      `json:"burst,omitempty"`
+}
+
+func (l Limit) validate() error {
+	if l.Rate <= 0 {
+		return fmt.Errorf("rate must be positive but is %d", l.Rate)
+	}
+	if l.Period <= 0 {
+		return fmt.Errorf("period must be positive but is %s", time.Duration(l.Period))
+	}
+	if l.interval() == 0 {
+		return fmt.Errorf("period %s is too short for a rate of %d", time.Duration(l.Period), l.Rate)
+	}
+	if l.Burst < 0 {
+		return fmt.Errorf("burst must not be negative but is %d", l.Burst)
+	}
+	return nil
+}
+
+// burst returns the bucket capacity, defaulting to the rate.
+func (l Limit) burst() int {
+	if l.Burst == 0 {
+		return l.Rate
+	}
+	return l.Burst
+}
+
+// A Policy describes the limits applied to a route. Authenticated, if set,
+// replaces the limit for clients presenting a valid API key. Algorithm is the
+// name of the algorithm to use and defaults to AlgorithmTokenBucket.
+type Policy struct {
+	Limit
+	Algorithm     string `json:"algorithm,omitempty"`
+	Authenticated *Limit `json:"authenticated,omitempty"`
+}
+
+func (p Policy) algorithmName() string {
+	if p.Algorithm == "" {
+		return AlgorithmTokenBucket
+	}
+	return p.Algorithm
+}
+
+func (p Policy) algorithm() Algorithm {
+	return algorithms[p.algorithmName()]
+}
+
+func (p Policy) validate() error {
+	if p.algorithm() == nil {
+		return fmt.Errorf("unknown algorithm %q", p.Algorithm)
+	}
+	if err := p.Limit.validate(); err != nil {
+		return err
+	}
+	if p.Authenticated != nil {
+		if err := p.Authenticated.validate(); err != nil {
+			return fmt.Errorf("authenticated: %w", err)
+		}
+	}
+	return nil
+}
+
+// A HeaderMode selects the response header fields used to inform clients
+// about their quota.
+type HeaderMode string
+
+// These constants define all supported header modes.
+const (
+	// HeadersStandard emits the RateLimit and RateLimit-Policy fields as
+	// defined by the IETF draft "RateLimit header fields for HTTP".
+	HeadersStandard HeaderMode = "standard"
+	// HeadersLegacy emits the X-RateLimit-Limit, X-RateLimit-Remaining and
+	// X-RateLimit-Reset fields for compatibility with older clients.
+	HeadersLegacy HeaderMode = "legacy"
+	// HeadersBoth emits both standard and legacy fields.
+	HeadersBoth HeaderMode = "both"
+)
+
+func (m HeaderMode) standard() bool {
+	return m == "" || m == HeadersStandard || m == HeadersBoth
+}
+
+func (m HeaderMode) legacy() bool {
+	return m == HeadersLegacy || m == HeadersBoth
+}
+
+// Config is the declarative rate limiting configuration.
+type Config struct {
+	// Headers selects the response header fields, defaulting to
+	// HeadersStandard.
+	Headers HeaderMode `json:"headers,omitempty"`
+	// Exempt lists CIDRs or IPs of clients that are never limited, such as
+	// internal networks.
+	Exempt []string `json:"exempt,omitempty"`
+	// APIKeys maps the name of an API client to its key. Clients send their
+	// key in the X-API-Key header or as bearer token and are limited per
+	// name instead of per IP.
+	APIKeys map[string]string `json:"apiKeys,omitempty"`
+	// Policies maps route names to their policies. Routes without a policy
+	// are not limited.
+	Policies map[string]Policy `json:"policies"`
+}
+
+// DefaultConfig returns the configuration used when none is provided: only
+// shortening is limited to 5 requests per minute.
+func DefaultConfig() Config {
+	return Config{
+		Policies: map[string]Policy{
+			"shorten": {
+				Limit: Limit{
+					Rate:   5,
+					Period: Duration(time.Minute),
+				},
+			},
+		},
+	}
+}
+
+// LoadConfig reads a JSON configuration from the file at path.
+func LoadConfig(path string) (Config, error) {
+	var cfg Config
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, fmt.Errorf("failed reading rate limit config: %w", err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&cfg); err != nil {
+		return cfg, fmt.Errorf("failed parsing rate limit config %s: %w", path, err)
+	}
+	return cfg, cfg.Validate()
+}
+
+// Validate checks the configuration for errors.
+func (cfg Config) Validate() error {
+	var errs []error
+	switch cfg.Headers {
+	case "", HeadersStandard, HeadersLegacy, HeadersBoth:
+	default:
+		errs = append(errs, fmt.Errorf("unknown header mode %q", cfg.Headers))
+	}
+	if _, err := clientip.ParsePrefixes(strings.Join(cfg.Exempt, ",")); err != nil {
+		errs = append(errs, fmt.Errorf("exempt: %w", err))
+	}
+	for name, key := range cfg.APIKeys {
+		if key == "" {
+			errs = append(errs, fmt.Errorf("API key %q is empty", name))
+		}
+	}
+	for name, policy := range cfg.Policies {
+		if err := policy.validate(); err != nil {
+			errs = append(errs, fmt.Errorf("policy %q: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// NewRateLimiter returns a RateLimiter enforcing the policy of the named route
+// including exemptions and API keys. It returns nil if there is no policy for
+// the route.
+func (cfg Config) NewRateLimiter(route string, store Store) (*RateLimiter, error) {
+	policy, ok := cfg.Policies[route]
+	if !ok {
+		return nil, nil
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	rl := NewRateLimiter(route, policy, store)
+	rl.headers = cfg.Headers
+	rl.exempt, _ = clientip.ParsePrefixes(strings.Join(cfg.Exempt, ","))
+	rl.apiKeys = make(map[string]string, len(cfg.APIKeys))
+	for name, key := range cfg.APIKeys {
+		rl.apiKeys[key] = name
+	}
+	return rl, nil
+}
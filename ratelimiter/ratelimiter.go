@@ -1,91 +1,136 @@
 package ratelimiter
 
 import (
+	"crypto/subtle"
 	"fmt"
 	"math"
-	"net"
 	"net/http"
+	"net/netip"
 	"strings"
-	"sync"
 	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/makkes/shorty/clientip"
+	"github.com/makkes/shorty/logging"
+	"github.com/makkes/shorty/tracing"
 )
 
-// RateLimiter implements a token bucket algorithm for rate limiting.
+var tracer = otel.Tracer("github.com/makkes/shorty/ratelimiter")
+
+// RateLimiter limits the rate of requests per client using one of the
+// supported algorithms.
 type RateLimiter struct {
-	visitors sync.Map
-	rate     int64         // requests per window
-	window   time.Duration // time window
+	name      string
+	policy    Policy
+	algorithm Algorithm
+	store     Store
+	clock     func() time.Time
+	headers   HeaderMode
+	exempt    []netip.Prefix
+	apiKeys   map[string]string // API key -> client name
 }
 
 var _ fmt.Stringer = &RateLimiter{}
 
-type visitor struct {
-	sync.Mutex
+// NewRateLimiter creates a new rate limiter
+// name: the name of the limited route, used to separate state in the store
+// policy: the limits to apply; its algorithm must be valid
+// store: where to keep the state of each client
+func NewRateLimiter(name string, policy Policy, store Store) *RateLimiter {
+	return &RateLimiter{
+		name:      name,
+		policy:    policy,
+		algorithm: policy.algorithm(),
+		store:     store,
+		clock:     time.Now,
+	}
+}
 
-	tokens     int64
-	lastUpdate int64
+// SetClock replaces the clock used to determine the current time, e.g. for
+// testing.
+func (rl *RateLimiter) SetClock(clock func() time.Time) {
+	rl.clock = clock
 }
 
-var _ fmt.Stringer = &visitor{}
+// SetHeaderMode selects the response header fields used to inform clients
+// about their quota.
+func (rl *RateLimiter) SetHeaderMode(mode HeaderMode) {
+	rl.headers = mode
+}
 
 // String implements fmt.Stringer.
-func (v *visitor) String() string {
-	v.Lock()
-	defer v.Unlock()
-	return fmt.Sprintf("%d:%d", v.tokens, v.lastUpdate)
+func (rl *RateLimiter) String() string {
+	return fmt.Sprintf("%s (%s): %s", rl.name, rl.policy.algorithmName(), rl.store)
 }
 
-// NewRateLimiter creates a new rate limiter
-// rate: max requests per window
-// window: time window for rate limiting
-func NewRateLimiter(rate int, window time.Duration) *RateLimiter {
-	rl := &RateLimiter{
-		rate:   int64(rate),
-		window: window,
+// identify returns the key under which the client's state is stored, the name
+// of the applicable policy and its limit.
+func (rl *RateLimiter) identify(r *http.Request, ip string) (string, string, Limit) {
+	if key := apiKey(r); key != "" && rl.policy.Authenticated != nil {
+		for candidate, name := range rl.apiKeys {
+			if subtle.ConstantTimeCompare([]byte(candidate), []byte(key)) == 1 {
+				return rl.name + ":" + rl.policy.algorithmName() + ":key:" + name, rl.name + "-authenticated", *rl.policy.Authenticated
+			}
+		}
 	}
+	return rl.name + ":" + rl.policy.algorithmName() + ":ip:" + ip, rl.name, rl.policy.Limit
+}
 
-	// Cleanup old visitors every 5 minutes
-	go rl.cleanupVisitors()
-
-	return rl
+// apiKey returns the API key sent with r, if any.
+func apiKey(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	scheme, token, found := strings.Cut(r.Header.Get("Authorization"), " ")
+	if found && strings.EqualFold(scheme, "Bearer") {
+		return strings.TrimSpace(token)
+	}
+	return ""
 }
 
-// String implements fmt.Stringer.
-func (rl *RateLimiter) String() string {
-	visitors := make([]string, 0)
-	rl.visitors.Range(func(key, value any) bool {
-		visitors = append(visitors, fmt.Sprintf("%s: %s", key, value))
-		return true
-	})
-	return strings.Join(visitors, ", ")
+func (rl *RateLimiter) isExempt(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	return err == nil && clientip.Contains(rl.exempt, addr)
 }
 
-// Middleware returns an HTTP middleware that applies rate limiting.
+// Middleware returns an HTTP middleware that applies rate limiting. Clients are
+// identified by their API key or otherwise by the IP resolved by
+// clientip.Resolver's middleware, which must wrap this one; otherwise the
+// request's remote address is used.
 func (rl *RateLimiter) Middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Extract IP from request
-		var ip string
-		xff := r.Header["X-Forwarded-For"]
-		if len(xff) > 0 {
-			ip = xff[0]
-		} else {
-			if remoteIP, _, err := net.SplitHostPort(r.RemoteAddr); err != nil {
-				ip = r.RemoteAddr
-			} else {
-				ip = remoteIP
-			}
+		ip := clientip.FromRequest(r)
+		if rl.isExempt(ip) {
+			next.ServeHTTP(w, r)
+			return
 		}
 
-		allow, tokens, lastUpdate := rl.allow(ip)
-		resetTime := time.Unix(0, lastUpdate).Add(rl.window)
+		key, policyName, limit := rl.identify(r, ip)
+		now := rl.clock()
+		ctx, span := tracer.Start(r.Context(), "ratelimiter.take", trace.WithAttributes(
+			attribute.String("ratelimit.route", rl.name),
+			attribute.String("ratelimit.policy", policyName),
+			attribute.String("ratelimit.algorithm", rl.policy.algorithmName()),
+		))
+		d, err := rl.store.Take(ctx, key, rl.algorithm, now, limit)
+		if err == nil {
+			span.SetAttributes(attribute.Bool("ratelimit.allowed", d.Allowed), attribute.Int("ratelimit.remaining", d.Remaining))
+		}
+		tracing.End(span, err)
+		if err != nil {
+			// fail open so that an unavailable store doesn't take the whole service down
+			logging.FromContext(r.Context()).Error("failed checking rate limit", "key", key, "error", err)
+			next.ServeHTTP(w, r)
+			return
+		}
 
-		// Set rate limit headers
-		w.Header().Set("X-RateLimit-Limit", fmt.Sprintf("%d", rl.rate))
-		w.Header().Set("X-RateLimit-Remaining", fmt.Sprintf("%d", tokens))
-		w.Header().Set("X-RateLimit-Reset", fmt.Sprintf("%d", resetTime.Unix()))
+		rl.setHeaders(w.Header(), policyName, d, now)
 
-		if !allow {
-			retryAfter := int(math.Max(0, time.Until(resetTime).Seconds()))
+		if !d.Allowed {
+			retryAfter := seconds(d.RetryAfter)
 			w.Header().Set("Retry-After", fmt.Sprintf("%d", retryAfter))
 
 			http.Error(w, fmt.Sprintf("Rate limit exceeded; try again in %d seconds", retryAfter), http.StatusTooManyRequests)
@@ -96,62 +141,21 @@ func (rl *RateLimiter) Middleware(next http.Handler) http.Handler {
 	})
 }
 
-// getVisitor retrieves or creates a visitor entry.
-func (rl *RateLimiter) getVisitor(ip string) *visitor {
-	if v, exists := rl.visitors.Load(ip); exists {
-		return v.(*visitor)
+// setHeaders informs the client about its quota according to the header mode.
+func (rl *RateLimiter) setHeaders(h http.Header, policyName string, d Decision, now time.Time) {
+	if rl.headers.standard() {
+		// see https://datatracker.ietf.org/doc/draft-ietf-httpapi-ratelimit-headers/
+		h.Set("RateLimit-Policy", fmt.Sprintf("%q;q=%d;w=%d", policyName, d.Limit, seconds(d.Window)))
+		h.Set("RateLimit", fmt.Sprintf("%q;r=%d;t=%d", policyName, d.Remaining, seconds(d.Reset)))
 	}
-	v := &visitor{
-		tokens:     rl.rate,
-		lastUpdate: time.Now().UnixNano(),
+	if rl.headers.legacy() {
+		h.Set("X-RateLimit-Limit", fmt.Sprintf("%d", d.Limit))
+		h.Set("X-RateLimit-Remaining", fmt.Sprintf("%d", d.Remaining))
+		h.Set("X-RateLimit-Reset", fmt.Sprintf("%d", now.Add(d.Reset+time.Second-1).Unix()))
 	}
-
-	actual, _ := rl.visitors.LoadOrStore(ip, v)
-	return actual.(*visitor)
 }
 
-// allow checks if a request should be allowed.
-func (rl *RateLimiter) allow(ip string) (bool, int64, int64) {
-	now := time.Now().UnixNano()
-	v := rl.getVisitor(ip)
-
-	v.Lock()
-	defer v.Unlock()
-
-	for {
-		elapsed := time.Duration(now - v.lastUpdate)
-
-		if elapsed >= rl.window {
-			v.lastUpdate = now
-			v.tokens = rl.rate - 1
-			return true, v.tokens, v.lastUpdate
-		}
-
-		if v.tokens > 0 {
-			v.tokens = v.tokens - 1
-			return true, v.tokens, v.lastUpdate
-		}
-
-		return false, v.tokens, v.lastUpdate
-	}
-}
-
-// cleanupVisitors removes old visitor entries to prevent memory leaks.
-func (rl *RateLimiter) cleanupVisitors() {
-	ticker := time.NewTicker(5 * time.Minute)
-	defer ticker.Stop()
-
-	for range ticker.C {
-		cutoff := time.Now().Add(-rl.window * 2).UnixNano()
-
-		rl.visitors.Range(func(key, value any) bool {
-			v := value.(*visitor)
-			v.Lock()
-			if v.lastUpdate < cutoff {
-				rl.visitors.Delete(key)
-			}
-			v.Unlock()
-			return true
-		})
-	}
+// seconds rounds d up to full seconds.
+func seconds(d time.Duration) int64 {
+	return int64(math.Ceil(max(0, d.Seconds())))
 }
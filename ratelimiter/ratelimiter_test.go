@@ -0,0 +1,137 @@
+package ratelimiter_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/makkes/shorty/assert"
+	"github.com/makkes/shorty/ratelimiter"
+)
+
+func serve(handler http.Handler, remoteAddr string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = remoteAddr
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w
+}
+
+var noop = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+func TestMiddlewareLimitsRequests(t *testing.T) {
+	policy := ratelimiter.Policy{Limit: ratelimiter.Limit{Rate: 2, Period: ratelimiter.Duration(time.Minute)}}
+	handler := ratelimiter.NewRateLimiter("test", policy, ratelimiter.NewMemoryStore()).Middleware(noop)
+
+	assert := assert.NewAssert(t)
+	for idx, expected := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		w := serve(handler, "1.2.3.4:5678", nil)
+		assert.Equal(w.Code, expected, "unexpected status code")
+		if idx == 2 {
+			assert.Equal(w.Header().Get("Retry-After"), "30", "unexpected Retry-After header")
+		}
+	}
+
+	// other clients are not affected
+	w := serve(handler, "4.3.2.1:5678", nil)
+	assert.Equal(w.Code, http.StatusOK, "unexpected status code")
+}
+
+func TestMiddlewareRefillsSmoothly(t *testing.T) {
+	policy := ratelimiter.Policy{Limit: ratelimiter.Limit{Rate: 100, Period: ratelimiter.Duration(time.Second), Burst: 1}}
+	handler := ratelimiter.NewRateLimiter("test", policy, ratelimiter.NewMemoryStore()).Middleware(noop)
+
+	assert := assert.NewAssert(t)
+	assert.Equal(serve(handler, "1.2.3.4:5678", nil).Code, http.StatusOK, "unexpected status code")
+	assert.Equal(serve(handler, "1.2.3.4:5678", nil).Code, http.StatusTooManyRequests, "burst has not been enforced")
+	// a single token is refilled after 10ms
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(serve(handler, "1.2.3.4:5678", nil).Code, http.StatusOK, "token has not been refilled")
+}
+
+func TestConfigAppliesExemptionsAndAPIKeys(t *testing.T) {
+	cfg := ratelimiter.Config{
+		Exempt:  []string{"10.0.0.0/8"},
+		APIKeys: map[string]string{"reporting": "s3cr3t"},
+		Policies: map[string]ratelimiter.Policy{
+			"api": {
+				Limit:         ratelimiter.Limit{Rate: 1, Period: ratelimiter.Duration(time.Minute)},
+				Authenticated: &ratelimiter.Limit{Rate: 3, Period: ratelimiter.Duration(time.Minute)},
+			},
+		},
+	}
+	store := ratelimiter.NewMemoryStore()
+	limiter, err := cfg.NewRateLimiter("api", store)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	handler := limiter.Middleware(noop)
+
+	assert := assert.NewAssert(t)
+	for range 5 {
+		assert.Equal(serve(handler, "10.1.2.3:1234", nil).Code, http.StatusOK, "exempt client has been limited")
+	}
+
+	for _, expected := range []int{http.StatusOK, http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		w := serve(handler, "1.2.3.4:5678", map[string]string{"Authorization": "Bearer s3cr3t"})
+		assert.Equal(w.Code, expected, "unexpected status code for authenticated client")
+	}
+	// the authenticated client is limited by key, not by IP
+	assert.Equal(serve(handler, "9.9.9.9:5678", map[string]string{"X-API-Key": "s3cr3t"}).Code, http.StatusTooManyRequests, "unexpected status code for authenticated client")
+
+	// an invalid key is treated like an anonymous client
+	assert.Equal(serve(handler, "1.2.3.4:5678", map[string]string{"X-API-Key": "wrong"}).Code, http.StatusOK, "unexpected status code")
+	assert.Equal(serve(handler, "1.2.3.4:5678", nil).Code, http.StatusTooManyRequests, "unexpected status code")
+
+	limiter, err = cfg.NewRateLimiter("unknown", store)
+	assert.Nil(err, "unexpected error")
+	assert.Nil(limiter, "expected no rate limiter for a route without policy")
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "limits.json")
+	err := os.WriteFile(path, []byte(`{
+		"headers": "both",
+		"exempt": ["10.0.0.0/8"],
+		"policies": {
+			"shorten": {"rate": 10, "period": "1h", "burst": 2}
+		}
+	}`), 0o600)
+	if err != nil {
+		t.Fatalf("failed writing config: %v", err)
+	}
+
+	cfg, err := ratelimiter.LoadConfig(path)
+	assert := assert.NewAssert(t)
+	assert.Nil(err, "unexpected error")
+	assert.Equal(cfg.Headers, ratelimiter.HeadersBoth, "unexpected header mode")
+	assert.Equal(cfg.Policies["shorten"].Rate, 10, "unexpected rate")
+	assert.Equal(time.Duration(cfg.Policies["shorten"].Period), time.Hour, "unexpected period")
+	assert.Equal(cfg.Policies["shorten"].Burst, 2, "unexpected burst")
+}
+
+func TestLoadConfigRejectsInvalidPolicies(t *testing.T) {
+	for _, content := range []string{
+		`{"policies": {"shorten": {"rate": 0, "period": "1m"}}}`,
+		`{"policies": {"shorten": {"rate": 1, "period": "soon"}}}`,
+		`{"policies": {"shorten": {"rate": 1000000000, "period": "500ms"}}}`,
+		`{"policies": {"shorten": {"rate": 1, "period": "1m", "authenticated": {"rate": 1}}}}`,
+		`{"exempt": ["nope"], "policies": {}}`,
+		`{"headers": "fancy", "policies": {}}`,
+		`{"unknown": true}`,
+	} {
+		path := filepath.Join(t.TempDir(), "limits.json")
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatalf("failed writing config: %v", err)
+		}
+		if _, err := ratelimiter.LoadConfig(path); err == nil {
+			t.Errorf("expected an error loading %s", content)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package ratelimiter
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	redisDialTimeout = 2 * time.Second
+	redisIOTimeout   = 2 * time.Second
+	redisPoolSize    = 16
+)
+
+// The scripts below implement the algorithms on state kept in Redis. Redis
+// runs each script atomically, so there is no need for optimistic locking.
+// Points in time and durations are passed and returned in microseconds so
+// that Lua's numbers represent points in time exactly. Each script takes the current time
+// and the TTL of the state in milliseconds as first arguments and returns
+// whether the request is allowed, the remaining requests, the time until the
+// quota is restored and the time until the next request is allowed.
+var (
+	// ARGV[3]: burst, ARGV[4]: interval
+	tokenBucketScript = redis.NewScript(`
+local now, burst, interval = tonumber(ARGV[1]), tonumber(ARGV[3]), tonumber(ARGV[4])
+local tokens = burst
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'at')
+if state[1] then
+	tokens = math.min(burst, tonumber(state[1]) + math.max(0, now - tonumber(state[2])) / interval)
+end
+local allowed, retry = 0, 0
+if tokens >= 1 then
+	allowed, tokens = 1, tokens - 1
+else
+	retry = math.ceil((1 - tokens) * interval)
+end
+redis.call('HSET', KEYS[1], 'tokens', string.format('%.17g', tokens), 'at', ARGV[1])
+redis.call('PEXPIRE', KEYS[1], ARGV[2])
+return {allowed, math.floor(tokens), math.floor((burst - tokens) * interval), retry}
+`)
+
+	// ARGV[3]: interval in whole microseconds, as the theoretical arrival
+	// time would otherwise lose precision, ARGV[4]: burst
+	gcraScript = redis.NewScript(`
+local now, interval = tonumber(ARGV[1]), tonumber(ARGV[3])
+local tolerance = tonumber(ARGV[4]) * interval
+local tat = math.max(now, tonumber(redis.call('GET', KEYS[1]) or now))
+local allowAt = tat + interval - tolerance
+local allowed, retry = 0, 0
+if now >= allowAt then
+	allowed, tat = 1, tat + interval
+else
+	retry = math.ceil(allowAt - now)
+end
+redis.call('SET', KEYS[1], string.format('%d', tat), 'PX', ARGV[2])
+return {allowed, math.max(0, math.floor((tolerance - (tat - now)) / interval)), math.floor(tat - now), retry}
+`)
+
+	// ARGV[3]: rate, ARGV[4]: period, ARGV[5]: unique ID of the request
+	slidingWindowLogScript = redis.NewScript(`
+local now, rate, period = tonumber(ARGV[1]), tonumber(ARGV[3]), tonumber(ARGV[4])
+local cutoff = now - period
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', cutoff)
+local count = redis.call('ZCARD', KEYS[1])
+local allowed, retry = 0, 0
+if count < rate then
+	allowed, count = 1, count + 1
+	redis.call('ZADD', KEYS[1], ARGV[1], ARGV[5])
+else
+	-- the oldest request within the window has to leave it first
+	local oldest = redis.call('ZRANGE', KEYS[1], count - rate, count - rate, 'WITHSCORES')
+	retry = math.ceil(tonumber(oldest[2]) - cutoff)
+end
+local reset = 0
+if count > 0 then
+	local newest = redis.call('ZRANGE', KEYS[1], -1, -1, 'WITHSCORES')
+	reset = math.floor(tonumber(newest[2]) - cutoff)
+end
+redis.call('PEXPIRE', KEYS[1], ARGV[2])
+return {allowed, math.max(0, rate - count), reset, retry}
+`)
+
+	// ARGV[3]: rate, ARGV[4]: period
+	slidingWindowCounterScript = redis.NewScript(`
+local now, rate, period = tonumber(ARGV[1]), tonumber(ARGV[3]), tonumber(ARGV[4])
+local start = now - now % period
+local prev, curr = 0, 0
+local state = redis.call('HMGET', KEYS[1], 'start', 'prev', 'curr')
+if state[1] then
+	local stored = tonumber(state[1])
+	if stored == start then
+		prev, curr = tonumber(state[2]), tonumber(state[3])
+	elseif stored == start - period then
+		prev = tonumber(state[3])
+	end
+end
+local elapsed = now - start
+local function estimate()
+	return prev * (period - elapsed) / period + curr
+end
+-- see retryAfterSlidingWindow
+local function decayedAt(count, budget)
+	return period - math.floor(period / count) * budget - math.floor((period % count) * budget / count)
+end
+local allowed, retry = 0, 0
+if estimate() + 1 <= rate then
+	allowed, curr = 1, curr + 1
+elseif curr + 1 <= rate and prev > 0 then
+	retry = math.max(0, decayedAt(prev, rate - 1 - curr) - elapsed)
+else
+	retry = period - elapsed + decayedAt(curr, rate - 1)
+end
+local reset = 0
+if curr > 0 then
+	reset = 2 * period - elapsed
+elseif prev > 0 then
+	reset = period - elapsed
+end
+redis.call('HSET', KEYS[1], 'start', string.format('%.17g', start), 'prev', prev, 'curr', curr)
+redis.call('PEXPIRE', KEYS[1], ARGV[2])
+return {allowed, math.max(0, math.floor(rate - estimate())), math.floor(reset), math.ceil(retry)}
+`)
+)
+
+// A RedisStore keeps rate limiter state in Redis (or any server speaking the
+// Redis protocol and supporting Lua scripts) so that limits are enforced
+// across all instances sharing the same server. Each request is accounted for
+// by a single script, which Redis runs atomically.
+type RedisStore struct {
+	client *redis.Client
+	prefix string
+}
+
+var _ Store = &RedisStore{}
+
+// NewRedisStore returns a RedisStore that connects to the server at addr,
+// authenticating with password unless it is empty. Connections are
+// established lazily.
+func NewRedisStore(addr, password string) *RedisStore {
+	return &RedisStore{
+		client: redis.NewClient(&redis.Options{
+			Addr:         addr,
+			Password:     password,
+			DialTimeout:  redisDialTimeout,
+			ReadTimeout:  redisIOTimeout,
+			WriteTimeout: redisIOTimeout,
+			PoolSize:     redisPoolSize,
+		}),
+		prefix: "shorty:ratelimit:",
+	}
+}
+
+// micros formats d in microseconds.
+func micros(d time.Duration) string {
+	return strconv.FormatFloat(float64(d)/float64(time.Microsecond), 'f', -1, 64)
+}
+
+// Take implements Store.
+func (rs *RedisStore) Take(ctx context.Context, key string, algorithm Algorithm, now time.Time, limit Limit) (Decision, error) {
+	var script *redis.Script
+	args := []any{now.UnixMicro(), max(algorithm.TTL(limit).Milliseconds(), 1)}
+	d := Decision{Limit: limit.Rate, Window: time.Duration(limit.Period)}
+	switch algorithm.(type) {
+	case TokenBucket:
+		script = tokenBucketScript
+		args = append(args, limit.burst(), micros(limit.interval()))
+		d.Limit, d.Window = limit.burst(), time.Duration(limit.burst())*limit.interval()
+	case GCRA:
+		script = gcraScript
+		args = append(args, max(limit.interval().Microseconds(), 1), limit.burst())
+		d.Limit, d.Window = limit.burst(), time.Duration(limit.burst())*limit.interval()
+	case SlidingWindowLog:
+		script = slidingWindowLogScript
+		args = append(args, limit.Rate, micros(time.Duration(limit.Period)), strconv.FormatUint(rand.Uint64(), 36))
+	case SlidingWindowCounter:
+		script = slidingWindowCounterScript
+		args = append(args, limit.Rate, micros(time.Duration(limit.Period)))
+	default:
+		return d, fmt.Errorf("algorithm %T is not supported by the Redis store", algorithm)
+	}
+
+	res, err := script.Run(ctx, rs.client, []string{rs.prefix + key}, args...).Int64Slice()
+	if err != nil {
+		return d, fmt.Errorf("failed taking request from Redis: %w", err)
+	}
+	if len(res) != 4 {
+		return d, fmt.Errorf("unexpected reply %v from Redis", res)
+	}
+	d.Allowed = res[0] == 1
+	d.Remaining = int(res[1])
+	d.Reset = time.Duration(res[2]) * time.Microsecond
+	d.RetryAfter = time.Duration(res[3]) * time.Microsecond
+	return d, nil
+}
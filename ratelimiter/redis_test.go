@@ -0,0 +1,125 @@
+package ratelimiter
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+var allAlgorithms = []Algorithm{TokenBucket{}, GCRA{}, SlidingWindowLog{}, SlidingWindowCounter{}}
+
+// closeTo tells whether a and b differ by no more than the rounding to
+// microseconds done by the Redis store allows.
+func closeTo(a, b time.Duration) bool {
+	return (a - b).Abs() < 10*time.Microsecond
+}
+
+func TestRedisStoreMatchesAlgorithms(t *testing.T) {
+	limits := []Limit{
+		{Rate: 3, Period: Duration(time.Minute)},
+		{Rate: 7, Period: Duration(10 * time.Second), Burst: 2},
+	}
+	advances := []time.Duration{0, 0, 0, 0, 0, 5 * time.Second, time.Second, 0, 20 * time.Second, 0, 0, 0, 0,
+		1500 * time.Millisecond, 0, 61 * time.Second, 0, 0, 0, 0, 333 * time.Millisecond, 0, 0}
+
+	for _, algorithm := range allAlgorithms {
+		for _, limit := range limits {
+			mr := miniredis.RunT(t)
+			rs := NewRedisStore(mr.Addr(), "")
+			ms := NewMemoryStore()
+			now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+			for idx, advance := range advances {
+				now = now.Add(advance)
+				expected, err := ms.Take(context.Background(), "k", algorithm, now, limit)
+				if err != nil {
+					t.Fatalf("%T %v step %d: unexpected error: %v", algorithm, limit, idx, err)
+				}
+				actual, err := rs.Take(context.Background(), "k", algorithm, now, limit)
+				if err != nil {
+					t.Fatalf("%T %v step %d: unexpected error: %v", algorithm, limit, idx, err)
+				}
+				if actual.Allowed != expected.Allowed || actual.Remaining != expected.Remaining ||
+					actual.Limit != expected.Limit || actual.Window != expected.Window ||
+					!closeTo(actual.Reset, expected.Reset) || !closeTo(actual.RetryAfter, expected.RetryAfter) {
+					t.Errorf("%T %v step %d: expected %+v but got %+v", algorithm, limit, idx, expected, actual)
+				}
+			}
+		}
+	}
+}
+
+func testStoreIsAtomic(t *testing.T, stores ...Store) {
+	limit := Limit{Rate: 10, Period: Duration(time.Hour)}
+	now := time.Now()
+	for _, algorithm := range allAlgorithms {
+		var wg sync.WaitGroup
+		var allowed atomic.Int64
+		for idx := range 50 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				d, err := stores[idx%len(stores)].Take(context.Background(), fmt.Sprintf("%T", algorithm), algorithm, now, limit)
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				if d.Allowed {
+					allowed.Add(1)
+				}
+			}()
+		}
+		wg.Wait()
+		if allowed.Load() != 10 {
+			t.Errorf("%T: expected 10 requests to be allowed but got %d", algorithm, allowed.Load())
+		}
+	}
+}
+
+func TestMemoryStoreIsAtomic(t *testing.T) {
+	testStoreIsAtomic(t, NewMemoryStore())
+}
+
+func TestRedisStoreIsAtomicAcrossInstances(t *testing.T) {
+	mr := miniredis.RunT(t)
+	testStoreIsAtomic(t, NewRedisStore(mr.Addr(), ""), NewRedisStore(mr.Addr(), ""))
+}
+
+func TestRedisStoreAuthenticates(t *testing.T) {
+	mr := miniredis.RunT(t)
+	mr.RequireAuth("s3cr3t")
+	limit := Limit{Rate: 1, Period: Duration(time.Minute)}
+
+	if _, err := NewRedisStore(mr.Addr(), "wrong").Take(context.Background(), "k", TokenBucket{}, time.Now(), limit); err == nil {
+		t.Fatalf("expected an error using a wrong password")
+	}
+	if _, err := NewRedisStore(mr.Addr(), "s3cr3t").Take(context.Background(), "k", TokenBucket{}, time.Now(), limit); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestRedisStoreExpiresState(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rs := NewRedisStore(mr.Addr(), "")
+	limit := Limit{Rate: 1, Period: Duration(time.Minute)}
+	now := time.Now()
+
+	for _, algorithm := range allAlgorithms {
+		for _, expected := range []bool{true, false} {
+			d, err := rs.Take(context.Background(), fmt.Sprintf("%T", algorithm), algorithm, now, limit)
+			if err != nil {
+				t.Fatalf("%T: unexpected error: %v", algorithm, err)
+			}
+			if d.Allowed != expected {
+				t.Fatalf("%T: expected allowed=%t but got %t", algorithm, expected, d.Allowed)
+			}
+		}
+		mr.FastForward(algorithm.TTL(limit) + time.Millisecond)
+		if mr.Exists(rs.prefix + fmt.Sprintf("%T", algorithm)) {
+			t.Errorf("%T: state has not expired", algorithm)
+		}
+	}
+}
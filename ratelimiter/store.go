@@ -0,0 +1,138 @@
+package ratelimiter
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A Store persists the per-client state of a RateLimiter. Implementations
+// must guarantee that Take is atomic with respect to all other calls for the
+// same key, across all processes sharing the store.
+type Store interface {
+	// Take accounts for a request at time now of the client whose state is
+	// stored under key, using algorithm. The state expires after
+	// algorithm.TTL(limit), after which the client is treated as new.
+	Take(ctx context.Context, key string, algorithm Algorithm, now time.Time, limit Limit) (Decision, error)
+}
+
+// A MemoryStore keeps rate limiter state in process-local memory. It is
+// suitable for single-instance deployments only. Entries expire according to
+// the clock of the rate limiters using the store, i.e. the points in time
+// passed to Take.
+type MemoryStore struct {
+	entries sync.Map
+	// latest is the latest point in time passed to Take, in nanoseconds
+	latest atomic.Int64
+}
+
+var _ Store = &MemoryStore{}
+
+type memoryEntry struct {
+	sync.Mutex
+
+	state   []byte
+	expires int64
+	deleted bool
+}
+
+// NewMemoryStore creates a new MemoryStore that removes expired entries every
+// 5 minutes.
+func NewMemoryStore() *MemoryStore {
+	ms := &MemoryStore{}
+
+	go ms.cleanup(5 * time.Minute)
+
+	return ms
+}
+
+// Take implements Store.
+func (ms *MemoryStore) Take(ctx context.Context, key string, algorithm Algorithm, now time.Time, limit Limit) (Decision, error) {
+	for {
+		value, _ := ms.entries.LoadOrStore(key, &memoryEntry{})
+		entry := value.(*memoryEntry)
+
+		entry.Lock()
+		if entry.deleted {
+			// the entry has been removed by cleanup after we loaded it
+			entry.Unlock()
+			continue
+		}
+
+		ms.observe(now)
+		state := entry.state
+		if entry.expires < now.UnixNano() {
+			state = nil
+		}
+		newState, d, err := algorithm.Take(state, now, limit)
+		if err == nil {
+			entry.state = newState
+			entry.expires = now.UnixNano() + algorithm.TTL(limit).Nanoseconds()
+		}
+		entry.Unlock()
+		return d, err
+	}
+}
+
+// observe records now as the current time if it is later than all points in
+// time seen before.
+func (ms *MemoryStore) observe(now time.Time) {
+	for {
+		latest := ms.latest.Load()
+		if now.UnixNano() <= latest || ms.latest.CompareAndSwap(latest, now.UnixNano()) {
+			return
+		}
+	}
+}
+
+// rangeStates calls fn for each key and its unexpired state.
+func (ms *MemoryStore) rangeStates(fn func(key string, state []byte)) {
+	now := ms.latest.Load()
+	ms.entries.Range(func(key, value any) bool {
+		entry := value.(*memoryEntry)
+		entry.Lock()
+		if !entry.deleted && entry.expires >= now {
+			fn(key.(string), entry.state)
+		}
+		entry.Unlock()
+		return true
+	})
+}
+
+// String implements fmt.Stringer.
+func (ms *MemoryStore) String() string {
+	entries := make([]string, 0)
+	ms.rangeStates(func(key string, state []byte) {
+		entries = append(entries, fmt.Sprintf("%s: %x", key, state))
+	})
+	return strings.Join(entries, ", ")
+}
+
+// cleanup removes expired entries to prevent memory leaks.
+func (ms *MemoryStore) cleanup(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		ms.removeExpired()
+	}
+}
+
+// removeExpired removes all entries that have expired by the latest point in
+// time passed to Take.
+func (ms *MemoryStore) removeExpired() {
+	now := ms.latest.Load()
+	ms.entries.Range(func(key, value any) bool {
+		entry := value.(*memoryEntry)
+		entry.Lock()
+		if entry.expires < now {
+			entry.deleted = true
+			ms.entries.Delete(key)
+		}
+		entry.Unlock()
+		return true
+	})
+}
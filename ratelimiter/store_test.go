@@ -0,0 +1,40 @@
+package ratelimiter
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryStoreExpiresByLimiterClock(t *testing.T) {
+	ms := NewMemoryStore()
+	limit := Limit{Rate: 1, Period: Duration(time.Minute)}
+	// far from the real time so that expiry can't be based on it
+	now := time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	take := func(key string, expected bool) {
+		t.Helper()
+		d, err := ms.Take(context.Background(), key, TokenBucket{}, now, limit)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if d.Allowed != expected {
+			t.Fatalf("expected allowed=%t for %s but got %t", expected, key, d.Allowed)
+		}
+	}
+	take("a", true)
+	take("a", false)
+	if ms.String() == "" {
+		t.Fatalf("state is missing")
+	}
+
+	now = now.Add(time.Minute + time.Nanosecond)
+	take("b", true)
+	ms.removeExpired()
+	if _, ok := ms.entries.Load("a"); ok {
+		t.Errorf("expired state has not been removed")
+	}
+	if _, ok := ms.entries.Load("b"); !ok {
+		t.Errorf("unexpired state has been removed")
+	}
+}
@@ -0,0 +1,189 @@
+// Package secheaders sets security-related header fields on HTTP responses.
+package secheaders
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Headers maps names of header fields to their values. An empty value
+// removes the field.
+type Headers map[string]string
+
+// Config describes the header fields of all responses.
+type Config struct {
+	// Headers are set on all responses before they are handled, so handlers
+	// may replace them.
+	Headers Headers `json:"headers,omitempty"`
+	// Routes maps route names to header fields replacing those of the
+	// route's responses, including fields set by handlers.
+	Routes map[string]Headers `json:"routes,omitempty"`
+}
+
+// DefaultConfig returns headers suitable for an API that isn't meant to be
+// framed or rendered as a document. Strict-Transport-Security is only
+// included if hsts is true, i.e. if Shorty is served via HTTPS. Redirects
+// don't set a Referrer-Policy so that the destination sees the referrer as
+// permitted by the page linking to the short URL.
+func DefaultConfig(hsts bool) Config {
+	cfg := Config{
+		Headers: Headers{
+			"Content-Security-Policy": "default-src 'none'; frame-ancestors 'none'; base-uri 'none'; form-action 'none'",
+			"X-Content-Type-Options":  "nosniff",
+			"Referrer-Policy":         "no-referrer",
+			"X-Frame-Options":         "DENY",
+		},
+		Routes: map[string]Headers{
+			"unshorten": {"Referrer-Policy": ""},
+		},
+	}
+	if hsts {
+		cfg.Headers["Strict-Transport-Security"] = "max-age=31536000"
+	}
+	return cfg
+}
+
+// LoadConfig reads the configuration at path and merges it into base, field
+// by field.
+func LoadConfig(path string, base Config) (Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return base, fmt.Errorf("failed reading security headers config: %w", err)
+	}
+	var cfg Config
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&cfg); err != nil {
+		return base, fmt.Errorf("failed parsing security headers config %s: %w", path, err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return base, err
+	}
+	return base.Merge(cfg), nil
+}
+
+// Merge returns a copy of cfg with the fields of other added or replaced.
+func (cfg Config) Merge(other Config) Config {
+	res := Config{Headers: merge(cfg.Headers, other.Headers), Routes: make(map[string]Headers)}
+	for route, headers := range cfg.Routes {
+		res.Routes[route] = merge(headers, nil)
+	}
+	for route, headers := range other.Routes {
+		res.Routes[route] = merge(res.Routes[route], headers)
+	}
+	return res
+}
+
+func merge(a, b Headers) Headers {
+	res := make(Headers, len(a)+len(b))
+	for name, value := range a {
+		res[http.CanonicalHeaderKey(name)] = value
+	}
+	for name, value := range b {
+		res[http.CanonicalHeaderKey(name)] = value
+	}
+	return res
+}
+
+// validName tells whether name is a valid header field name as defined by
+// RFC 9110.
+func validName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if c > '~' || c <= ' ' || strings.ContainsRune(`"(),/:;<=>?@[\]{}`, c) {
+			return false
+		}
+	}
+	return true
+}
+
+// Validate checks the configuration for errors.
+func (cfg Config) Validate() error {
+	var errs []error
+	check := func(prefix string, headers Headers) {
+		for name, value := range headers {
+			if !validName(name) {
+				errs = append(errs, fmt.Errorf("%sinvalid header field name %q", prefix, name))
+			}
+			if strings.ContainsAny(value, "\r\n") {
+				errs = append(errs, fmt.Errorf("%sheader field %q: value contains a line break", prefix, name))
+			}
+		}
+	}
+	check("", cfg.Headers)
+	for route, headers := range cfg.Routes {
+		check(fmt.Sprintf("route %q: ", route), headers)
+	}
+	return errors.Join(errs...)
+}
+
+// set applies headers to h.
+func set(h http.Header, headers Headers) {
+	for name, value := range headers {
+		if value == "" {
+			h.Del(name)
+		} else {
+			h.Set(name, value)
+		}
+	}
+}
+
+// Middleware sets the fields of cfg.Headers on all responses.
+func (cfg Config) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		set(w.Header(), cfg.Headers)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// overridingWriter applies headers right before the response header is
+// written.
+type overridingWriter struct {
+	http.ResponseWriter
+	headers Headers
+	written bool
+}
+
+func (w *overridingWriter) WriteHeader(status int) {
+	if !w.written {
+		w.written = true
+		set(w.Header(), w.headers)
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *overridingWriter) Write(b []byte) (int, error) {
+	if !w.written {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap makes http.ResponseController work with the original writer.
+func (w *overridingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Route applies the fields configured for the named route to the responses
+// of next. It returns next if there are none.
+func (cfg Config) Route(route string, next http.Handler) http.Handler {
+	headers := cfg.Routes[route]
+	if len(headers) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ow := &overridingWriter{ResponseWriter: w, headers: headers}
+		next.ServeHTTP(ow, r)
+		if !ow.written {
+			// the handler didn't write anything, causing an empty 200 response
+			ow.WriteHeader(http.StatusOK)
+		}
+	})
+}
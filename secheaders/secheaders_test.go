@@ -0,0 +1,89 @@
+package secheaders_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/makkes/shorty/assert"
+	"github.com/makkes/shorty/secheaders"
+)
+
+func TestDefaultConfig(t *testing.T) {
+	assert := assert.NewAssert(t)
+	_, ok := secheaders.DefaultConfig(false).Headers["Strict-Transport-Security"]
+	assert.Equal(ok, false, "HSTS must not be sent via plain HTTP")
+	assert.Equal(secheaders.DefaultConfig(true).Headers["Strict-Transport-Security"], "max-age=31536000", "unexpected HSTS header")
+}
+
+func TestHeaders(t *testing.T) {
+	cfg := secheaders.Config{
+		Headers: secheaders.Headers{"X-Frame-Options": "DENY", "Referrer-Policy": "no-referrer", "Content-Security-Policy": "default-src 'none'"},
+		Routes: map[string]secheaders.Headers{
+			"redirect": {"Referrer-Policy": "", "Content-Security-Policy": "sandbox"},
+		},
+	}
+	// the handler replaces a default header
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Security-Policy", "default-src 'self'")
+		w.WriteHeader(http.StatusTeapot)
+	})
+
+	for _, tc := range []struct {
+		route    string
+		expected map[string]string
+	}{
+		{"other", map[string]string{"X-Frame-Options": "DENY", "Referrer-Policy": "no-referrer", "Content-Security-Policy": "default-src 'self'"}},
+		{"redirect", map[string]string{"X-Frame-Options": "DENY", "Referrer-Policy": "", "Content-Security-Policy": "sandbox"}},
+	} {
+		t.Run(tc.route, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			cfg.Middleware(cfg.Route(tc.route, handler)).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+			assert := assert.NewAssert(t)
+			assert.Equal(rr.Code, http.StatusTeapot, "unexpected status code")
+			for name, value := range tc.expected {
+				assert.Equal(rr.Header().Get(name), value, "unexpected "+name)
+			}
+		})
+	}
+
+	// overrides are applied to responses without body, too
+	rr := httptest.NewRecorder()
+	cfg.Route("redirect", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert := assert.NewAssert(t)
+	assert.Equal(rr.Header().Get("Content-Security-Policy"), "sandbox", "override has not been applied")
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	write := func(content string) string {
+		path := filepath.Join(dir, "headers.json")
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	assert := assert.NewAssert(t)
+	cfg, err := secheaders.LoadConfig(write(`{
+		"headers": {"strict-transport-security": "max-age=63072000; includeSubDomains", "X-Frame-Options": ""},
+		"routes": {"unshorten": {"Referrer-Policy": "unsafe-url"}, "ui": {"Permissions-Policy": "camera=()"}}
+	}`), secheaders.DefaultConfig(true))
+	assert.Nil(err, "unexpected error")
+	assert.Equal(cfg.Headers["Strict-Transport-Security"], "max-age=63072000; includeSubDomains", "header has not been replaced")
+	assert.Equal(cfg.Headers["X-Frame-Options"], "", "header has not been removed")
+	assert.Equal(cfg.Headers["X-Content-Type-Options"], "nosniff", "default header is missing")
+	assert.Equal(cfg.Routes["unshorten"]["Referrer-Policy"], "unsafe-url", "route header has not been replaced")
+	assert.Equal(cfg.Routes["ui"]["Permissions-Policy"], "camera=()", "route header has not been added")
+
+	for _, doc := range []string{
+		`{"header": {}}`,
+		`{"headers": {"X Frame": "DENY"}}`,
+		`{"routes": {"ui": {"X-Test": "a\nb"}}}`,
+	} {
+		_, err := secheaders.LoadConfig(write(doc), secheaders.DefaultConfig(false))
+		assert.NotNil(err, "expected error for "+doc)
+	}
+}
@@ -0,0 +1,283 @@
+package sqldb
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	dbpkg "github.com/makkes/shorty/db"
+	"github.com/makkes/shorty/hll"
+)
+
+// RecordClick queues click for being stored. It fails if the queue is full.
+func (db SQLDB) RecordClick(ctx context.Context, click dbpkg.Click) error {
+	select {
+	case db.clicks <- click:
+		return nil
+	default:
+		return errors.New("click queue is full, dropping click")
+	}
+}
+
+// GetClickStats rolls up the clicks stored for key on the fly.
+func (db SQLDB) GetClickStats(ctx context.Context, key []byte, from, to time.Time, granularity dbpkg.Granularity) ([]dbpkg.ClickStats, error) {
+	res := make([]dbpkg.ClickStats, 0)
+	rows, err := db.query(ctx, `SELECT time, referrer, browser, os, device, country, bot FROM clicks
+		WHERE key = ? AND time >= ? AND time < ? ORDER BY time, id`,
+		string(key), formatTime(granularity.Truncate(from)), formatTime(to))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t scanTime
+		click := dbpkg.Click{Key: key}
+		if err := rows.Scan(&t, &click.Referrer, &click.Browser, &click.OS, &click.Device, &click.Country, &click.Bot); err != nil {
+			return nil, err
+		}
+		start := granularity.Truncate(t.Time)
+		if len(res) == 0 || !res[len(res)-1].Start.Equal(start) {
+			res = append(res, dbpkg.NewClickStats(start))
+		}
+		res[len(res)-1].Add(click)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if granularity != dbpkg.GranularityDay {
+		return res, nil
+	}
+	for idx := range res {
+		sketch, err := db.visitorSketch(ctx, db.db, key, res[idx].Start, "")
+		if err != nil {
+			return nil, err
+		}
+		res[idx].UniqueVisitors = sketch.Count()
+	}
+	return res, nil
+}
+
+func (db SQLDB) GetUniqueVisitors(ctx context.Context, key []byte, from, to time.Time) (uint64, error) {
+	merged := hll.New()
+	rows, err := db.query(ctx, `SELECT sketch FROM visitors WHERE key = ? AND day >= ? AND day < ?`,
+		string(key), formatTime(dbpkg.GranularityDay.Truncate(from)), formatTime(to))
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return 0, err
+		}
+		var sketch hll.Sketch
+		if err := sketch.UnmarshalBinary(data); err != nil {
+			return 0, fmt.Errorf("Error decoding visitors of %q: %w", key, err)
+		}
+		merged.Merge(&sketch)
+	}
+	return merged.Count(), rows.Err()
+}
+
+// VisitorSalt returns the salt of the day starting at day, creating it if
+// needed and deleting the salts of earlier days. Instances creating the salt
+// at the same time agree on the one inserted first.
+func (db SQLDB) VisitorSalt(ctx context.Context, day time.Time) ([]byte, error) {
+	salt := make([]byte, 32)
+	// rand.Read never returns an error
+	_, _ = rand.Read(salt)
+	if _, err := db.exec(ctx, `INSERT INTO visitor_salts (day, salt) VALUES (?, ?) ON CONFLICT (day) DO NOTHING`, formatTime(day), salt); err != nil {
+		return nil, err
+	}
+	if _, err := db.exec(ctx, `DELETE FROM visitor_salts WHERE day < ?`, formatTime(day)); err != nil {
+		return nil, err
+	}
+	err := db.db.QueryRowContext(ctx, db.dialect.rebind(`SELECT salt FROM visitor_salts WHERE day = ?`), formatTime(day)).Scan(&salt)
+	return salt, err
+}
+
+// ForEachClick iterates over the clicks ordered by key and time.
+func (db SQLDB) ForEachClick(ctx context.Context, from, to time.Time, fn func(dbpkg.Click) error) error {
+	rows, err := db.query(ctx, `SELECT key, time, referrer, browser, os, device, country, bot FROM clicks
+		WHERE time >= ? AND time < ? ORDER BY key, time, id`, formatTime(from), formatTime(to))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key string
+		var t scanTime
+		var click dbpkg.Click
+		if err := rows.Scan(&key, &t, &click.Referrer, &click.Browser, &click.OS, &click.Device, &click.Country, &click.Bot); err != nil {
+			return err
+		}
+		click.Key, click.Time = []byte(key), t.Time
+		if err := fn(click); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// querier is implemented by *sql.DB and *sql.Tx.
+type querier interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// visitorSketch returns the visitors of key on the given day. suffix is
+// appended to the query.
+func (db SQLDB) visitorSketch(ctx context.Context, q querier, key []byte, day time.Time, suffix string) (*hll.Sketch, error) {
+	sketch := hll.New()
+	var data []byte
+	err := q.QueryRowContext(ctx, db.dialect.rebind(`SELECT sketch FROM visitors WHERE key = ? AND day = ?`+suffix), string(key), formatTime(day)).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return sketch, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := sketch.UnmarshalBinary(data); err != nil {
+		return nil, fmt.Errorf("Error decoding visitors of %q: %w", key, err)
+	}
+	return sketch, nil
+}
+
+// storeClick stores click and updates the click counter and visitors of its
+// key. Clicks by bots aren't added to the counter, just like they aren't
+// counted as clicks in the stats.
+func (db SQLDB) storeClick(ctx context.Context, tx *sql.Tx, click dbpkg.Click) error {
+	key := string(click.Key)
+	if click.Bot == "" {
+		if _, err := tx.ExecContext(ctx, db.dialect.rebind(`UPDATE links SET clicks = clicks + 1 WHERE key = ?`), key); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, db.dialect.rebind(`INSERT INTO clicks (key, time, referrer, browser, os, device, country, bot)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`),
+		key, formatTime(click.Time), click.Referrer, click.Browser, click.OS, click.Device, click.Country, click.Bot); err != nil {
+		return err
+	}
+	if click.Visitor == 0 {
+		return nil
+	}
+	// create the row first so that it can be locked while merging the
+	// sketches of concurrent writers
+	day := dbpkg.GranularityDay.Truncate(click.Time)
+	empty, err := hll.New().MarshalBinary()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, db.dialect.rebind(`INSERT INTO visitors (key, day, sketch) VALUES (?, ?, ?)
+		ON CONFLICT (key, day) DO NOTHING`), key, formatTime(day), empty); err != nil {
+		return err
+	}
+	sketch, err := db.visitorSketch(ctx, tx, click.Key, day, db.dialect.forUpdate)
+	if err != nil {
+		return err
+	}
+	sketch.Add(click.Visitor)
+	data, err := sketch.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, db.dialect.rebind(`UPDATE visitors SET sketch = ? WHERE key = ? AND day = ?`), data, key, formatTime(day))
+	return err
+}
+
+// maxClickBatchAttempts is the number of times storing a batch of clicks is
+// attempted if it fails because of concurrent transactions.
+const maxClickBatchAttempts = 3
+
+// storeClicks stores batch in a single transaction. The clicks are sorted by
+// key so that concurrent instances lock the rows of links and visitors in the
+// same order. If the transaction fails, the clicks are stored one by one so
+// that a single bad click doesn't drop the others; clicks that can't be
+// stored are logged and skipped.
+func (db SQLDB) storeClicks(ctx context.Context, batch []dbpkg.Click) error {
+	slices.SortStableFunc(batch, func(a, b dbpkg.Click) int {
+		return bytes.Compare(a.Key, b.Key)
+	})
+	err := db.storeClickBatch(ctx, batch)
+	if err == nil || len(batch) == 1 || ctx.Err() != nil {
+		return err
+	}
+	db.logger.Warn("failed storing clicks, storing them one by one", "count", len(batch), "error", err)
+	for _, click := range batch {
+		if err := db.storeClickBatch(ctx, []dbpkg.Click{click}); err != nil {
+			if ctx.Err() != nil {
+				return err
+			}
+			db.logger.Error("failed storing click, skipping it", "key", string(click.Key), "time", click.Time, "error", err)
+		}
+	}
+	return nil
+}
+
+// storeClickBatch stores batch in a single transaction, which is retried if
+// it fails because of concurrent transactions, e.g. because of a
+// serialization failure.
+func (db SQLDB) storeClickBatch(ctx context.Context, batch []dbpkg.Click) error {
+	for attempt := 1; ; attempt++ {
+		err := db.storeClicksOnce(ctx, batch)
+		if err == nil || attempt == maxClickBatchAttempts || !db.dialect.isTransient(err) {
+			return err
+		}
+		db.logger.Warn("retrying to store clicks", "count", len(batch), "attempt", attempt, "error", err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Duration(attempt) * 50 * time.Millisecond):
+		}
+	}
+}
+
+// storeClicksOnce stores batch in a single transaction.
+func (db SQLDB) storeClicksOnce(ctx context.Context, batch []dbpkg.Click) error {
+	tx, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	for _, click := range batch {
+		if err := db.storeClick(ctx, tx, click); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// clickBatchTimeout bounds storing a batch of clicks so that an unresponsive
+// database doesn't stall the queue indefinitely.
+const clickBatchTimeout = 30 * time.Second
+
+// collectClicks stores all queued clicks until the queue is closed and then
+// closes db.collected. Clicks are written in batches to reduce the number of
+// transactions under load.
+func (db SQLDB) collectClicks() {
+	defer close(db.collected)
+	for click := range db.clicks {
+		batch := []dbpkg.Click{click}
+	drain:
+		for len(batch) < 100 {
+			select {
+			case click, ok := <-db.clicks:
+				if !ok {
+					break drain
+				}
+				batch = append(batch, click)
+			default:
+				break drain
+			}
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), clickBatchTimeout)
+		if err := db.storeClicks(ctx, batch); err != nil {
+			db.logger.Error("failed storing clicks", "count", len(batch), "error", err)
+		}
+		cancel()
+	}
+}
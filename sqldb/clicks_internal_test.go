@@ -0,0 +1,41 @@
+package sqldb
+
+import (
+	"log/slog"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/makkes/shorty/assert"
+	dbpkg "github.com/makkes/shorty/db"
+)
+
+func TestBadClickDoesNotDropTheBatch(t *testing.T) {
+	db, err := OpenSQLite(filepath.Join(t.TempDir(), "shorty.sqlite"), slog.Default())
+	if err != nil {
+		t.Fatalf("failed opening DB: %v", err)
+	}
+	defer db.Close()
+	assert := assert.NewAssert(t)
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	for _, key := range []string{"a", "b", "c"} {
+		assert.Nil(db.SaveURL(t.Context(), "http://example.org", []byte(key)), "unexpected error")
+	}
+	// clicks with a visitor on b fail as its sketch can't be decoded
+	_, err = db.exec(t.Context(), `INSERT INTO visitors (key, day, sketch) VALUES (?, ?, ?)`, "b", formatTime(day), []byte("garbage"))
+	assert.Nil(err, "unexpected error")
+
+	assert.Nil(db.storeClicks(t.Context(), []dbpkg.Click{
+		{Key: []byte("c"), Time: day, Visitor: 1},
+		{Key: []byte("b"), Time: day, Visitor: 2},
+		{Key: []byte("a"), Time: day, Visitor: 3},
+	}), "unexpected error")
+	clicks := make(map[string]uint64)
+	assert.Nil(db.ListURLs(t.Context(), func(link dbpkg.Link) error {
+		clicks[link.Key] = link.Clicks
+		return nil
+	}), "unexpected error")
+	assert.Equal(clicks["a"], uint64(1), "click on a has been dropped")
+	assert.Equal(clicks["b"], uint64(0), "bad click on b has been stored")
+	assert.Equal(clicks["c"], uint64(1), "click on c has been dropped")
+}
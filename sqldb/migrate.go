@@ -0,0 +1,70 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// A migration is a list of statements that migrate the schema from the
+// previous version. migrations[i] migrates to version i+1.
+type migration []string
+
+// migrate applies all migrations of d that haven't been applied to db yet,
+// each in its own transaction. Applied versions are recorded in the table
+// schema_migrations.
+func migrate(db *sql.DB, d dialect) (err error) {
+	ctx := context.Background()
+	// session-level locks require all statements to use the same connection
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if d.lockMigrations != "" {
+		if _, err := conn.ExecContext(ctx, d.lockMigrations); err != nil {
+			return fmt.Errorf("failed acquiring migration lock: %w", err)
+		}
+		defer func() {
+			_, unlockErr := conn.ExecContext(ctx, d.unlockMigrations)
+			err = errors.Join(err, unlockErr)
+		}()
+	}
+
+	if _, err := conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		applied TEXT NOT NULL
+	)`); err != nil {
+		return err
+	}
+	var current int
+	if err := conn.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current); err != nil {
+		return err
+	}
+	if current > len(d.migrations) {
+		return fmt.Errorf("schema version %d is newer than the latest known version %d", current, len(d.migrations))
+	}
+	for idx, m := range d.migrations[current:] {
+		version := current + idx + 1
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		for _, stmt := range m {
+			if _, err := tx.Exec(stmt); err != nil {
+				tx.Rollback()
+				return fmt.Errorf("version %d: %w", version, err)
+			}
+		}
+		if _, err := tx.Exec(d.rebind(`INSERT INTO schema_migrations (version, applied) VALUES (?, ?)`), version, formatTime(time.Now())); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("version %d: %w", version, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package sqldb
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	semconv "go.opentelemetry.io/otel/semconv/v1.43.0"
+
+	dbpkg "github.com/makkes/shorty/db"
+)
+
+// migrationLockID is the key of the advisory lock serializing migrations of
+// instances starting at the same time.
+const migrationLockID = 0x73686f727479 // "shorty"
+
+var postgresDialect = dialect{
+	name:   "PostgreSQL",
+	system: semconv.DBSystemNamePostgreSQL,
+	migrations: []migration{
+		{
+			// keys are compared bytewise like in all other backends
+			`CREATE TABLE links (
+				key TEXT COLLATE "C" PRIMARY KEY,
+				url TEXT NOT NULL,
+				created TIMESTAMPTZ,
+				clicks BIGINT NOT NULL DEFAULT 0
+			)`,
+			`CREATE INDEX links_url ON links (url)`,
+			`CREATE TABLE clicks (
+				id BIGSERIAL PRIMARY KEY,
+				key TEXT COLLATE "C" NOT NULL,
+				time TIMESTAMPTZ NOT NULL,
+				referrer TEXT NOT NULL DEFAULT '',
+				browser TEXT NOT NULL DEFAULT '',
+				os TEXT NOT NULL DEFAULT '',
+				device TEXT NOT NULL DEFAULT '',
+				country TEXT NOT NULL DEFAULT '',
+				bot TEXT NOT NULL DEFAULT ''
+			)`,
+			`CREATE INDEX clicks_key_time ON clicks (key, time)`,
+			`CREATE INDEX clicks_time ON clicks (time)`,
+			`CREATE TABLE visitors (
+				key TEXT COLLATE "C" NOT NULL,
+				day TIMESTAMPTZ NOT NULL,
+				sketch BYTEA NOT NULL,
+				PRIMARY KEY (key, day)
+			)`,
+		},
+		{
+			`CREATE TABLE visitor_salts (
+				day TIMESTAMPTZ PRIMARY KEY,
+				salt BYTEA NOT NULL
+			)`,
+		},
+	},
+	rebind: rebindDollar,
+	isUniqueViolation: func(err error) bool {
+		var pgErr *pgconn.PgError
+		return errors.As(err, &pgErr) && pgErr.Code == "23505"
+	},
+	isTransient: func(err error) bool {
+		// deadlock_detected and serialization_failure
+		var pgErr *pgconn.PgError
+		return errors.As(err, &pgErr) && (pgErr.Code == "40P01" || pgErr.Code == "40001")
+	},
+	forUpdate:        " FOR UPDATE",
+	lockMigrations:   fmt.Sprintf("SELECT pg_advisory_lock(%d)", migrationLockID),
+	unlockMigrations: fmt.Sprintf("SELECT pg_advisory_unlock(%d)", migrationLockID),
+}
+
+// rebindDollar replaces the ? placeholders of query with $1, $2 and so on.
+func rebindDollar(query string) string {
+	var sb strings.Builder
+	n := 0
+	for _, r := range query {
+		if r != '?' {
+			sb.WriteRune(r)
+			continue
+		}
+		n++
+		sb.WriteString("$" + strconv.Itoa(n))
+	}
+	return sb.String()
+}
+
+// NewPostgres returns a SQLDB that uses the PostgreSQL database at
+// POSTGRES_URL. The connection pool is limited to POSTGRES_MAX_CONNS
+// connections that are replaced after POSTGRES_CONN_MAX_LIFETIME. Failures of
+// recording clicks are logged to logger.
+func NewPostgres(logger *slog.Logger) (dbpkg.DB, error) {
+	maxConns := 10
+	if s := os.Getenv("POSTGRES_MAX_CONNS"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid POSTGRES_MAX_CONNS %q", s)
+		}
+		maxConns = n
+	}
+	maxLifetime := 30 * time.Minute
+	if s := os.Getenv("POSTGRES_CONN_MAX_LIFETIME"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid POSTGRES_CONN_MAX_LIFETIME: %w", err)
+		}
+		maxLifetime = d
+	}
+	db, err := OpenPostgres(os.Getenv("POSTGRES_URL"), logger)
+	if err != nil {
+		return nil, err
+	}
+	db.db.SetMaxOpenConns(maxConns)
+	db.db.SetMaxIdleConns(maxConns)
+	db.db.SetConnMaxLifetime(maxLifetime)
+	return db, nil
+}
+
+// OpenPostgres opens the PostgreSQL database at url, which may be a URL or
+// key/value connection string. Unset parameters are taken from the
+// environment variables of libpq such as PGHOST.
+func OpenPostgres(url string, logger *slog.Logger) (SQLDB, error) {
+	return open("pgx", url, postgresDialect, logger)
+}
@@ -0,0 +1,13 @@
+package sqldb
+
+import (
+	"testing"
+
+	"github.com/makkes/shorty/assert"
+)
+
+func TestRebindDollar(t *testing.T) {
+	assert := assert.NewAssert(t)
+	assert.Equal(rebindDollar(`SELECT url FROM links WHERE key = ? AND url <> ?`), `SELECT url FROM links WHERE key = $1 AND url <> $2`, "unexpected query")
+	assert.Equal(rebindDollar(`SELECT COUNT(*) FROM links`), `SELECT COUNT(*) FROM links`, "unexpected query without placeholders")
+}
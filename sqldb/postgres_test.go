@@ -0,0 +1,148 @@
+package sqldb_test
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	embeddedpostgres "github.com/fergusstrange/embedded-postgres"
+	"github.com/makkes/shorty/assert"
+	dbpkg "github.com/makkes/shorty/db"
+	"github.com/makkes/shorty/sqldb"
+)
+
+// embeddedPostgres is started by openPostgres for the first test needing a
+// Postgres server, unless SHORTY_TEST_POSTGRES_URL is set, and stopped by
+// TestMain.
+var embeddedPostgres struct {
+	once sync.Once
+	db   *embeddedpostgres.EmbeddedPostgres
+	dir  string
+	url  string
+	err  error
+}
+
+// startEmbeddedPostgres starts a Postgres server on a free port, downloading
+// its binaries on first use.
+func startEmbeddedPostgres() (string, error) {
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		return "", err
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+	if embeddedPostgres.dir, err = os.MkdirTemp("", "shorty-postgres"); err != nil {
+		return "", err
+	}
+	var logs bytes.Buffer
+	cfg := embeddedpostgres.DefaultConfig().
+		Version(embeddedpostgres.V17).
+		Port(uint32(port)).
+		RuntimePath(embeddedPostgres.dir).
+		Logger(&logs)
+	embeddedPostgres.db = embeddedpostgres.NewDatabase(cfg)
+	if err := embeddedPostgres.db.Start(); err != nil {
+		embeddedPostgres.db = nil
+		return "", fmt.Errorf("%w\n%s", err, logs.String())
+	}
+	return cfg.GetConnectionURL() + "?sslmode=disable", nil
+}
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	if embeddedPostgres.db != nil {
+		if err := embeddedPostgres.db.Stop(); err != nil {
+			fmt.Fprintf(os.Stderr, "failed stopping Postgres: %v\n", err)
+		}
+	}
+	if embeddedPostgres.dir != "" {
+		os.RemoveAll(embeddedPostgres.dir)
+	}
+	os.Exit(code)
+}
+
+// openPostgres returns a function opening the database at
+// SHORTY_TEST_POSTGRES_URL or, if it isn't set, on an embedded server after
+// dropping all of Shorty's tables. Tests are skipped if the embedded server
+// can't be started, e.g. because its binaries can't be downloaded.
+func openPostgres(t *testing.T) func() sqldb.SQLDB {
+	url := os.Getenv("SHORTY_TEST_POSTGRES_URL")
+	if url == "" {
+		embeddedPostgres.once.Do(func() {
+			embeddedPostgres.url, embeddedPostgres.err = startEmbeddedPostgres()
+		})
+		if embeddedPostgres.err != nil {
+			t.Skipf("failed starting embedded Postgres: %v", embeddedPostgres.err)
+		}
+		url = embeddedPostgres.url
+	}
+	conn, err := sql.Open("pgx", url)
+	if err != nil {
+		t.Fatalf("failed opening DB: %v", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Exec(`DROP TABLE IF EXISTS links, clicks, visitors, visitor_salts, schema_migrations`); err != nil {
+		t.Fatalf("failed dropping tables: %v", err)
+	}
+	return func() sqldb.SQLDB {
+		db, err := sqldb.OpenPostgres(url, slog.Default())
+		if err != nil {
+			t.Fatalf("failed opening DB: %v", err)
+		}
+		return db
+	}
+}
+
+func TestPostgresLinks(t *testing.T) {
+	testLinks(t, openPostgres(t))
+}
+
+func TestPostgresClicks(t *testing.T) {
+	testClicks(t, openPostgres(t))
+}
+
+// TestPostgresConcurrentClicks records clicks on the same links through two
+// instances in opposite order, which deadlocks unless batches are sorted or
+// retried.
+func TestPostgresConcurrentClicks(t *testing.T) {
+	open := openPostgres(t)
+	instances := []sqldb.SQLDB{open(), open()}
+	keys := []string{"a", "b", "c", "d"}
+	assert := assert.NewAssert(t)
+	for _, key := range keys {
+		assert.Nil(instances[0].SaveURL(t.Context(), "http://example.org", []byte(key)), "unexpected error")
+	}
+
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	var wg sync.WaitGroup
+	for idx, db := range instances {
+		wg.Go(func() {
+			for i := range 400 {
+				key := keys[i%len(keys)]
+				if idx == 1 {
+					key = keys[len(keys)-1-i%len(keys)]
+				}
+				for db.RecordClick(t.Context(), dbpkg.Click{Key: []byte(key), Time: day, Visitor: uint64(i + 1)}) != nil {
+					time.Sleep(time.Millisecond)
+				}
+			}
+		})
+	}
+	wg.Wait()
+	for _, db := range instances {
+		assert.Nil(db.Close(), "unexpected error")
+	}
+
+	db := open()
+	defer db.Close()
+	assert.Nil(db.ListURLs(t.Context(), func(link dbpkg.Link) error {
+		assert.Equal(link.Clicks, uint64(200), "unexpected number of clicks of "+link.Key)
+		return nil
+	}), "unexpected error")
+}
@@ -0,0 +1,229 @@
+// Package sqldb persists links and clicks in a relational database. Links
+// are kept in the table links, clicks in clicks and the HyperLogLog
+// sketches of the daily visitors in visitors, along with the salts of the
+// visitor identifiers in visitor_salts, so that the data can be queried
+// using plain SQL, too. Times are stored as fixed-width RFC 3339 strings in
+// UTC, e.g. 2024-03-01T12:00:00.000000Z, which sort chronologically.
+package sqldb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.43.0"
+	"go.opentelemetry.io/otel/trace"
+
+	dbpkg "github.com/makkes/shorty/db"
+	"github.com/makkes/shorty/tracing"
+)
+
+// A SQLDB persists links in a SQL database. Clicks are recorded
+// asynchronously.
+type SQLDB struct {
+	db        *sql.DB
+	dialect   dialect
+	clicks    chan dbpkg.Click
+	collected chan struct{}
+	logger    *slog.Logger
+}
+
+var _ dbpkg.DB = SQLDB{}
+var _ dbpkg.LinkSaver = SQLDB{}
+var _ dbpkg.Analytics = SQLDB{}
+var _ dbpkg.Exporter = SQLDB{}
+var _ dbpkg.VisitorSalts = SQLDB{}
+
+// dialect covers the differences between the supported databases.
+type dialect struct {
+	name string
+	// system identifies the database in spans.
+	system     attribute.KeyValue
+	migrations []migration
+	// rebind replaces the ? placeholders of query if the database uses a
+	// different syntax.
+	rebind func(query string) string
+	// isUniqueViolation tells whether err has been caused by violating a
+	// primary key or unique constraint.
+	isUniqueViolation func(err error) bool
+	// isTransient tells whether a transaction failed with err because of
+	// concurrent transactions, e.g. by being chosen as deadlock victim, so
+	// that retrying it may succeed.
+	isTransient func(err error) bool
+	// forUpdate is appended to queries whose rows are updated within the
+	// same transaction.
+	forUpdate string
+	// lockMigrations and unlockMigrations, if set, serialize migrations
+	// across instances sharing the database.
+	lockMigrations, unlockMigrations string
+}
+
+// timeFormat is the format of all stored times.
+const timeFormat = "2006-01-02T15:04:05.000000Z"
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(timeFormat)
+}
+
+// nullTime returns NULL for the zero time.
+func nullTime(t time.Time) any {
+	if t.IsZero() {
+		return nil
+	}
+	return formatTime(t)
+}
+
+// scanTime reads a time written by formatTime or returned as time.Time by
+// the driver. NULL is read as the zero time.
+type scanTime struct {
+	time.Time
+}
+
+// Scan implements sql.Scanner.
+func (t *scanTime) Scan(src any) error {
+	var err error
+	switch v := src.(type) {
+	case nil:
+		t.Time = time.Time{}
+	case time.Time:
+		t.Time = v.UTC()
+	case string:
+		t.Time, err = time.Parse(time.RFC3339Nano, v)
+	case []byte:
+		t.Time, err = time.Parse(time.RFC3339Nano, string(v))
+	default:
+		err = fmt.Errorf("cannot scan %T into a time", src)
+	}
+	return err
+}
+
+// open opens the database and migrates its schema to the latest version.
+// Failures of recording clicks are logged to logger.
+func open(driverName, dataSourceName string, d dialect, logger *slog.Logger) (SQLDB, error) {
+	res := SQLDB{dialect: d, logger: logger}
+	db, err := sql.Open(driverName, dataSourceName)
+	if err != nil {
+		return res, fmt.Errorf("Error opening %s database: %w", d.name, err)
+	}
+	if err := migrate(db, d); err != nil {
+		db.Close()
+		return res, fmt.Errorf("Error migrating %s database: %w", d.name, err)
+	}
+	res.db = db
+	res.clicks = make(chan dbpkg.Click, 1000)
+	res.collected = make(chan struct{})
+	go res.collectClicks()
+	return res, nil
+}
+
+// Close stops recording clicks after all queued clicks have been stored and
+// closes the database. db must not be used afterwards.
+func (db SQLDB) Close() error {
+	close(db.clicks)
+	<-db.collected
+	return db.db.Close()
+}
+
+func (db SQLDB) exec(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return db.db.ExecContext(ctx, db.dialect.rebind(query), args...)
+}
+
+func (db SQLDB) query(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return db.db.QueryContext(ctx, db.dialect.rebind(query), args...)
+}
+
+var tracer = otel.Tracer("github.com/makkes/shorty/sqldb")
+
+// startSpan starts the span of the database operation op.
+func (db SQLDB) startSpan(ctx context.Context, op string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, op, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		db.dialect.system,
+		semconv.DBOperationName(op),
+	))
+}
+
+func (db SQLDB) GetURL(ctx context.Context, key []byte) (url []byte, err error) {
+	ctx, span := db.startSpan(ctx, "GetURL")
+	defer func() { tracing.End(span, err) }()
+	err = db.db.QueryRowContext(ctx, db.dialect.rebind(`SELECT url FROM links WHERE key = ?`), string(key)).Scan(&url)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return url, err
+}
+
+// saveLink stores link, failing with ErrKeyCollision if its key is used
+// unless replace is true. Replacing keeps the creation time and number of
+// clicks unless link has them set.
+func (db SQLDB) saveLink(ctx context.Context, link dbpkg.Link, replace bool) error {
+	query := `INSERT INTO links (key, url, created, clicks) VALUES (?, ?, ?, ?)`
+	if replace {
+		query += ` ON CONFLICT (key) DO UPDATE SET url = excluded.url,
+			created = COALESCE(excluded.created, links.created),
+			clicks = CASE WHEN excluded.clicks > 0 THEN excluded.clicks ELSE links.clicks END`
+	}
+	_, err := db.exec(ctx, query, link.Key, link.URL, nullTime(link.Created), int64(link.Clicks))
+	if err != nil && db.dialect.isUniqueViolation(err) {
+		return dbpkg.NewErrKeyCollision([]byte(link.Key))
+	}
+	return err
+}
+
+// SaveURL saves the given url using a key from the keybuffer as short URL.
+func (db SQLDB) SaveURL(ctx context.Context, url string, key []byte) (err error) {
+	ctx, span := db.startSpan(ctx, "SaveURL")
+	defer func() { tracing.End(span, err) }()
+	return db.saveLink(ctx, dbpkg.Link{Key: string(key), URL: url, Created: time.Now()}, false)
+}
+
+// ReplaceURL saves url under key, replacing any URL already stored under it.
+func (db SQLDB) ReplaceURL(ctx context.Context, url string, key []byte) (err error) {
+	ctx, span := db.startSpan(ctx, "ReplaceURL")
+	defer func() { tracing.End(span, err) }()
+	return db.saveLink(ctx, dbpkg.Link{Key: string(key), URL: url}, true)
+}
+
+// SaveLink saves link including its metadata. The number of clicks
+// replaces the total number of clicks recorded for the key.
+func (db SQLDB) SaveLink(ctx context.Context, link dbpkg.Link, replace bool) (err error) {
+	ctx, span := db.startSpan(ctx, "SaveLink")
+	defer func() { tracing.End(span, err) }()
+	return db.saveLink(ctx, link, replace)
+}
+
+// ListURLs iterates over all links including their creation time and total
+// number of clicks.
+func (db SQLDB) ListURLs(ctx context.Context, fn func(dbpkg.Link) error) (err error) {
+	ctx, span := db.startSpan(ctx, "ListURLs")
+	defer func() { tracing.End(span, err) }()
+	rows, err := db.query(ctx, `SELECT key, url, created, clicks FROM links ORDER BY key`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var link dbpkg.Link
+		var created scanTime
+		var clicks int64
+		if err := rows.Scan(&link.Key, &link.URL, &created, &clicks); err != nil {
+			return err
+		}
+		link.Created, link.Clicks = created.Time, uint64(clicks)
+		if err := fn(link); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (db SQLDB) GetStats(ctx context.Context) (res dbpkg.Stats, err error) {
+	ctx, span := db.startSpan(ctx, "GetStats")
+	defer func() { tracing.End(span, err) }()
+	err = db.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM links`).Scan(&res.StoredURLs)
+	return res, err
+}
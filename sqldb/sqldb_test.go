@@ -0,0 +1,129 @@
+package sqldb_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/makkes/shorty/assert"
+	dbpkg "github.com/makkes/shorty/db"
+	"github.com/makkes/shorty/sqldb"
+)
+
+// testLinks runs the tests of links against the database returned by open,
+// which must be empty on the first call and reopen the same database on
+// subsequent calls.
+func testLinks(t *testing.T, open func() sqldb.SQLDB) {
+	db := open()
+
+	assert := assert.NewAssert(t)
+	assert.Nil(db.SaveURL(t.Context(), "http://example.org", []byte("b")), "unexpected error")
+	err := db.SaveURL(t.Context(), "http://example.com", []byte("b"))
+	assert.Equal(errors.Is(err, dbpkg.ErrKeyCollision{}), true, "expected key collision")
+	assert.Nil(db.ReplaceURL(t.Context(), "http://example.net", []byte("b")), "unexpected error")
+	created := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	assert.Nil(db.SaveLink(t.Context(), dbpkg.Link{Key: "a", URL: "http://example.com", Created: created, Clicks: 42}, false), "unexpected error")
+
+	// migrations are only applied once
+	assert.Nil(db.Close(), "unexpected error")
+	db = open()
+	defer db.Close()
+
+	url, err := db.GetURL(t.Context(), []byte("b"))
+	assert.Nil(err, "unexpected error")
+	assert.Equal(string(url), "http://example.net", "URL has not been replaced")
+	url, err = db.GetURL(t.Context(), []byte("unknown"))
+	assert.Nil(err, "unexpected error")
+	assert.Nil(url, "unexpected URL for unknown key")
+	stats, err := db.GetStats(t.Context())
+	assert.Nil(err, "unexpected error")
+	assert.Equal(stats.StoredURLs, 2, "unexpected number of URLs")
+
+	var links []dbpkg.Link
+	assert.Nil(db.ListURLs(t.Context(), func(link dbpkg.Link) error {
+		links = append(links, link)
+		return nil
+	}), "unexpected error")
+	assert.Equal(len(links), 2, "unexpected number of links")
+	assert.Equal(links[0], dbpkg.Link{Key: "a", URL: "http://example.com", Created: created, Clicks: 42}, "unexpected first link")
+	assert.Equal(links[1].Key, "b", "unexpected key of second link")
+	assert.Equal(time.Since(links[1].Created) < time.Minute, true, "creation time has not been kept when replacing")
+}
+
+// testClicks is like testLinks for the tests of clicks.
+func testClicks(t *testing.T, open func() sqldb.SQLDB) {
+	db := open()
+
+	assert := assert.NewAssert(t)
+	assert.Nil(db.SaveURL(t.Context(), "http://example.org", []byte("a")), "unexpected error")
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	for _, click := range []dbpkg.Click{
+		{Key: []byte("a"), Time: day.Add(10 * time.Minute), Referrer: "example.org", Browser: "Firefox", Country: "DE", Visitor: 0xdeadbeef00000000},
+		{Key: []byte("a"), Time: day.Add(20 * time.Minute), Browser: "Firefox", Visitor: 0xdeadbeef00000000},
+		{Key: []byte("a"), Time: day.Add(3 * time.Hour), Browser: "Chrome", Visitor: 0xcafebabe00000000},
+		{Key: []byte("a"), Time: day.Add(3 * time.Hour), Browser: "Chrome", Bot: "unfurler"},
+		{Key: []byte("a"), Time: day.AddDate(0, 0, 1), Browser: "Chrome", Visitor: 0xdeadbeef00000000},
+		{Key: []byte("b"), Time: day, Browser: "Chrome"},
+	} {
+		assert.Nil(db.RecordClick(t.Context(), click), "unexpected error")
+	}
+	// closing waits for all queued clicks to be stored
+	assert.Nil(db.Close(), "unexpected error")
+	db = open()
+	defer db.Close()
+
+	hourly, err := db.GetClickStats(t.Context(), []byte("a"), day, day.AddDate(0, 0, 1), dbpkg.GranularityHour)
+	assert.Nil(err, "unexpected error")
+	assert.Equal(len(hourly), 2, "unexpected number of hourly periods")
+	assert.Equal(hourly[0].Start.Equal(day), true, "unexpected start of first period")
+	assert.Equal(hourly[0].Clicks, uint64(2), "unexpected clicks in first hour")
+	assert.Equal(hourly[0].Browsers["Firefox"], uint64(2), "unexpected browser count")
+	assert.Equal(hourly[0].Referrers["direct"], uint64(1), "unexpected direct count")
+	assert.Equal(hourly[1].Clicks, uint64(1), "unexpected clicks in second hour")
+	assert.Equal(hourly[1].Bots["unfurler"], uint64(1), "unexpected unfurler count")
+
+	daily, err := db.GetClickStats(t.Context(), []byte("a"), day, day.AddDate(0, 0, 7), dbpkg.GranularityDay)
+	assert.Nil(err, "unexpected error")
+	assert.Equal(len(daily), 2, "unexpected number of daily periods")
+	assert.Equal(daily[0].Clicks, uint64(3), "unexpected clicks on first day")
+	assert.Equal(daily[0].BotClicks, uint64(1), "unexpected bot clicks on first day")
+	assert.Equal(daily[0].UniqueVisitors, uint64(2), "unexpected unique visitors on first day")
+	assert.Equal(daily[1].UniqueVisitors, uint64(1), "unexpected unique visitors on second day")
+
+	visitors, err := db.GetUniqueVisitors(t.Context(), []byte("a"), day, day.AddDate(0, 0, 7))
+	assert.Nil(err, "unexpected error")
+	assert.Equal(visitors, uint64(2), "unexpected unique visitors")
+
+	var links []string
+	assert.Nil(db.ListURLs(t.Context(), func(link dbpkg.Link) error {
+		links = append(links, link.Key)
+		assert.Equal(link.Clicks, uint64(4), "bot clicks must not be counted in the total")
+		return nil
+	}), "unexpected error")
+	assert.Equal(strings.Join(links, " "), "a", "unexpected links")
+
+	var clicks []dbpkg.Click
+	assert.Nil(db.ForEachClick(t.Context(), day, day.Add(time.Hour), func(click dbpkg.Click) error {
+		clicks = append(clicks, click)
+		return nil
+	}), "unexpected error")
+	assert.Equal(len(clicks), 3, "unexpected number of clicks")
+	assert.Equal(string(clicks[0].Key), "a", "unexpected key of first click")
+	assert.Equal(clicks[0].Time.Equal(day.Add(10*time.Minute)), true, "unexpected time of first click")
+	assert.Equal(clicks[0].Country, "DE", "unexpected country of first click")
+	assert.Equal(string(clicks[2].Key), "b", "unexpected key of last click")
+
+	salt, err := db.VisitorSalt(t.Context(), day)
+	assert.Nil(err, "unexpected error")
+	assert.Equal(len(salt), 32, "unexpected salt length")
+	again, err := db.VisitorSalt(t.Context(), day)
+	assert.Nil(err, "unexpected error")
+	assert.Equal(string(again), string(salt), "salt must be kept for the day")
+	next, err := db.VisitorSalt(t.Context(), day.AddDate(0, 0, 1))
+	assert.Nil(err, "unexpected error")
+	assert.Equal(string(next) == string(salt), false, "salt must differ on the next day")
+	again, err = db.VisitorSalt(t.Context(), day)
+	assert.Nil(err, "unexpected error")
+	assert.Equal(string(again) == string(salt), false, "salt of the previous day must have been deleted")
+}
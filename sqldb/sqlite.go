@@ -0,0 +1,85 @@
+package sqldb
+
+import (
+	"errors"
+	"log/slog"
+	"net/url"
+	"os"
+	"path"
+
+	semconv "go.opentelemetry.io/otel/semconv/v1.43.0"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+
+	dbpkg "github.com/makkes/shorty/db"
+)
+
+// sqliteFile is the name of the SQLite database file in DB_DIR.
+const sqliteFile = "shorty.sqlite"
+
+var sqliteDialect = dialect{
+	name:   "SQLite",
+	system: semconv.DBSystemNameSQLite,
+	migrations: []migration{
+		{
+			`CREATE TABLE links (
+				key TEXT PRIMARY KEY,
+				url TEXT NOT NULL,
+				created TEXT,
+				clicks INTEGER NOT NULL DEFAULT 0
+			)`,
+			`CREATE INDEX links_url ON links (url)`,
+			`CREATE TABLE clicks (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				key TEXT NOT NULL,
+				time TEXT NOT NULL,
+				referrer TEXT NOT NULL DEFAULT '',
+				browser TEXT NOT NULL DEFAULT '',
+				os TEXT NOT NULL DEFAULT '',
+				device TEXT NOT NULL DEFAULT '',
+				country TEXT NOT NULL DEFAULT '',
+				bot TEXT NOT NULL DEFAULT ''
+			)`,
+			`CREATE INDEX clicks_key_time ON clicks (key, time)`,
+			`CREATE INDEX clicks_time ON clicks (time)`,
+			`CREATE TABLE visitors (
+				key TEXT NOT NULL,
+				day TEXT NOT NULL,
+				sketch BLOB NOT NULL,
+				PRIMARY KEY (key, day)
+			)`,
+		},
+		{
+			`CREATE TABLE visitor_salts (
+				day TEXT PRIMARY KEY,
+				salt BLOB NOT NULL
+			)`,
+		},
+	},
+	rebind: func(query string) string { return query },
+	isUniqueViolation: func(err error) bool {
+		var sqliteErr *sqlite.Error
+		return errors.As(err, &sqliteErr) &&
+			(sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY || sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE)
+	},
+	isTransient: func(err error) bool {
+		// the primary result code without the extended bits
+		var sqliteErr *sqlite.Error
+		return errors.As(err, &sqliteErr) && (sqliteErr.Code()&0xff == sqlite3.SQLITE_BUSY || sqliteErr.Code()&0xff == sqlite3.SQLITE_LOCKED)
+	},
+}
+
+// NewSQLite returns a SQLDB that uses the SQLite database shorty.sqlite in
+// DB_DIR, creating it if necessary. Failures of recording clicks are logged
+// to logger.
+func NewSQLite(logger *slog.Logger) (dbpkg.DB, error) {
+	return OpenSQLite(path.Join(os.Getenv("DB_DIR"), sqliteFile), logger)
+}
+
+// OpenSQLite opens the SQLite database at file. The database uses
+// write-ahead logging so that readers such as reporting tools don't block
+// Shorty and vice versa.
+func OpenSQLite(file string, logger *slog.Logger) (SQLDB, error) {
+	params := url.Values{"_pragma": {"journal_mode(WAL)", "busy_timeout(5000)", "synchronous(NORMAL)"}}
+	return open("sqlite", "file:"+file+"?"+params.Encode(), sqliteDialect, logger)
+}
@@ -0,0 +1,28 @@
+package sqldb_test
+
+import (
+	"log/slog"
+	"path/filepath"
+	"testing"
+
+	"github.com/makkes/shorty/sqldb"
+)
+
+func openSQLite(t *testing.T) func() sqldb.SQLDB {
+	file := filepath.Join(t.TempDir(), "shorty.sqlite")
+	return func() sqldb.SQLDB {
+		db, err := sqldb.OpenSQLite(file, slog.Default())
+		if err != nil {
+			t.Fatalf("failed opening DB: %v", err)
+		}
+		return db
+	}
+}
+
+func TestSQLiteLinks(t *testing.T) {
+	testLinks(t, openSQLite(t))
+}
+
+func TestSQLiteClicks(t *testing.T) {
+	testClicks(t, openSQLite(t))
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/makkes/shorty/events"
+	"github.com/makkes/shorty/logging"
+)
+
+// sseKeepAlive is the interval of comments sent to keep idle connections
+// open.
+const sseKeepAlive = 15 * time.Second
+
+// requireStreamToken only passes requests to next that carry token as bearer
+// token or in the 'token' parameter, as browsers' EventSource can't send
+// header fields.
+func requireStreamToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if given := r.URL.Query().Get("token"); given != "" && r.Header.Get("Authorization") == "" {
+			r = r.Clone(r.Context())
+			r.Header.Set("Authorization", "Bearer "+given)
+		}
+		requireAdmin(token, next).ServeHTTP(w, r)
+	})
+}
+
+// eventStream serves the events published on bus as Server-Sent Events.
+// Clients may filter by the 'key' and 'type' parameters and resume a stream
+// using the Last-Event-ID header or parameter.
+func eventStream(bus *events.Bus) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		filter := events.Filter{Keys: query["key"], Types: query["type"]}
+		for _, t := range filter.Types {
+			if !slices.Contains([]string{events.LinkCreated, events.LinkClicked}, t) {
+				http.Error(w, fmt.Sprintf("unknown event type %q", t), http.StatusBadRequest)
+				return
+			}
+		}
+		lastEventID := r.Header.Get("Last-Event-ID")
+		if lastEventID == "" {
+			lastEventID = query.Get("lastEventId")
+		}
+		var lastID uint64
+		if lastEventID != "" {
+			var err error
+			if lastID, err = strconv.ParseUint(lastEventID, 10, 64); err != nil {
+				http.Error(w, fmt.Sprintf("invalid last event ID %q", lastEventID), http.StatusBadRequest)
+				return
+			}
+		}
+
+		sub := bus.Subscribe(filter, lastID)
+		defer bus.Unsubscribe(sub)
+
+		rc := http.NewResponseController(w)
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+		if err := rc.Flush(); err != nil {
+			logging.FromContext(r.Context()).Warn("failed flushing event stream", "error", err)
+			return
+		}
+
+		keepAlive := time.NewTicker(sseKeepAlive)
+		defer keepAlive.Stop()
+		for {
+			var err error
+			select {
+			case <-r.Context().Done():
+				return
+			case <-keepAlive.C:
+				_, err = fmt.Fprint(w, ": keep-alive\n\n")
+			case e, ok := <-sub.C:
+				if !ok {
+					// the client has been too slow and may reconnect
+					return
+				}
+				var data []byte
+				if data, err = json.Marshal(e); err == nil {
+					_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+				}
+			}
+			if err == nil {
+				err = rc.Flush()
+			}
+			if err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/makkes/shorty/assert"
+	"github.com/makkes/shorty/db"
+	"github.com/makkes/shorty/events"
+)
+
+func TestEventStream(t *testing.T) {
+	bus := events.NewBus(10, 10)
+	srv := httptest.NewServer(eventStream(bus))
+	defer srv.Close()
+
+	sub := bus.Subscribe(events.Filter{}, 0)
+	bus.Publish(events.LinkCreated, "abc", linkCreated{URL: "http://example.org", ShortURL: "https://sho.rt/abc"})
+	bus.Publish(events.LinkCreated, "def", nil)
+	bus.Publish(events.LinkClicked, "abc", db.Click{Browser: "Firefox"})
+	first := <-sub.C
+	bus.Unsubscribe(sub)
+
+	req, _ := http.NewRequest("GET", srv.URL+"?key=abc", nil)
+	req.Header.Set("Last-Event-ID", strconv.FormatUint(first.ID, 10))
+	res, err := http.DefaultClient.Do(req)
+
+	assert := assert.NewAssert(t)
+	assert.Nil(err, "unexpected error")
+	defer res.Body.Close()
+	assert.Equal(res.StatusCode, http.StatusOK, "unexpected status code")
+	assert.Equal(res.Header.Get("Content-Type"), "text/event-stream", "unexpected content type")
+
+	bus.Publish(events.LinkCreated, "def", nil)
+	bus.Publish(events.LinkCreated, "abc", linkCreated{URL: "http://example.org", ShortURL: "https://sho.rt/abc"})
+	var lines []string
+	scanner := bufio.NewScanner(res.Body)
+	for len(lines) < 8 && scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	assert.Equal(lines[0], fmt.Sprintf("id: %d", first.ID+2), "unexpected ID of replayed event")
+	assert.Equal(lines[1], "event: link.clicked", "unexpected type of replayed event")
+	assert.Match(`^data: \{"id":[0-9]+,"type":"link.clicked",.*"key":"abc","data":\{.*"browser":"Firefox"\}\}$`, lines[2], "unexpected data of replayed event")
+	assert.Equal(lines[3], "", "events are not separated")
+	assert.Equal(lines[4], fmt.Sprintf("id: %d", first.ID+4), "unexpected ID of live event")
+	assert.Equal(lines[5], "event: link.created", "unexpected type of live event")
+	assert.Match(`"key":"abc","data":\{"url":"http://example.org","shortUrl":"https://sho.rt/abc"\}\}$`, lines[6], "unexpected data of live event")
+}
+
+func TestEventStreamValidatesParameters(t *testing.T) {
+	for _, url := range []string{"/?type=link.exploded", "/?lastEventId=abc"} {
+		req := httptest.NewRequest("GET", url, nil)
+		w := httptest.NewRecorder()
+		eventStream(events.NewBus(10, 10))(w, req)
+		assert := assert.NewAssert(t)
+		assert.Equal(w.Code, http.StatusBadRequest, "unexpected status code for "+url)
+	}
+}
+
+func TestEventStreamRequiresToken(t *testing.T) {
+	h := requireStreamToken("s3cr3t", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	for url, expected := range map[string]int{
+		"/":              http.StatusUnauthorized,
+		"/?token=wrong":  http.StatusUnauthorized,
+		"/?token=s3cr3t": http.StatusNoContent,
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+		assert := assert.NewAssert(t)
+		assert.Equal(w.Code, expected, "unexpected status code for "+url)
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer s3cr3t")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert := assert.NewAssert(t)
+	assert.Equal(w.Code, http.StatusNoContent, "bearer token must be accepted")
+}
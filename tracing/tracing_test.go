@@ -0,0 +1,77 @@
+package tracing_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/makkes/shorty/assert"
+	"github.com/makkes/shorty/tracing"
+)
+
+func TestMiddlewareContinuesPropagatedTrace(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() { _ = tp.Shutdown(t.Context()) })
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /links/{key}", func(w http.ResponseWriter, r *http.Request) {
+		_, span := otel.Tracer("test").Start(r.Context(), "lookup")
+		span.End()
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	req := httptest.NewRequest(http.MethodGet, "/links/abc", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	rr := httptest.NewRecorder()
+	tracing.Middleware(mux).ServeHTTP(rr, req)
+
+	assert := assert.NewAssert(t)
+	assert.Equal(rr.Code, http.StatusServiceUnavailable, "unexpected status code")
+	spans := recorder.Ended()
+	assert.Equal(len(spans), 2, "unexpected number of spans")
+	child, server := spans[0], spans[1]
+	assert.Equal(server.Name(), "GET /links/{key}", "unexpected span name")
+	assert.Equal(server.SpanContext().TraceID().String(), "4bf92f3577b34da6a3ce929d0e0e4736", "propagated trace has not been continued")
+	assert.Equal(server.Parent().SpanID().String(), "00f067aa0ba902b7", "unexpected parent span")
+	assert.Equal(server.Status().Code, codes.Error, "server error has not been recorded")
+	assert.Equal(child.Parent().SpanID(), server.SpanContext().SpanID(), "handler span is no child of the server span")
+	for _, attr := range server.Attributes() {
+		if attr.Key == "http.response.status_code" {
+			assert.Equal(attr.Value.AsInt64(), int64(http.StatusServiceUnavailable), "unexpected status code attribute")
+		}
+	}
+}
+
+func TestSetupWritesSpansToFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traces.jsonl")
+	shutdown, err := tracing.Setup(t.Context(), tracing.ExporterFile, path)
+	assert := assert.NewAssert(t)
+	assert.Nil(err, "unexpected error")
+
+	_, span := otel.Tracer("test").Start(t.Context(), "some operation")
+	span.End()
+	assert.Nil(shutdown(t.Context()), "unexpected error flushing spans")
+
+	data, err := os.ReadFile(path)
+	assert.Nil(err, "unexpected error")
+	assert.Match(`"Name":"some operation"`, string(data), "span has not been written")
+	assert.Match(`"Value":"shorty"`, string(data), "service name is missing")
+}
+
+func TestSetupRejectsUnknownExporter(t *testing.T) {
+	_, err := tracing.Setup(t.Context(), "jaeger", "")
+	assert := assert.NewAssert(t)
+	assert.NotNil(err, "expected error")
+	_, err = tracing.Setup(t.Context(), tracing.ExporterFile, "")
+	assert.NotNil(err, "expected error for missing path")
+}
@@ -0,0 +1,93 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"slices"
+)
+
+// An Endpoint receives the events it is subscribed to.
+type Endpoint struct {
+	// Name identifies the endpoint in the delivery log.
+	Name string `json:"name"`
+	URL  string `json:"url"`
+	// Secret is the key used to sign payloads.
+	Secret string `json:"secret"`
+	// Events lists the event types sent to the endpoint, defaulting to all.
+	Events []string `json:"events,omitempty"`
+	// IncludeBots enables sending EventLinkClicked for clicks by bots, which
+	// are skipped by default.
+	IncludeBots bool `json:"includeBots,omitempty"`
+}
+
+func (e Endpoint) subscribed(event string) bool {
+	return len(e.Events) == 0 || slices.Contains(e.Events, event)
+}
+
+// receives tells whether the event is sent to e.
+func (e Endpoint) receives(event queuedEvent) bool {
+	return e.subscribed(event.eventType) && (!event.bot || e.IncludeBots)
+}
+
+func (e Endpoint) validate() error {
+	u, err := url.Parse(e.URL)
+	if err != nil {
+		return fmt.Errorf("invalid URL: %w", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("URL %q must be an absolute http or https URL", e.URL)
+	}
+	if e.Secret == "" {
+		return errors.New("secret is empty")
+	}
+	for _, event := range e.Events {
+		if !slices.Contains(EventTypes, event) {
+			return fmt.Errorf("unknown event %q", event)
+		}
+	}
+	return nil
+}
+
+// Config is the webhook configuration.
+type Config struct {
+	Endpoints []Endpoint `json:"endpoints"`
+}
+
+// LoadConfig reads a JSON configuration from the file at path.
+func LoadConfig(path string) (Config, error) {
+	var cfg Config
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, fmt.Errorf("failed reading webhook config: %w", err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&cfg); err != nil {
+		return cfg, fmt.Errorf("failed parsing webhook config %s: %w", path, err)
+	}
+	return cfg, cfg.Validate()
+}
+
+// Validate checks the configuration for errors.
+func (cfg Config) Validate() error {
+	var errs []error
+	names := make(map[string]bool)
+	for idx, endpoint := range cfg.Endpoints {
+		if endpoint.Name == "" {
+			errs = append(errs, fmt.Errorf("endpoint %d: name is empty", idx))
+			continue
+		}
+		if names[endpoint.Name] {
+			errs = append(errs, fmt.Errorf("endpoint %q: duplicate name", endpoint.Name))
+		}
+		names[endpoint.Name] = true
+		if err := endpoint.validate(); err != nil {
+			errs = append(errs, fmt.Errorf("endpoint %q: %w", endpoint.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,99 @@
+package webhook
+
+import (
+	"context"
+
+	dbpkg "github.com/makkes/shorty/db"
+)
+
+type notifyingDB struct {
+	dbpkg.DB
+	dispatcher *Dispatcher
+}
+
+// WrapDB returns a DB that publishes EventLinkCreated for every URL saved in
+// db and EventLinkUpdated for every URL replaced in it.
+func (d *Dispatcher) WrapDB(db dbpkg.DB) dbpkg.DB {
+	return notifyingDB{DB: db, dispatcher: d}
+}
+
+// publish publishes event for the link under key. Failures are logged only as
+// the link has already been saved.
+func (db notifyingDB) publish(ctx context.Context, event, url string, key []byte) {
+	err := db.dispatcher.Publish(ctx, event, LinkData{
+		Key:      string(key),
+		URL:      url,
+		ShortURL: db.dispatcher.baseURL + string(key),
+	})
+	if err != nil {
+		db.dispatcher.logger.Error("failed publishing webhook event", "event", event, "error", err)
+	}
+}
+
+func (db notifyingDB) SaveURL(ctx context.Context, url string, key []byte) error {
+	if err := db.DB.SaveURL(ctx, url, key); err != nil {
+		return err
+	}
+	db.publish(ctx, EventLinkCreated, url, key)
+	return nil
+}
+
+func (db notifyingDB) ReplaceURL(ctx context.Context, url string, key []byte) error {
+	if err := db.DB.ReplaceURL(ctx, url, key); err != nil {
+		return err
+	}
+	db.publish(ctx, EventLinkUpdated, url, key)
+	return nil
+}
+
+// SaveLink saves link in the wrapped DB, dropping its metadata if the
+// wrapped DB doesn't support it. As it isn't known whether a replaced link
+// existed before, EventLinkUpdated is published for all replaced links.
+func (db notifyingDB) SaveLink(ctx context.Context, link dbpkg.Link, replace bool) error {
+	if saver, ok := db.DB.(dbpkg.LinkSaver); ok {
+		if err := saver.SaveLink(ctx, link, replace); err != nil {
+			return err
+		}
+	} else if replace {
+		if err := db.DB.ReplaceURL(ctx, link.URL, []byte(link.Key)); err != nil {
+			return err
+		}
+	} else if err := db.DB.SaveURL(ctx, link.URL, []byte(link.Key)); err != nil {
+		return err
+	}
+	if replace {
+		db.publish(ctx, EventLinkUpdated, link.URL, []byte(link.Key))
+	} else {
+		db.publish(ctx, EventLinkCreated, link.URL, []byte(link.Key))
+	}
+	return nil
+}
+
+type notifyingAnalytics struct {
+	dbpkg.Analytics
+	dispatcher *Dispatcher
+}
+
+// WrapAnalytics returns an Analytics that publishes EventLinkClicked for
+// every click recorded in a. The deliveries are persisted in the background
+// so that redirects don't wait for them.
+func (d *Dispatcher) WrapAnalytics(a dbpkg.Analytics) dbpkg.Analytics {
+	return notifyingAnalytics{Analytics: a, dispatcher: d}
+}
+
+func (a notifyingAnalytics) RecordClick(ctx context.Context, click dbpkg.Click) error {
+	err := a.dispatcher.Enqueue(EventLinkClicked, ClickData{
+		Key:      string(click.Key),
+		Time:     click.Time.UTC(),
+		Referrer: click.Referrer,
+		Browser:  click.Browser,
+		OS:       click.OS,
+		Device:   click.Device,
+		Country:  click.Country,
+		Bot:      click.Bot,
+	})
+	if err != nil {
+		a.dispatcher.logger.Error("failed publishing webhook event", "event", EventLinkClicked, "error", err)
+	}
+	return a.Analytics.RecordClick(ctx, click)
+}
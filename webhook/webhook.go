@@ -0,0 +1,376 @@
+// Package webhook notifies external systems about events such as the creation
+// of links by sending signed JSON payloads to configured endpoints.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	dbpkg "github.com/makkes/shorty/db"
+	"github.com/makkes/shorty/version"
+)
+
+// These constants define all event types.
+const (
+	EventLinkCreated = "link.created"
+	EventLinkUpdated = "link.updated"
+	EventLinkClicked = "link.clicked"
+)
+
+// EventTypes lists all event types.
+var EventTypes = []string{EventLinkCreated, EventLinkUpdated, EventLinkClicked}
+
+// These constants define the request headers sent along with payloads.
+const (
+	HeaderEvent     = "X-Shorty-Event"
+	HeaderDelivery  = "X-Shorty-Delivery"
+	HeaderTimestamp = "X-Shorty-Timestamp"
+	HeaderSignature = "X-Shorty-Signature"
+)
+
+// An Event is the payload sent to endpoints.
+type Event struct {
+	ID   string    `json:"id"`
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+	Data any       `json:"data"`
+}
+
+// LinkData is the data of EventLinkCreated and EventLinkUpdated.
+type LinkData struct {
+	Key      string `json:"key"`
+	URL      string `json:"url"`
+	ShortURL string `json:"shortUrl"`
+}
+
+// ClickData is the data of EventLinkClicked.
+type ClickData struct {
+	Key      string    `json:"key"`
+	Time     time.Time `json:"time"`
+	Referrer string    `json:"referrer,omitempty"`
+	Browser  string    `json:"browser,omitempty"`
+	OS       string    `json:"os,omitempty"`
+	Device   string    `json:"device,omitempty"`
+	Country  string    `json:"country,omitempty"`
+	Bot      string    `json:"bot,omitempty"`
+}
+
+// Sign returns the signature of payload sent at timestamp (in Unix seconds):
+// the hex-encoded HMAC-SHA256 of the timestamp, a dot and the payload keyed
+// with secret, prefixed by "sha256=".
+func Sign(secret string, timestamp int64, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify tells whether signature is a valid signature of payload sent at
+// timestamp.
+func Verify(secret string, timestamp int64, payload []byte, signature string) bool {
+	return hmac.Equal([]byte(signature), []byte(Sign(secret, timestamp, payload)))
+}
+
+// A Dispatcher queues events for all subscribed endpoints and delivers them
+// in the background, retrying failed deliveries with exponential backoff.
+type Dispatcher struct {
+	endpoints      []Endpoint
+	store          dbpkg.Webhooks
+	baseURL        string
+	client         *http.Client
+	clock          func() time.Time
+	wake           chan struct{}
+	queue          chan queuedEvent
+	maxAttempts    int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	logger         *slog.Logger
+}
+
+// NewDispatcher returns a Dispatcher sending events to the endpoints in cfg
+// and persisting deliveries in store. baseURL is the prefix of short URLs,
+// e.g. "https://sho.rt/".
+func NewDispatcher(cfg Config, store dbpkg.Webhooks, baseURL string) *Dispatcher {
+	return &Dispatcher{
+		endpoints:      cfg.Endpoints,
+		store:          store,
+		baseURL:        baseURL,
+		client:         &http.Client{Timeout: 10 * time.Second},
+		clock:          time.Now,
+		wake:           make(chan struct{}, 1),
+		queue:          make(chan queuedEvent, 1024),
+		maxAttempts:    10,
+		initialBackoff: 10 * time.Second,
+		maxBackoff:     time.Hour,
+		logger:         slog.Default(),
+	}
+}
+
+// SetLogger replaces the logger failures are logged to, which defaults to
+// slog.Default().
+func (d *Dispatcher) SetLogger(logger *slog.Logger) {
+	d.logger = logger
+}
+
+// SetRetries configures the number of attempts after which a delivery is
+// given up and the backoff between them, which doubles after each attempt
+// starting at initial up to max.
+func (d *Dispatcher) SetRetries(maxAttempts int, initial, max time.Duration) {
+	d.maxAttempts = maxAttempts
+	d.initialBackoff = initial
+	d.maxBackoff = max
+}
+
+// Subscribed tells whether any endpoint is subscribed to eventType.
+func (d *Dispatcher) Subscribed(eventType string) bool {
+	for _, endpoint := range d.endpoints {
+		if endpoint.subscribed(eventType) {
+			return true
+		}
+	}
+	return false
+}
+
+// A queuedEvent is an encoded event waiting to be persisted. bot is set for
+// clicks by bots.
+type queuedEvent struct {
+	eventType string
+	payload   []byte
+	time      time.Time
+	bot       bool
+}
+
+// received tells whether any endpoint receives e.
+func (d *Dispatcher) received(e queuedEvent) bool {
+	return slices.ContainsFunc(d.endpoints, func(endpoint Endpoint) bool {
+		return endpoint.receives(e)
+	})
+}
+
+// encode returns the event of type eventType carrying data.
+func (d *Dispatcher) encode(eventType string, data any) (queuedEvent, error) {
+	now := d.clock().UTC()
+	payload, err := json.Marshal(Event{
+		ID:   rand.Text(),
+		Type: eventType,
+		Time: now,
+		Data: data,
+	})
+	if err != nil {
+		return queuedEvent{}, fmt.Errorf("failed encoding %s event: %w", eventType, err)
+	}
+	click, ok := data.(ClickData)
+	return queuedEvent{eventType: eventType, payload: payload, time: now, bot: ok && click.Bot != ""}, nil
+}
+
+// Publish queues an event of type eventType for all subscribed endpoints.
+// Clicks by bots are only queued for endpoints including them.
+func (d *Dispatcher) Publish(ctx context.Context, eventType string, data any) error {
+	if !d.Subscribed(eventType) {
+		return nil
+	}
+	e, err := d.encode(eventType, data)
+	if err != nil || !d.received(e) {
+		return err
+	}
+	return d.save(ctx, e)
+}
+
+// Enqueue is like Publish but persists the deliveries in the background so
+// that callers on latency-sensitive paths don't wait for the store. Events
+// are dropped if the background queue is full.
+func (d *Dispatcher) Enqueue(eventType string, data any) error {
+	if !d.Subscribed(eventType) {
+		return nil
+	}
+	e, err := d.encode(eventType, data)
+	if err != nil || !d.received(e) {
+		return err
+	}
+	select {
+	case d.queue <- e:
+		return nil
+	default:
+		return fmt.Errorf("webhook queue is full, dropping %s event", eventType)
+	}
+}
+
+// save persists a delivery of e for every endpoint receiving it.
+func (d *Dispatcher) save(ctx context.Context, e queuedEvent) error {
+	for _, endpoint := range d.endpoints {
+		if !endpoint.receives(e) {
+			continue
+		}
+		err := d.store.SaveDelivery(ctx, &dbpkg.Delivery{
+			Endpoint:    endpoint.Name,
+			Event:       e.eventType,
+			Payload:     e.payload,
+			Status:      dbpkg.DeliveryPending,
+			Created:     e.time,
+			NextAttempt: e.time,
+		})
+		if err != nil {
+			return fmt.Errorf("failed queueing %s event for %s: %w", e.eventType, endpoint.Name, err)
+		}
+	}
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// persist saves the events passed to Enqueue until ctx is done, saving the
+// remaining ones before returning.
+func (d *Dispatcher) persist(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			d.drain(context.WithoutCancel(ctx))
+			return
+		case e := <-d.queue:
+			if err := d.save(ctx, e); err != nil {
+				d.logger.Error("failed publishing webhook event", "event", e.eventType, "error", err)
+			}
+		}
+	}
+}
+
+// drain saves all events waiting in the queue.
+func (d *Dispatcher) drain(ctx context.Context) {
+	for {
+		select {
+		case e := <-d.queue:
+			if err := d.save(ctx, e); err != nil {
+				d.logger.Error("failed publishing webhook event", "event", e.eventType, "error", err)
+			}
+		default:
+			return
+		}
+	}
+}
+
+// Run persists and delivers queued events until ctx is done.
+func (d *Dispatcher) Run(ctx context.Context) {
+	go d.persist(ctx)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		d.deliverDue(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-d.wake:
+		}
+	}
+}
+
+// deliverDue attempts all deliveries that are due.
+func (d *Dispatcher) deliverDue(ctx context.Context) {
+	for ctx.Err() == nil {
+		due, err := d.store.DueDeliveries(ctx, d.clock(), 100)
+		if err != nil {
+			d.logger.Error("failed reading webhook queue", "error", err)
+			return
+		}
+		if len(due) == 0 {
+			return
+		}
+		for _, delivery := range due {
+			if ctx.Err() != nil {
+				return
+			}
+			d.attempt(ctx, &delivery)
+			if err := d.store.SaveDelivery(ctx, &delivery); err != nil {
+				d.logger.Error("failed saving webhook delivery", "delivery", delivery.ID, "error", err)
+				return
+			}
+		}
+	}
+}
+
+func (d *Dispatcher) endpoint(name string) (Endpoint, bool) {
+	for _, endpoint := range d.endpoints {
+		if endpoint.Name == name {
+			return endpoint, true
+		}
+	}
+	return Endpoint{}, false
+}
+
+// backoff returns the time to wait after the given number of failed attempts.
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	backoff := d.initialBackoff
+	for range attempts - 1 {
+		if backoff >= d.maxBackoff/2 {
+			return d.maxBackoff
+		}
+		backoff *= 2
+	}
+	return min(backoff, d.maxBackoff)
+}
+
+// attempt sends delivery once and updates its state accordingly.
+func (d *Dispatcher) attempt(ctx context.Context, delivery *dbpkg.Delivery) {
+	now := d.clock()
+	attempt := dbpkg.DeliveryAttempt{Time: now.UTC()}
+	endpoint, ok := d.endpoint(delivery.Endpoint)
+	if ok {
+		attempt.StatusCode, attempt.Error = d.send(ctx, endpoint, delivery, now)
+	} else {
+		attempt.Error = "endpoint has been removed from the configuration"
+	}
+	delivery.Attempts = append(delivery.Attempts, attempt)
+
+	switch {
+	case attempt.Error == "":
+		delivery.Status = dbpkg.DeliverySucceeded
+		delivery.NextAttempt = time.Time{}
+	case !ok || len(delivery.Attempts) >= d.maxAttempts:
+		d.logger.Warn("giving up webhook delivery", "delivery", delivery.ID, "endpoint", delivery.Endpoint, "attempts", len(delivery.Attempts), "error", attempt.Error)
+		delivery.Status = dbpkg.DeliveryFailed
+		delivery.NextAttempt = time.Time{}
+	default:
+		delivery.NextAttempt = now.Add(d.backoff(len(delivery.Attempts))).UTC()
+	}
+}
+
+// send posts the payload of delivery to endpoint, returning the response
+// status code and a description of the error if it failed.
+func (d *Dispatcher) send(ctx context.Context, endpoint Endpoint, delivery *dbpkg.Delivery, now time.Time) (int, string) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err.Error()
+	}
+	timestamp := now.Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Shorty/"+version.Get().Version)
+	req.Header.Set(HeaderEvent, delivery.Event)
+	req.Header.Set(HeaderDelivery, strconv.FormatUint(delivery.ID, 10))
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(HeaderSignature, Sign(endpoint.Secret, timestamp, delivery.Payload))
+
+	res, err := d.client.Do(req)
+	if err != nil {
+		return 0, err.Error()
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(res.Body, 64*1024))
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.StatusCode, fmt.Sprintf("unexpected status %s", res.Status)
+	}
+	return res.StatusCode, ""
+}
@@ -0,0 +1,277 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/makkes/shorty/assert"
+	dbpkg "github.com/makkes/shorty/db"
+)
+
+type memoryStore struct {
+	mu         sync.Mutex
+	deliveries []dbpkg.Delivery
+}
+
+func (s *memoryStore) SaveDelivery(ctx context.Context, d *dbpkg.Delivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if d.ID == 0 {
+		d.ID = uint64(len(s.deliveries) + 1)
+		s.deliveries = append(s.deliveries, *d)
+		return nil
+	}
+	s.deliveries[d.ID-1] = *d
+	return nil
+}
+
+func (s *memoryStore) DueDeliveries(ctx context.Context, now time.Time, limit int) ([]dbpkg.Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var res []dbpkg.Delivery
+	for _, d := range s.deliveries {
+		if d.Status == dbpkg.DeliveryPending && !d.NextAttempt.After(now) && len(res) < limit {
+			res = append(res, d)
+		}
+	}
+	return res, nil
+}
+
+func (s *memoryStore) Deliveries(ctx context.Context, limit int) ([]dbpkg.Delivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := slices.Clone(s.deliveries)
+	slices.Reverse(res)
+	return res[:min(limit, len(res))], nil
+}
+
+// receiver records the requests it receives and responds with the given
+// status codes in turn.
+type receiver struct {
+	mu       sync.Mutex
+	statuses []int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (rec *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	body, _ := io.ReadAll(r.Body)
+	rec.requests = append(rec.requests, r)
+	rec.bodies = append(rec.bodies, body)
+	status := http.StatusNoContent
+	if len(rec.statuses) > 0 {
+		status, rec.statuses = rec.statuses[0], rec.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+func newDispatcher(t *testing.T, rec *receiver, events ...string) (*Dispatcher, *memoryStore, *time.Time) {
+	srv := httptest.NewServer(rec)
+	t.Cleanup(srv.Close)
+	store := &memoryStore{}
+	d := NewDispatcher(Config{Endpoints: []Endpoint{{Name: "test", URL: srv.URL, Secret: "s3cr3t", Events: events}}}, store, "https://sho.rt/")
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	d.clock = func() time.Time { return now }
+	return d, store, &now
+}
+
+func TestDeliversSignedPayloads(t *testing.T) {
+	rec := &receiver{}
+	d, store, now := newDispatcher(t, rec)
+
+	assert := assert.NewAssert(t)
+	assert.Nil(d.WrapDB(&nopDB{}).SaveURL(t.Context(), "http://example.org", []byte("abc")), "unexpected error")
+	d.deliverDue(context.Background())
+
+	assert.Equal(len(rec.requests), 1, "unexpected number of requests")
+	req := rec.requests[0]
+	assert.Equal(req.Header.Get("Content-Type"), "application/json", "unexpected content type")
+	assert.Equal(req.Header.Get(HeaderEvent), EventLinkCreated, "unexpected event header")
+	assert.Equal(req.Header.Get(HeaderDelivery), "1", "unexpected delivery header")
+	timestamp, err := strconv.ParseInt(req.Header.Get(HeaderTimestamp), 10, 64)
+	assert.Nil(err, "unexpected error")
+	assert.Equal(timestamp, now.Unix(), "unexpected timestamp")
+	assert.Equal(Verify("s3cr3t", timestamp, rec.bodies[0], req.Header.Get(HeaderSignature)), true, "invalid signature")
+	assert.Equal(Verify("wrong", timestamp, rec.bodies[0], req.Header.Get(HeaderSignature)), false, "signature valid with wrong secret")
+
+	var event struct {
+		Event
+		Data LinkData `json:"data"`
+	}
+	assert.Nil(json.Unmarshal(rec.bodies[0], &event), "unexpected error")
+	assert.Equal(event.Type, EventLinkCreated, "unexpected event type")
+	assert.Equal(event.ID != "", true, "missing event ID")
+	assert.Equal(event.Data, LinkData{Key: "abc", URL: "http://example.org", ShortURL: "https://sho.rt/abc"}, "unexpected data")
+
+	deliveries, _ := store.Deliveries(t.Context(), 10)
+	assert.Equal(deliveries[0].Status, dbpkg.DeliverySucceeded, "unexpected status")
+	assert.Equal(deliveries[0].Attempts[0].StatusCode, http.StatusNoContent, "unexpected status code")
+}
+
+func TestRetriesWithExponentialBackoff(t *testing.T) {
+	rec := &receiver{statuses: []int{http.StatusInternalServerError, http.StatusBadGateway}}
+	d, store, now := newDispatcher(t, rec)
+	d.SetRetries(5, time.Minute, time.Hour)
+
+	assert := assert.NewAssert(t)
+	assert.Nil(d.Publish(t.Context(), EventLinkClicked, ClickData{Key: "abc"}), "unexpected error")
+	d.deliverDue(context.Background())
+	deliveries, _ := store.Deliveries(t.Context(), 10)
+	assert.Equal(deliveries[0].Status, dbpkg.DeliveryPending, "unexpected status after first attempt")
+	assert.Equal(deliveries[0].NextAttempt, now.Add(time.Minute), "unexpected time of second attempt")
+
+	*now = now.Add(59 * time.Second)
+	d.deliverDue(context.Background())
+	assert.Equal(len(rec.requests), 1, "retried too early")
+
+	*now = now.Add(time.Second)
+	d.deliverDue(context.Background())
+	deliveries, _ = store.Deliveries(t.Context(), 10)
+	assert.Equal(deliveries[0].NextAttempt, now.Add(2*time.Minute), "unexpected time of third attempt")
+
+	*now = now.Add(2 * time.Minute)
+	d.deliverDue(context.Background())
+	deliveries, _ = store.Deliveries(t.Context(), 10)
+	assert.Equal(len(rec.requests), 3, "unexpected number of requests")
+	assert.Equal(deliveries[0].Status, dbpkg.DeliverySucceeded, "unexpected status")
+	assert.Equal(len(deliveries[0].Attempts), 3, "unexpected number of attempts")
+	assert.Equal(deliveries[0].Attempts[0].StatusCode, http.StatusInternalServerError, "unexpected status code of first attempt")
+	assert.Equal(deliveries[0].Attempts[0].Error != "", true, "missing error of first attempt")
+}
+
+func TestGivesUpAfterMaxAttempts(t *testing.T) {
+	rec := &receiver{statuses: []int{500, 500, 500}}
+	d, store, now := newDispatcher(t, rec)
+	d.SetRetries(2, time.Second, time.Second)
+
+	assert := assert.NewAssert(t)
+	assert.Nil(d.Publish(t.Context(), EventLinkCreated, LinkData{Key: "abc"}), "unexpected error")
+	for range 3 {
+		d.deliverDue(context.Background())
+		*now = now.Add(time.Second)
+	}
+	deliveries, _ := store.Deliveries(t.Context(), 10)
+	assert.Equal(len(rec.requests), 2, "unexpected number of requests")
+	assert.Equal(deliveries[0].Status, dbpkg.DeliveryFailed, "unexpected status")
+}
+
+func TestOnlySubscribedEventsAreQueued(t *testing.T) {
+	rec := &receiver{}
+	d, store, _ := newDispatcher(t, rec, EventLinkCreated)
+
+	assert := assert.NewAssert(t)
+	assert.Equal(d.Subscribed(EventLinkClicked), false, "unexpected subscription")
+	assert.Nil(d.WrapAnalytics(&nopAnalytics{}).RecordClick(t.Context(), dbpkg.Click{Key: []byte("abc")}), "unexpected error")
+	deliveries, _ := store.Deliveries(t.Context(), 10)
+	assert.Equal(len(deliveries), 0, "unsubscribed event has been queued")
+}
+
+func TestBotClicksAreOnlyQueuedForEndpointsIncludingThem(t *testing.T) {
+	rec := &receiver{}
+	d, store, _ := newDispatcher(t, rec, EventLinkClicked)
+
+	assert := assert.NewAssert(t)
+	assert.Nil(d.Publish(t.Context(), EventLinkClicked, ClickData{Key: "abc", Bot: "crawler"}), "unexpected error")
+	deliveries, _ := store.Deliveries(t.Context(), 10)
+	assert.Equal(len(deliveries), 0, "bot click has been queued")
+
+	d.endpoints[0].IncludeBots = true
+	assert.Nil(d.Publish(t.Context(), EventLinkClicked, ClickData{Key: "abc", Bot: "crawler"}), "unexpected error")
+	deliveries, _ = store.Deliveries(t.Context(), 10)
+	assert.Equal(len(deliveries), 1, "bot click has not been queued")
+}
+
+func TestWrappedDBPublishesLinkEvents(t *testing.T) {
+	rec := &receiver{}
+	d, store, _ := newDispatcher(t, rec)
+	db := d.WrapDB(&nopDB{})
+	saver, ok := db.(dbpkg.LinkSaver)
+
+	assert := assert.NewAssert(t)
+	assert.Equal(ok, true, "wrapped DB must save links")
+	assert.Nil(db.ReplaceURL(t.Context(), "http://example.org", []byte("a")), "unexpected error")
+	assert.Nil(saver.SaveLink(t.Context(), dbpkg.Link{Key: "b", URL: "http://example.org"}, false), "unexpected error")
+	assert.Nil(saver.SaveLink(t.Context(), dbpkg.Link{Key: "c", URL: "http://example.org"}, true), "unexpected error")
+	deliveries, _ := store.Deliveries(t.Context(), 10)
+	slices.Reverse(deliveries)
+	var events []string
+	for _, delivery := range deliveries {
+		events = append(events, delivery.Event)
+	}
+	assert.Equal(strings.Join(events, " "), EventLinkUpdated+" "+EventLinkCreated+" "+EventLinkUpdated, "unexpected events")
+}
+
+func TestClicksArePersistedInTheBackground(t *testing.T) {
+	rec := &receiver{}
+	d, store, _ := newDispatcher(t, rec, EventLinkClicked)
+	d.queue = make(chan queuedEvent, 1)
+	analytics := d.WrapAnalytics(&nopAnalytics{})
+
+	assert := assert.NewAssert(t)
+	assert.Nil(analytics.RecordClick(t.Context(), dbpkg.Click{Key: []byte("abc")}), "unexpected error")
+	deliveries, _ := store.Deliveries(t.Context(), 10)
+	assert.Equal(len(deliveries), 0, "click has been persisted synchronously")
+	assert.NotNil(d.Enqueue(EventLinkClicked, ClickData{Key: "abc"}), "expected error with full queue")
+
+	d.drain(t.Context())
+	d.deliverDue(context.Background())
+	deliveries, _ = store.Deliveries(t.Context(), 10)
+	assert.Equal(len(deliveries), 1, "unexpected number of deliveries")
+	assert.Equal(deliveries[0].Status, dbpkg.DeliverySucceeded, "unexpected status")
+}
+
+func TestBackoffIsCapped(t *testing.T) {
+	d := NewDispatcher(Config{}, &memoryStore{}, "")
+	d.SetRetries(100, time.Second, time.Minute)
+
+	assert := assert.NewAssert(t)
+	assert.Equal(d.backoff(1), time.Second, "unexpected first backoff")
+	assert.Equal(d.backoff(3), 4*time.Second, "unexpected third backoff")
+	assert.Equal(d.backoff(7), time.Minute, "unexpected capped backoff")
+	assert.Equal(d.backoff(99), time.Minute, "unexpected capped backoff")
+}
+
+func TestValidateConfig(t *testing.T) {
+	assert := assert.NewAssert(t)
+	valid := Endpoint{Name: "a", URL: "https://example.org/hook", Secret: "s"}
+	assert.Nil(Config{Endpoints: []Endpoint{valid}}.Validate(), "unexpected error")
+	for _, endpoints := range [][]Endpoint{
+		{{URL: valid.URL, Secret: "s"}},
+		{valid, valid},
+		{{Name: "a", URL: "ftp://example.org", Secret: "s"}},
+		{{Name: "a", URL: "/hook", Secret: "s"}},
+		{{Name: "a", URL: valid.URL}},
+		{{Name: "a", URL: valid.URL, Secret: "s", Events: []string{"link.exploded"}}},
+	} {
+		assert.NotNil(Config{Endpoints: endpoints}.Validate(), "expected error")
+	}
+}
+
+type nopDB struct{}
+
+func (nopDB) SaveURL(ctx context.Context, url string, key []byte) error     { return nil }
+func (nopDB) ReplaceURL(ctx context.Context, url string, key []byte) error  { return nil }
+func (nopDB) ListURLs(ctx context.Context, fn func(dbpkg.Link) error) error { return nil }
+func (nopDB) GetURL(ctx context.Context, key []byte) ([]byte, error)        { return nil, nil }
+func (nopDB) GetStats(ctx context.Context) (dbpkg.Stats, error)             { return dbpkg.Stats{}, nil }
+
+type nopAnalytics struct{}
+
+func (nopAnalytics) RecordClick(ctx context.Context, click dbpkg.Click) error { return nil }
+func (nopAnalytics) GetClickStats(ctx context.Context, key []byte, from, to time.Time, g dbpkg.Granularity) ([]dbpkg.ClickStats, error) {
+	return nil, nil
+}
+func (nopAnalytics) GetUniqueVisitors(ctx context.Context, key []byte, from, to time.Time) (uint64, error) {
+	return 0, nil
+}